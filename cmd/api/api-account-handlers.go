@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validate"
+)
+
+type forgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type registerPayload struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+type verifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
+// register creates a new, unverified, non admin account and emails a verification link to
+// it. The account can't log in until the link has been followed. When the address already
+// has an account its owner is emailed instead, and the response is the same, so the endpoint
+// can't be used to find out who is registered.
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var payload registerPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	user := data.User{
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Email:     payload.Email,
+		Password:  payload.Password,
+	}
+
+	if errs := validate.User(&user, true); !errs.Valid() {
+		app.validationError(w, r, errs)
+		return
+	}
+
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	switch {
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		var existing *data.User
+		existing, err = app.DB.GetUserByEmail(r.Context(), user.Email)
+		if err != nil {
+			app.repositoryError(w, r, err)
+			return
+		}
+		err = app.Accounts.SendAlreadyRegistered(r.Context(), existing)
+	case err != nil:
+		app.repositoryError(w, r, err)
+		return
+	default:
+		err = app.Accounts.SendVerification(r.Context(), &user)
+	}
+	if err != nil {
+		app.errorJSON(w, r, errors.New("could not send the confirmation email"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp = struct {
+		Message string `json:"message"`
+	}{
+		Message: "follow the link we've emailed to confirm your address, then log in",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// verifyEmail confirms an account's email address using the token from its verification email
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload verifyEmailPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(r.Context(), data.ScopeVerification, data.HashToken(payload.Token))
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired verification token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.VerifyUser(r.Context(), token.UserID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	_ = app.DB.DeleteUserTokens(r.Context(), token.UserID, data.ScopeVerification)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgotPassword emails a password reset link. It answers the same way whether or not the
+// address has an account, so it can't be used to find out who is registered.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), payload.Email)
+	if err == nil {
+		err = app.Accounts.SendPasswordReset(r.Context(), user)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("could not send the password reset email"), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var resp = struct {
+		Message string `json:"message"`
+	}{
+		Message: "if that address has an account, a password reset link has been sent to it",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// resetPassword sets a new password using a token from a reset email. The token can only be
+// used once, and the user is logged out everywhere afterwards.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// check the password before using up the token
+	if err := validate.Password(payload.Password, ""); err != nil {
+		app.validationError(w, r, validate.Errors{"password": err.Error()})
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(r.Context(), data.ScopePasswordReset, data.HashToken(payload.Token))
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), token.UserID, payload.Password)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	// any other reset links for this user are now stale
+	_ = app.DB.DeleteUserTokens(r.Context(), token.UserID, data.ScopePasswordReset)
+	_ = app.DB.RevokeUserRefreshTokens(r.Context(), token.UserID)
+	_ = app.DB.RevokeUserSessions(r.Context(), token.UserID, 0)
+
+	w.WriteHeader(http.StatusNoContent)
+}
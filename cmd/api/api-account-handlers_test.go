@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tokenInMail = regexp.MustCompile(`token=([^\s]+)`)
+
+// lastMailedToken returns the token from the last link that was emailed
+func lastMailedToken(t *testing.T) string {
+	matches := tokenInMail.FindAllStringSubmatch(sentMail.String(), -1)
+	if len(matches) == 0 {
+		t.Fatal("no token found in sent mail")
+	}
+
+	token, err := url.QueryUnescape(matches[len(matches)-1][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_application_forgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+		expectMail         bool
+	}{
+		{"known user", `{"email":"admin@example.com"}`, http.StatusAccepted, true},
+		{"known user in other capitals", `{"email":"Admin@EXAMPLE.com"}`, http.StatusAccepted, true},
+		{"unknown user", `{"email":"nobody@example.com"}`, http.StatusAccepted, false},
+		{"not json", `not json`, http.StatusBadRequest, false},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.forgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		sent := strings.Contains(sentMail.String(), "/reset-password?token=")
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %v, but it was %v", e.name, e.expectMail, sent)
+		}
+	}
+}
+
+func Test_application_resetPassword(t *testing.T) {
+	sentMail.Reset()
+
+	req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(`{"email":"admin@example.com"}`))
+	http.HandlerFunc(app.forgotPassword).ServeHTTP(httptest.NewRecorder(), req)
+
+	token := lastMailedToken(t)
+
+	var tests = []struct {
+		name               string
+		token              string
+		password           string
+		expectedStatusCode int
+	}{
+		{"password too short", token, "short", http.StatusUnprocessableEntity},
+		{"wrong token", "not-the-token", "a new password", http.StatusBadRequest},
+		{"valid", token, "a new password", http.StatusNoContent},
+		{"token already used", token, "another password", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		body := fmt.Sprintf(`{"token":%q,"password":%q}`, e.token, e.password)
+		req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.resetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_application_register(t *testing.T) {
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+		expectMail         bool
+		expectTakenMail    bool
+	}{
+		{"valid", `{"first_name":"New","last_name":"User","email":"new@example.com","password":"long enough"}`, http.StatusAccepted, true, false},
+		{"email taken", `{"first_name":"New","last_name":"User","email":"admin@example.com","password":"long enough"}`, http.StatusAccepted, false, true},
+		{"email taken in other capitals", `{"first_name":"New","last_name":"User","email":"Admin@Example.com","password":"long enough"}`, http.StatusAccepted, false, true},
+		{"bad email", `{"first_name":"New","last_name":"User","email":"not an email","password":"long enough"}`, http.StatusUnprocessableEntity, false, false},
+		{"short password", `{"first_name":"New","last_name":"User","email":"short@example.com","password":"short"}`, http.StatusUnprocessableEntity, false, false},
+		{"missing name", `{"first_name":" ","last_name":"User","email":"noname@example.com","password":"long enough"}`, http.StatusUnprocessableEntity, false, false},
+		{"unknown field", `{"first_name":"New","last_name":"User","email":"admin2@example.com","password":"long enough","is_admin":1}`, http.StatusBadRequest, false, false},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		req, _ := http.NewRequest("POST", "/register", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.register)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		sent := strings.Contains(sentMail.String(), "/verify-email?token=")
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %v, but it was %v", e.name, e.expectMail, sent)
+		}
+
+		taken := strings.Contains(sentMail.String(), "Subject: You already have an account")
+		if taken != e.expectTakenMail {
+			t.Errorf("%s: expected already registered mail sent to be %v, but it was %v", e.name, e.expectTakenMail, taken)
+		}
+	}
+}
+
+func Test_application_verifyEmail(t *testing.T) {
+	sentMail.Reset()
+
+	body := `{"first_name":"Verify","last_name":"Me","email":"verify@example.com","password":"long enough"}`
+	req, _ := http.NewRequest("POST", "/register", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.register).ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("register: expected status %d but got %d", http.StatusAccepted, rr.Code)
+	}
+
+	token := lastMailedToken(t)
+
+	login := func() int {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"verify@example.com","password":"long enough"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("login before verifying: expected status %d but got %d", http.StatusForbidden, code)
+	}
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"wrong token", "not-the-token", http.StatusBadRequest},
+		{"valid", token, http.StatusNoContent},
+		{"token already used", token, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/verify-email", strings.NewReader(fmt.Sprintf(`{"token":%q}`, e.token)))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.verifyEmail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	if code := login(); code != http.StatusOK {
+		t.Errorf("login after verifying: expected status %d but got %d", http.StatusOK, code)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/metrics"
+	"webapp/pkg/repository"
+	"webapp/pkg/throttle"
+	"webapp/pkg/validate"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v4"
@@ -18,107 +25,154 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+// the media type of an RFC 7396 JSON merge patch
+const mergePatchContentType = "application/merge-patch+json"
+
+// userPayload is the part of a user an admin can change
+type userPayload struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	IsAdmin   *int   `json:"is_admin"`
+}
+
+// newUserPayload is an account created by an admin. Unlike data.User, it carries a password.
+type newUserPayload struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	IsAdmin   int    `json:"is_admin"`
+}
+
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 
 	// read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		fmt.Println(err)
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.Logger.DebugContext(r.Context(), "reading credentials", "err", err)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
+	ip := app.clientIP(r)
+
 	// look up the user credentials in the database by email address
-	user, err := app.DB.GetUserByEmail(creds.Username)
+	user, err := app.DB.GetUserByEmail(r.Context(), creds.Username)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		user = nil
+	}
+
+	// refuse clients and accounts that are backing off before spending time on bcrypt
+	if blocked := app.Guard.Check(ip, creds.Username, user); blocked != nil {
+		app.Metrics.Login(metrics.LoginBlocked)
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	if user == nil {
+		app.loginFailed(w, r, ip, creds.Username, nil, metrics.LoginUnknownUser)
 		return
 	}
 
 	//check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.loginFailed(w, r, ip, creds.Username, user, metrics.LoginBadPassword)
 		return
 	}
 
+	// only checked once the password is known to be right, so it doesn't reveal accounts
+	if !user.Verified {
+		app.Metrics.Login(metrics.LoginUnverified)
+		app.errorJSON(w, r, errors.New("email address has not been verified"), http.StatusForbidden)
+		return
+	}
+
+	// with two-factor on, the password only earns a challenge to trade in at /auth/mfa
+	mfaEnabled, err := app.MFA.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		app.Metrics.Login(metrics.LoginMFARequired)
+		app.sendMFAChallenge(w, r, user)
+		return
+	}
+
+	// the failures are only cleared once the whole login has succeeded, so a stolen password
+	// doesn't buy unlimited guesses at the code
+	_ = app.Guard.Succeeded(r.Context(), ip, creds.Username, user)
+	app.Metrics.Login(metrics.LoginSuccess)
+
 	// generate tokens if password matches
-	tokenPairs, err := app.generateTokenPair(user)
+	tokenPairs, err := app.startSession(r, user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost", // not in prod, only for dev
-		HttpOnly: true,
-		Secure:   true,
-	})
+	app.setRefreshCookie(w, tokenPairs.RefreshToken)
 
 	// send token to user (JWT and refresh)
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
+// loginFailed counts a failed login with outcome and records it with the guard. It answers 401,
+// or 423 if that failure locked the account.
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, ip, email string, user *data.User, outcome string) {
+	app.Metrics.Login(outcome)
+
+	blocked, err := app.Guard.Failed(r.Context(), ip, email, user)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "recording failed login", "err", err)
+	}
+	if blocked != nil {
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+}
+
+// loginBlocked answers a refused login attempt with 429 or 423, and when to try again
+func (app *application) loginBlocked(w http.ResponseWriter, r *http.Request, blocked *throttle.BlockedError) {
+	w.Header().Set("Retry-After", blocked.RetryAfterHeader())
+	app.errorJSON(w, r, blocked, blocked.StatusCode())
+}
+
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	refreshToken := r.Form.Get("refresh_token")
 	claims := &Claims{}
 
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
+	_, err = jwt.ParseWithClaims(refreshToken, claims, app.Keys.Keyfunc)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renew yet"), http.StatusTooEarly)
+		app.errorJSON(w, r, errors.New("refresh token does not need renew yet"), http.StatusTooEarly)
 		return
 	}
 
-	// get user id from claims
-	userID, err := strconv.Atoi(claims.Subject)
+	// the token is valid, now make sure it has not been used or revoked
+	tokenPairs, err := app.exchangeRefreshToken(r.Context(), refreshToken, app.clientIP(r))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusUnauthorized)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
-	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
-		return
-	}
-
-	tokenPairs, err := app.generateTokenPair(user)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost", // not in prod, only for dev
-		HttpOnly: true,
-		Secure:   true,
-	})
+	app.setRefreshCookie(w, tokenPairs.RefreshToken)
 
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 }
@@ -129,51 +183,27 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 			claims := &Claims{}
 			refreshToken := cookie.Value
 
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
+			_, err := jwt.ParseWithClaims(refreshToken, claims, app.Keys.Keyfunc)
 
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
 				return
 			}
 
 			// ignoring this in deve env for now
 			// if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-			// 	app.errorJSON(w, errors.New("refresh token does not need renew yet"), http.StatusTooEarly)
+			// 	app.errorJSON(w, r, errors.New("refresh token does not need renew yet"), http.StatusTooEarly)
 			// 	return
 			// }
 
-			// get user id from claims
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
-				return
-			}
-
-			user, err := app.DB.GetUser(userID)
+			// the token is valid, now make sure it has not been used or revoked
+			tokenPairs, err := app.exchangeRefreshToken(r.Context(), refreshToken, app.clientIP(r))
 			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusUnauthorized)
 				return
 			}
 
-			tokenPairs, err := app.generateTokenPair(user)
-			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
-				return
-			}
-
-			http.SetCookie(w, &http.Cookie{
-				Name:     "__Host-refresh_token",
-				Path:     "/",
-				Value:    tokenPairs.RefreshToken,
-				Expires:  time.Now().Add(refreshTokenExpiry),
-				MaxAge:   int(refreshTokenExpiry.Seconds()),
-				SameSite: http.SameSiteStrictMode,
-				Domain:   "localhost", // not in prod, only for dev
-				HttpOnly: true,
-				Secure:   true,
-			})
+			app.setRefreshCookie(w, tokenPairs.RefreshToken)
 
 			// send back JSON
 			_ = app.writeJSON(w, http.StatusOK, tokenPairs)
@@ -181,86 +211,441 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type usersPage struct {
+	Users    []*data.User `json:"users"`
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	Links    pageLinks    `json:"links"`
+}
+
+type pageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
 }
 
+// allUsers returns one page of users. It accepts page, page_size, sort, order (asc|desc),
+// and the filters email, name (a first or last name prefix), is_admin, created_after and
+// created_before (RFC 3339 or yyyy-mm-dd).
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	q, errs := app.readUserQuery(r)
+	if len(errs) > 0 {
+		app.validationError(w, r, errs)
+		return
+	}
+
+	users, total, err := app.DB.AllUsers(r.Context(), q)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryError(w, r, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, users)
+	if users == nil {
+		users = []*data.User{}
+	}
+
+	page := usersPage{
+		Users:    users,
+		Total:    total,
+		Page:     q.Page,
+		PageSize: q.PageSize,
+		Links: pageLinks{
+			Self: pageURL(r, q.Page),
+		},
+	}
+
+	if q.Page*q.PageSize < total {
+		page.Links.Next = pageURL(r, q.Page+1)
+	}
+	if q.Page > 1 {
+		page.Links.Prev = pageURL(r, q.Page-1)
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, page)
+}
+
+// readUserQuery reads the paging, sorting and filtering parameters for allUsers, along with
+// what is wrong with any of them
+func (app *application) readUserQuery(r *http.Request) (repository.UserQuery, map[string]string) {
+	values := r.URL.Query()
+	q := repository.UserQuery{
+		Page:       1,
+		PageSize:   defaultPageSize,
+		Sort:       "last_name",
+		Email:      values.Get("email"),
+		NamePrefix: values.Get("name"),
+	}
+
+	errs := map[string]string{}
+	var err error
+
+	if v := values.Get("page"); v != "" {
+		q.Page, err = strconv.Atoi(v)
+		if err != nil || q.Page < 1 {
+			errs["page"] = "must be a positive integer"
+		}
+	}
+
+	if v := values.Get("page_size"); v != "" {
+		q.PageSize, err = strconv.Atoi(v)
+		if err != nil || q.PageSize < 1 || q.PageSize > maxPageSize {
+			errs["page_size"] = fmt.Sprintf("must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	if v := values.Get("sort"); v != "" {
+		if !repository.IsUserSortField(v) {
+			errs["sort"] = fmt.Sprintf("must be one of %s", strings.Join(repository.UserSortFields, ", "))
+		}
+		q.Sort = v
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		errs["order"] = "must be asc or desc"
+	}
+
+	if v := values.Get("is_admin"); v != "" {
+		isAdmin, err := strconv.Atoi(v)
+		if err != nil || (isAdmin != 0 && isAdmin != 1) {
+			errs["is_admin"] = "must be 0 or 1"
+		}
+		q.IsAdmin = &isAdmin
+	}
+
+	if v := values.Get("created_after"); v != "" {
+		q.CreatedAfter, err = parseTime(v)
+		if err != nil {
+			errs["created_after"] = "must be a date or an RFC 3339 time"
+		}
+	}
+
+	if v := values.Get("created_before"); v != "" {
+		q.CreatedBefore, err = parseTime(v)
+		if err != nil {
+			errs["created_before"] = "must be a date or an RFC 3339 time"
+		}
+	}
+
+	return q, errs
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagListed(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	_ = app.writeJSON(w, http.StatusOK, user)
 }
 
-func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+// replaceUser is PUT /users/{userID}: the body replaces every field of the user an admin
+// can change, so each of them must be given
+func (app *application) replaceUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	err = app.DB.UpdateUser(user)
+	var payload userPayload
+	err = app.readJSON(w, r, &payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	user := data.User{
+		ID:        userID,
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Email:     payload.Email,
+	}
+	if payload.IsAdmin != nil {
+		user.IsAdmin = *payload.IsAdmin
+	}
+
+	errs := validate.User(&user, false)
+	errs.Check(payload.IsAdmin != nil, "is_admin", "is required")
+	if !errs.Valid() {
+		app.validationError(w, r, errs)
+		return
+	}
+
+	var ok bool
+	user.Version, ok = app.ifMatchVersion(w, r, userID)
+	if !ok {
+		return
+	}
+
+	err = app.DB.UpdateUser(r.Context(), user)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	app.writeUser(w, r, userID)
+}
+
+// patchUser is PATCH /users/{userID}, an RFC 7396 JSON merge patch: only the fields in the
+// body are changed, and the rest of the user is left alone
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchContentType && mediaType != "application/json" {
+		app.errorJSON(w, r, fmt.Errorf("patches must be sent as %s", mergePatchContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	err = app.readJSON(w, r, &patch)
+	if err != nil || patch == nil {
+		app.errorJSON(w, r, errors.New("a merge patch must be a JSON object"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	// the patch is checked against the user as read here, so with If-Match it is only applied
+	// to that version of them
+	var version int
+	if header := r.Header.Get("If-Match"); header != "" {
+		if !etagListed(header, userETag(user), false) {
+			app.errorJSON(w, r, repository.ErrStale, http.StatusPreconditionFailed)
+			return
+		}
+		version = user.Version
+	}
+
+	update, errs := mergeUserPatch(user, patch)
+	if !errs.Valid() {
+		app.validationError(w, r, errs)
+		return
+	}
+	update.Version = version
+
+	if !update.IsEmpty() {
+		err = app.DB.PatchUser(r.Context(), userID, update)
+		if err != nil {
+			app.repositoryError(w, r, err)
+			return
+		}
+	}
+
+	app.writeUser(w, r, userID)
+}
+
+// mergeUserPatch applies a merge patch to user and checks the result. It returns an update
+// holding the patched fields, normalised, along with what is wrong with any of them.
+func mergeUserPatch(user *data.User, patch map[string]json.RawMessage) (repository.UserUpdate, validate.Errors) {
+	var update repository.UserUpdate
+	errs := validate.Errors{}
+
+	text := map[string]*string{
+		"first_name": &user.FirstName,
+		"last_name":  &user.LastName,
+		"email":      &user.Email,
+	}
+
+	for field, value := range patch {
+		// null removes a member, which none of these fields allow
+		if string(value) == "null" {
+			errs.Add(field, "cannot be removed")
+			continue
+		}
+
+		switch field {
+		case "first_name", "last_name", "email":
+			if err := json.Unmarshal(value, text[field]); err != nil {
+				errs.Add(field, "must be a string")
+			}
+		case "is_admin":
+			if err := json.Unmarshal(value, &user.IsAdmin); err != nil {
+				errs.Add(field, "must be 0 or 1")
+			}
+		default:
+			errs.Add(field, "cannot be changed")
+		}
+	}
+
+	if !errs.Valid() {
+		return update, errs
+	}
+
+	// check the user as it would be after the patch, but only report the patched fields
+	for field, msg := range validate.User(user, false) {
+		if _, ok := patch[field]; ok {
+			errs.Add(field, msg)
+		}
+	}
+
+	if _, ok := patch["first_name"]; ok {
+		update.FirstName = &user.FirstName
+	}
+	if _, ok := patch["last_name"]; ok {
+		update.LastName = &user.LastName
+	}
+	if _, ok := patch["email"]; ok {
+		update.Email = &user.Email
+	}
+	if _, ok := patch["is_admin"]; ok {
+		update.IsAdmin = &user.IsAdmin
+	}
+
+	return update, errs
+}
+
+// writeUser answers with a user as it is now stored, and their new ETag, after a change to them
+func (app *application) writeUser(w http.ResponseWriter, r *http.Request, userID int) {
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	_ = app.writeJSON(w, http.StatusOK, user)
 }
 
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	version, ok := app.ifMatchVersion(w, r, userID)
+	if !ok {
+		return
+	}
+
+	err = app.DB.DeleteUser(r.Context(), userID, version)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unlockUser lets an admin clear a lockout, along with the account's failed logins
+func (app *application) unlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
 		return
 	}
 
-	err = app.DB.DeleteUser(userID)
+	err = app.Guard.Unlock(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// insertUser lets an admin create an account, which starts out verified: an admin vouches
+// for the accounts they create
 func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var payload newUserPayload
+	err := app.readJSON(w, r, &payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	user := data.User{
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Email:     payload.Email,
+		Password:  payload.Password,
+		IsAdmin:   payload.IsAdmin,
+		Verified:  true,
+	}
+
+	if errs := validate.User(&user, true); !errs.Valid() {
+		app.validationError(w, r, errs)
+		return
+	}
+
+	_, err = app.DB.InsertUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// jwks publishes the public verification keys, so other services can check our access tokens
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, app.Keys.JWKS())
+}
+
+// setRefreshCookie hands the refresh token to browser clients in an http only cookie
+func (app *application) setRefreshCookie(w http.ResponseWriter, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "__Host-refresh_token",
+		Path:     "/",
+		Value:    refreshToken,
+		Expires:  time.Now().Add(refreshTokenExpiry),
+		MaxAge:   int(refreshTokenExpiry.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain:   "localhost", // not in prod, only for dev
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
+	// revoke the refresh token on our side too, so that a copy of the cookie is useless
+	if cookie, err := r.Cookie("__Host-refresh_token"); err == nil {
+		_ = app.revokeRefreshToken(r.Context(), cookie.Value)
+	}
+
 	delCookie := http.Cookie{
 		Name:     "__Host-refresh_token",
 		Path:     "/",
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/throttle"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -20,6 +24,7 @@ func Test_application_authenticate(t *testing.T) {
 		expectedStatusCode int
 	}{
 		{"valid user", `{"email":"admin@example.com","password":"secret"}`, http.StatusOK},
+		{"email in other capitals", `{"email":"Admin@EXAMPLE.com","password":"secret"}`, http.StatusOK},
 		{"not json", `not json`, http.StatusUnauthorized},
 		{"empty json", `{}`, http.StatusUnauthorized},
 		{"empty email", `{"email":""}`, http.StatusUnauthorized},
@@ -42,6 +47,92 @@ func Test_application_authenticate(t *testing.T) {
 	}
 }
 
+func Test_application_authenticateLockout(t *testing.T) {
+	// a guard of our own, so the failures here don't leak into other tests
+	defer func(g *throttle.Guard) { app.Guard = g }(app.Guard)
+	app.Guard = throttle.NewGuard(app.DB)
+	app.Guard.MaxFailures = 3
+	app.Guard.Accounts = throttle.NewLimiter(100, time.Second, time.Minute)
+
+	id, _ := app.DB.InsertUser(context.Background(), data.User{Email: "lockout@example.com", Password: "secret", Verified: true})
+
+	var tests = []struct {
+		name               string
+		password           string
+		expectedStatusCode int
+	}{
+		{"first wrong password", "wrong", http.StatusUnauthorized},
+		{"second wrong password", "wrong", http.StatusUnauthorized},
+		{"third wrong password locks", "wrong", http.StatusLocked},
+		{"right password while locked", "secret", http.StatusLocked},
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"email":"lockout@example.com","password":%q}`, password)
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, e := range tests {
+		rr := login(e.password)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusLocked && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+
+	// an admin unlocks the account
+	req, _ := http.NewRequest("POST", "/", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", strconv.Itoa(id))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.unlockUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unlock: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+
+	if rr := login("secret"); rr.Code != http.StatusOK {
+		t.Errorf("login after unlock: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func Test_application_authenticateThrottled(t *testing.T) {
+	defer func(g *throttle.Guard) { app.Guard = g }(app.Guard)
+	app.Guard = throttle.NewGuard(app.DB)
+
+	// the default limits give an address three free failures, then back off
+	var expected = []int{
+		http.StatusUnauthorized,
+		http.StatusUnauthorized,
+		http.StatusUnauthorized,
+		http.StatusUnauthorized,
+		http.StatusTooManyRequests,
+	}
+
+	for i, status := range expected {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"guess@example.com","password":"guess"}`))
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+		if rr.Code != status {
+			t.Errorf("attempt %d: expected status %d but got %d", i+1, status, rr.Code)
+		}
+
+		if status == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("attempt %d: expected Retry-After 1 but got %q", i+1, rr.Header().Get("Retry-After"))
+		}
+	}
+}
+
 func Test_application_refresh(t *testing.T) {
 	var tests = []struct {
 		name               string
@@ -69,7 +160,7 @@ func Test_application_refresh(t *testing.T) {
 			if e.resetRefreshTime {
 				refreshTokenExpiry = time.Second * 1
 			}
-			tokens, _ := app.generateTokenPair(&testUser)
+			tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 			tkn = tokens.RefreshToken
 		} else {
 			tkn = e.token
@@ -97,6 +188,8 @@ func Test_application_refresh(t *testing.T) {
 
 // one test to test multiple handlers
 func Test_application_userHandlers(t *testing.T) {
+	useFreshDB(t)
+
 	var tests = []struct {
 		name               string
 		method             string
@@ -107,40 +200,77 @@ func Test_application_userHandlers(t *testing.T) {
 	}{
 		{"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
 		{"getUser valid", "GET", "", "1", app.getUser, http.StatusOK},
-		{"getUser invalid", "GET", "", "0", app.getUser, http.StatusBadRequest},
+		{"getUser unknown user", "GET", "", "0", app.getUser, http.StatusNotFound},
 		{"getUser bad url param", "GET", "", "y", app.getUser, http.StatusBadRequest},
 
 		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
 		{"deleteUser bad url param", "DELETE", "", "y", app.deleteUser, http.StatusBadRequest},
 
+		{"unlockUser", "POST", "", "1", app.unlockUser, http.StatusNoContent},
+		{"unlockUser unknown user", "POST", "", "99", app.unlockUser, http.StatusNotFound},
+		{"unlockUser bad url param", "POST", "", "y", app.unlockUser, http.StatusBadRequest},
+
 		{
-			"updateUser valid",
-			"PATCH",
-			`{"id":1,"first_name":"administrator","last_name":"user", "email":"admin@example.com"}`,
-			"",
-			app.updateUser,
-			http.StatusNoContent,
+			"replaceUser valid",
+			"PUT",
+			`{"first_name":"administrator","last_name":"user", "email":"admin@example.com", "is_admin":1}`,
+			"1",
+			app.replaceUser,
+			http.StatusOK,
 		},
 		{
-			"updateUser invalid",
-			"PATCH",
-			`{"id":2,"first_name":"administrator","last_name":"user", "email":"admin@example.com"}`,
-			"",
-			app.updateUser,
+			"replaceUser unknown user",
+			"PUT",
+			`{"first_name":"nobody","last_name":"user", "email":"nobody@example.com", "is_admin":0}`,
+			"99",
+			app.replaceUser,
+			http.StatusNotFound,
+		},
+		{
+			"replaceUser missing is_admin",
+			"PUT",
+			`{"first_name":"administrator","last_name":"user", "email":"admin@example.com"}`,
+			"1",
+			app.replaceUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"replaceUser empty email",
+			"PUT",
+			`{"first_name":"administrator","last_name":"user", "email":"", "is_admin":1}`,
+			"1",
+			app.replaceUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"replaceUser invalid json",
+			"PUT",
+			`{first_name:"administrator","last_name":"user", "email":"admin@example.com", "is_admin":1}`,
+			"1",
+			app.replaceUser,
 			http.StatusBadRequest,
 		},
 		{
-			"updateUser invalid json",
-			"PATCH",
-			`{"id":1,first_name:"administrator","last_name":"user", "email":"admin@example.com"}`,
-			"",
-			app.updateUser,
+			"replaceUser bad url param",
+			"PUT",
+			`{"first_name":"administrator","last_name":"user", "email":"admin@example.com", "is_admin":1}`,
+			"y",
+			app.replaceUser,
 			http.StatusBadRequest,
 		},
+		{"patchUser valid", "PATCH", `{"last_name":"user"}`, "1", app.patchUser, http.StatusOK},
+		{"patchUser empty patch", "PATCH", `{}`, "1", app.patchUser, http.StatusOK},
+		{"patchUser unknown user", "PATCH", `{"last_name":"user"}`, "99", app.patchUser, http.StatusNotFound},
+		{"patchUser blank name", "PATCH", `{"first_name":"  "}`, "1", app.patchUser, http.StatusUnprocessableEntity},
+		{"patchUser null field", "PATCH", `{"email":null}`, "1", app.patchUser, http.StatusUnprocessableEntity},
+		{"patchUser read only field", "PATCH", `{"verified":false}`, "1", app.patchUser, http.StatusUnprocessableEntity},
+		{"patchUser wrong type", "PATCH", `{"is_admin":"yes"}`, "1", app.patchUser, http.StatusUnprocessableEntity},
+		{"patchUser not an object", "PATCH", `["first_name"]`, "1", app.patchUser, http.StatusBadRequest},
+		{"patchUser bad url param", "PATCH", `{"last_name":"user"}`, "y", app.patchUser, http.StatusBadRequest},
 		{
 			"insertUser valid",
 			"PUT",
-			`{"first_name":"me","last_name":"who", "email":"me@example.com"}`,
+			`{"first_name":"me","last_name":"who", "email":"me@example.com", "password":"long enough"}`,
 			"",
 			app.insertUser,
 			http.StatusNoContent,
@@ -153,6 +283,38 @@ func Test_application_userHandlers(t *testing.T) {
 			app.insertUser,
 			http.StatusBadRequest,
 		},
+		{
+			"insertUser duplicate email",
+			"PUT",
+			`{"first_name":"me","last_name":"who", "email":"Admin@Example.com", "password":"long enough"}`,
+			"",
+			app.insertUser,
+			http.StatusConflict,
+		},
+		{
+			"insertUser no password",
+			"PUT",
+			`{"first_name":"me","last_name":"who", "email":"nopass@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insertUser bad email",
+			"PUT",
+			`{"first_name":"me","last_name":"who", "email":"me at example.com", "password":"long enough"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insertUser bad is_admin",
+			"PUT",
+			`{"first_name":"me","last_name":"who", "email":"boss@example.com", "password":"long enough", "is_admin":7}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
 		{
 			"insertUser bad json",
 			"PUT",
@@ -170,6 +332,9 @@ func Test_application_userHandlers(t *testing.T) {
 		} else {
 			req, _ = http.NewRequest(e.method, "/", strings.NewReader(e.json))
 		}
+		if e.method == "PATCH" {
+			req.Header.Set("Content-Type", mergePatchContentType)
+		}
 
 		if e.paramID != "" {
 			chiCtx := chi.NewRouteContext()
@@ -189,6 +354,53 @@ func Test_application_userHandlers(t *testing.T) {
 	}
 }
 
+func Test_application_patchUser(t *testing.T) {
+	useFreshDB(t)
+
+	id, err := app.DB.InsertUser(context.Background(), data.User{FirstName: "Patch", LastName: "Me", Email: "patch@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name        string
+		contentType string
+		json        string
+		expectCode  int
+		expectUser  data.User
+	}{
+		{"first name only", "application/merge-patch+json", `{"first_name":" Patched "}`, http.StatusOK, data.User{FirstName: "Patched", LastName: "Me", Email: "patch@example.com"}},
+		{"email and admin", "application/merge-patch+json; charset=utf-8", `{"email":"patched@EXAMPLE.com","is_admin":1}`, http.StatusOK, data.User{FirstName: "Patched", LastName: "Me", Email: "patched@example.com", IsAdmin: 1}},
+		{"plain json", "application/json", `{"last_name":"Again"}`, http.StatusOK, data.User{FirstName: "Patched", LastName: "Again", Email: "patched@example.com", IsAdmin: 1}},
+		{"taken email", "application/merge-patch+json", `{"email":"admin@example.com"}`, http.StatusConflict, data.User{FirstName: "Patched", LastName: "Again", Email: "patched@example.com", IsAdmin: 1}},
+		{"bad admin flag", "application/merge-patch+json", `{"is_admin":2}`, http.StatusUnprocessableEntity, data.User{FirstName: "Patched", LastName: "Again", Email: "patched@example.com", IsAdmin: 1}},
+		{"form content type", "application/x-www-form-urlencoded", `first_name=Nope`, http.StatusUnsupportedMediaType, data.User{FirstName: "Patched", LastName: "Again", Email: "patched@example.com", IsAdmin: 1}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(e.json))
+		req.Header.Set("Content-Type", e.contentType)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.patchUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectCode, rr.Code)
+		}
+
+		// fields left out of the patch keep their values
+		user, _ := app.DB.GetUser(context.Background(), id)
+		if user.FirstName != e.expectUser.FirstName || user.LastName != e.expectUser.LastName || user.Email != e.expectUser.Email || user.IsAdmin != e.expectUser.IsAdmin {
+			t.Errorf("%s: expected %s %s <%s> admin %d, but got %s %s <%s> admin %d", e.name,
+				e.expectUser.FirstName, e.expectUser.LastName, e.expectUser.Email, e.expectUser.IsAdmin,
+				user.FirstName, user.LastName, user.Email, user.IsAdmin)
+		}
+	}
+}
+
 func Test_application_refreshUsingCookie(t *testing.T) {
 	testUser := data.User{
 		ID:        1,
@@ -197,7 +409,7 @@ func Test_application_refreshUsingCookie(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	testCookie := &http.Cookie{
 		Name:     "__Host-refresh_token",
@@ -278,3 +490,187 @@ func Test_application_deleteRefreshCookie(t *testing.T) {
 		t.Error("__Host-refresh_token cookie not found")
 	}
 }
+
+func Test_application_refreshTokenReuse(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
+
+	exchange := func(refreshToken string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: refreshToken})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the first exchange rotates the token
+	rr := exchange(tokens.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first exchange: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var rotated string
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "__Host-refresh_token" {
+			rotated = c.Value
+		}
+	}
+	if rotated == "" || rotated == tokens.RefreshToken {
+		t.Fatal("expected a new refresh token after the first exchange")
+	}
+
+	// replaying the original token is rejected
+	rr = exchange(tokens.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed token: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// and the replay revoked the rest of the family, including the rotated token
+	rr = exchange(rotated)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("rotated token after reuse: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func Test_application_logoutRevokesRefreshToken(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
+	cookie := &http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken}
+
+	req, _ := http.NewRequest("GET", "/logout", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.deleteRefreshCookie).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("logout: expected status %d but got %d", http.StatusAccepted, rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func Test_application_jwks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.jwks)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong status, expected %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// the test app signs with the HMAC secret, which must never be published
+	if !strings.Contains(rr.Body.String(), `"keys":[]`) {
+		t.Errorf("expected an empty key set, got %s", rr.Body.String())
+	}
+}
+
+func Test_application_allUsersQuery(t *testing.T) {
+	useFreshDB(t)
+
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedTotal      int
+		expectNext         bool
+		expectPrev         bool
+	}{
+		{"defaults", "", http.StatusOK, 1, false, false},
+		{"filter by email", "?email=ADMIN@example.com", http.StatusOK, 1, false, false},
+		{"filter by name prefix", "?name=adm", http.StatusOK, 1, false, false},
+		{"filter excludes", "?is_admin=0", http.StatusOK, 0, false, false},
+		{"created range", "?created_after=2022-01-01&created_before=2023-01-01T00:00:00Z", http.StatusOK, 1, false, false},
+		{"second page", "?page=2&page_size=1&sort=email&order=desc", http.StatusOK, 1, false, true},
+		{"bad page", "?page=0", http.StatusUnprocessableEntity, 0, false, false},
+		{"page size too big", "?page_size=1000", http.StatusUnprocessableEntity, 0, false, false},
+		{"bad sort", "?sort=password", http.StatusUnprocessableEntity, 0, false, false},
+		{"bad order", "?order=sideways", http.StatusUnprocessableEntity, 0, false, false},
+		{"bad is_admin", "?is_admin=2", http.StatusUnprocessableEntity, 0, false, false},
+		{"bad date", "?created_after=yesterday", http.StatusUnprocessableEntity, 0, false, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.allUsers)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var page usersPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Errorf("%s: could not decode response: %s", e.name, err)
+			continue
+		}
+
+		if page.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, page.Total)
+		}
+
+		if (page.Links.Next != "") != e.expectNext {
+			t.Errorf("%s: unexpected next link %q", e.name, page.Links.Next)
+		}
+
+		if (page.Links.Prev != "") != e.expectPrev {
+			t.Errorf("%s: unexpected prev link %q", e.name, page.Links.Prev)
+		}
+
+		if e.expectPrev && !strings.Contains(page.Links.Prev, "page=1") {
+			t.Errorf("%s: prev link does not point at page 1: %s", e.name, page.Links.Prev)
+		}
+	}
+}
+
+func Test_application_cancelledRequest(t *testing.T) {
+	// a client that has gone away cancels the request context, which must reach the repository
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, chiCtx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.getUser)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code == http.StatusOK {
+		t.Error("expected getUser to fail once the request context was cancelled")
+	}
+
+	// the cancellation is the server's business, the client gets a plain 500
+	if rr.Code != http.StatusInternalServerError || strings.Contains(rr.Body.String(), context.Canceled.Error()) {
+		t.Errorf("expected a 500 without the error's text, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/images"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// how long the image urls handed out by the api keep working
+var imageURLExpiry = 15 * time.Minute
+
+// userImages lists a user's images, newest first, with a signed url for every variant
+func (app *application) userImages(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	userImages, err := app.Images.Images(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	if userImages == nil {
+		userImages = []*data.UserImage{}
+	}
+
+	err = app.Images.WithURLs(r.Context(), userImages, imageURLExpiry)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, userImages, "images")
+}
+
+// activateUserImage makes one of a user's earlier images their profile picture again
+func (app *application) activateUserImage(w http.ResponseWriter, r *http.Request) {
+	app.changeUserImage(w, r, app.Images.Activate)
+}
+
+// deleteUserImage deletes one of a user's images, and its stored files
+func (app *application) deleteUserImage(w http.ResponseWriter, r *http.Request) {
+	app.changeUserImage(w, r, app.Images.Delete)
+}
+
+func (app *application) changeUserImage(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, imageID int) error) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	imageID, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = change(r.Context(), userID, imageID)
+	if errors.Is(err, images.ErrNotFound) {
+		app.errorJSON(w, r, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
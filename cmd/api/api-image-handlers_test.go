@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_application_userImages(t *testing.T) {
+	ctx := context.Background()
+	id, _ := app.DB.InsertUser(ctx, data.User{FirstName: "Image", LastName: "History", Email: "history@example.com", Password: "secret", Verified: true})
+	user, _ := app.DB.GetUser(ctx, id)
+
+	var imageIDs []int
+	for _, name := range []string{"old", "new"} {
+		key := fmt.Sprintf("users/%d/%s.png", id, name)
+		_ = app.Storage.Put(ctx, key, strings.NewReader(name), "image/png")
+		imageID, _ := app.DB.InsertUserImage(ctx, data.UserImage{
+			UserID:   id,
+			FileName: key,
+			Variants: []data.ImageVariant{{Variant: "original", FileName: key}},
+		})
+		imageIDs = append(imageIDs, imageID)
+	}
+	oldID, newID := imageIDs[0], imageIDs[1]
+
+	tokens, err := app.generateTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := app.routes()
+
+	send := func(method, url, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// list, newest first, with urls
+	rr := send("GET", fmt.Sprintf("/users/%d/images", id), tokens.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var listed struct {
+		Images []data.UserImage `json:"images"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed.Images) != 2 || listed.Images[0].ID != newID || !listed.Images[0].Active {
+		t.Fatalf("list: expected image %d first and active, got %+v", newID, listed.Images)
+	}
+	if u := listed.Images[0].Variants[0].URL; !strings.HasPrefix(u, app.WebURL+"/images/") {
+		t.Errorf("list: expected a signed url, got %q", u)
+	}
+
+	// switch back to the old one
+	if rr := send("POST", fmt.Sprintf("/users/%d/images/%d/activate", id, oldID), tokens.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("activate: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+	if u, _ := app.DB.GetUser(ctx, id); u.ProfilePic.ID != oldID {
+		t.Errorf("activate: expected image %d to be active, got %d", oldID, u.ProfilePic.ID)
+	}
+
+	// delete the new one, and its file
+	if rr := send("DELETE", fmt.Sprintf("/users/%d/images/%d", id, newID), tokens.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+	if _, err := app.Storage.Get(ctx, fmt.Sprintf("users/%d/new.png", id)); err == nil {
+		t.Error("delete: expected the stored file to be removed")
+	}
+
+	// an image that is gone, or someone else's, is not found
+	if rr := send("DELETE", fmt.Sprintf("/users/%d/images/%d", id, newID), tokens.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("delete again: expected status %d but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := send("GET", "/users/1/images", tokens.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("another user's images: expected status %d but got %d", http.StatusForbidden, rr.Code)
+	}
+}
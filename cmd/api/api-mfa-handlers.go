@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// the audience of mfa challenge tokens, so they can't pass for access tokens
+const mfaTokenAudience = "mfa"
+
+var mfaTokenExpiry = 5 * time.Minute
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type mfaLoginPayload struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type mfaCodePayload struct {
+	Code string `json:"code"`
+}
+
+type mfaEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// sendMFAChallenge answers a correct password for a user with two-factor on. The challenge
+// token proves the password step was passed, and is traded for a token pair at /auth/mfa.
+func (app *application) sendMFAChallenge(w http.ResponseWriter, r *http.Request, user *data.User) {
+	claims := jwt.MapClaims{}
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = mfaTokenAudience
+	claims["iss"] = app.Domain
+	claims["exp"] = time.Now().Add(mfaTokenExpiry).Unix()
+
+	token, err := app.Keys.Sign(claims)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, mfaChallenge{MFARequired: true, MFAToken: token})
+}
+
+// authenticateMFA is the second login step for users with two-factor on. It takes the challenge
+// token from authenticate and a code from the user's app, or a recovery code.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var payload mfaLoginPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(payload.MFAToken, claims, app.Keys.Keyfunc)
+	if err != nil || claims.Issuer != app.Domain || !claims.VerifyAudience(mfaTokenAudience, true) {
+		app.errorJSON(w, r, errors.New("invalid or expired mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	ip := app.clientIP(r)
+
+	// wrong codes count as failed logins, so guessing them is throttled like passwords
+	if blocked := app.Guard.Check(ip, user.Email, user); blocked != nil {
+		app.Metrics.Login(metrics.LoginBlocked)
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	err = app.MFA.Verify(r.Context(), user.ID, payload.Code)
+	if errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, mfa.ErrNotEnrolled) {
+		app.loginFailed(w, r, ip, user.Email, user, metrics.LoginBadMFACode)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.Guard.Succeeded(r.Context(), ip, user.Email, user)
+	app.Metrics.Login(metrics.LoginSuccess)
+
+	tokenPairs, err := app.startSession(r, user)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.setRefreshCookie(w, tokenPairs.RefreshToken)
+
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
+
+// mfaStatus tells the logged in user whether they have two-factor on
+func (app *application) mfaStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.userIDFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	enabled, err := app.MFA.Enabled(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var resp = struct {
+		Enabled bool `json:"enabled"`
+	}{
+		Enabled: enabled,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
+
+// beginMFA starts two-factor enrollment, returning the secret to add to an authenticator app
+func (app *application) beginMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.userIDFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	secret, uri, err := app.MFA.Begin(r.Context(), user)
+	if errors.Is(err, mfa.ErrAlreadyEnabled) {
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, mfaEnrollment{Secret: secret, ProvisioningURI: uri})
+}
+
+// confirmMFA turns two-factor on with a first code from the app, and returns the recovery codes
+func (app *application) confirmMFA(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(userID int, code string) (any, error) {
+		codes, err := app.MFA.Confirm(r.Context(), userID, code)
+		return recoveryCodes{RecoveryCodes: codes}, err
+	})
+}
+
+// regenerateRecoveryCodes swaps the user's recovery codes for new ones
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(userID int, code string) (any, error) {
+		codes, err := app.MFA.RegenerateRecoveryCodes(r.Context(), userID, code)
+		return recoveryCodes{RecoveryCodes: codes}, err
+	})
+}
+
+// disableMFA turns two-factor off, which takes a current code
+func (app *application) disableMFA(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(userID int, code string) (any, error) {
+		return nil, app.MFA.Disable(r.Context(), userID, code)
+	})
+}
+
+// withMFACode reads a code from the body for the logged in user, runs fn with it, and writes
+// its result, or 204 if there is none. Wrong codes count as failed logins, like at /auth/mfa,
+// so someone holding a stolen access token can't guess their way to turning two-factor off.
+func (app *application) withMFACode(w http.ResponseWriter, r *http.Request, fn func(userID int, code string) (any, error)) {
+	userID, ok := app.userIDFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload mfaCodePayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	ip := app.clientIP(r)
+	if blocked := app.Guard.Check(ip, user.Email, user); blocked != nil {
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	resp, err := fn(userID, payload.Code)
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		blocked, failErr := app.Guard.Failed(r.Context(), ip, user.Email, user)
+		if failErr != nil {
+			app.Logger.ErrorContext(r.Context(), "recording failed code", "err", failErr)
+		}
+		if blocked != nil {
+			app.loginBlocked(w, r, blocked)
+			return
+		}
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	case errors.Is(err, mfa.ErrNotEnrolled), errors.Is(err, mfa.ErrAlreadyEnabled):
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	case err != nil:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
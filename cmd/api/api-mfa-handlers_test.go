@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
+	"webapp/pkg/throttle"
+)
+
+func Test_application_mfaLogin(t *testing.T) {
+	id, _ := app.DB.InsertUser(context.Background(), data.User{FirstName: "Two", LastName: "Factor", Email: "mfa@example.com", Password: "secret", Verified: true})
+	user, _ := app.DB.GetUser(context.Background(), id)
+
+	tokens, err := app.generateTokenPair(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := app.routes()
+
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// enroll
+	rr := send("POST", "/account/mfa", tokens.Token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("begin enrollment: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var enrollment mfaEnrollment
+	_ = json.NewDecoder(rr.Body).Decode(&enrollment)
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") {
+		t.Errorf("expected an otpauth uri, got %q", enrollment.ProvisioningURI)
+	}
+
+	step := mfa.Step(time.Now())
+	code, _ := mfa.Code(enrollment.Secret, step)
+
+	if rr := send("POST", "/account/mfa/confirm", tokens.Token, `{"code":"000000"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("confirm with a wrong code: expected status %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = send("POST", "/account/mfa/confirm", tokens.Token, fmt.Sprintf(`{"code":%q}`, code))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var recovery recoveryCodes
+	_ = json.NewDecoder(rr.Body).Decode(&recovery)
+	if len(recovery.RecoveryCodes) != mfa.RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", mfa.RecoveryCodeCount, len(recovery.RecoveryCodes))
+	}
+
+	if rr := send("POST", "/account/mfa", tokens.Token, ""); rr.Code != http.StatusConflict {
+		t.Errorf("enrolling twice: expected status %d but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// the password now only gets a challenge
+	rr = send("POST", "/auth", "", `{"email":"mfa@example.com","password":"secret"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("password step: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var challenge mfaChallenge
+	_ = json.Unmarshal(rr.Body.Bytes(), &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || strings.Contains(rr.Body.String(), "access_token") {
+		t.Fatalf("expected an mfa challenge and no tokens, got %s", rr.Body.String())
+	}
+
+	if rr := send("GET", "/account/mfa", challenge.MFAToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("mfa token as access token: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	next, _ := mfa.Code(enrollment.Secret, step+1)
+
+	var tests = []struct {
+		name               string
+		mfaToken           string
+		code               string
+		expectedStatusCode int
+	}{
+		{"wrong code", challenge.MFAToken, "000000", http.StatusUnauthorized},
+		{"access token instead of challenge", tokens.Token, next, http.StatusUnauthorized},
+		{"valid code", challenge.MFAToken, next, http.StatusOK},
+		{"replayed code", challenge.MFAToken, next, http.StatusUnauthorized},
+		{"recovery code", challenge.MFAToken, recovery.RecoveryCodes[0], http.StatusOK},
+		{"used recovery code", challenge.MFAToken, recovery.RecoveryCodes[0], http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		rr := send("POST", "/auth/mfa", "", fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, e.mfaToken, e.code))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "access_token") {
+			t.Errorf("%s: expected a token pair", e.name)
+		}
+	}
+
+	// turning it off again takes a code
+	if rr := send("DELETE", "/account/mfa", tokens.Token, `{"code":"000000"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("disable with a wrong code: expected status %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	body := fmt.Sprintf(`{"code":%q}`, recovery.RecoveryCodes[1])
+	if rr := send("DELETE", "/account/mfa", tokens.Token, body); rr.Code != http.StatusNoContent {
+		t.Errorf("disable: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+
+	rr = send("POST", "/auth", "", `{"email":"mfa@example.com","password":"secret"}`)
+	if !strings.Contains(rr.Body.String(), "access_token") {
+		t.Errorf("expected a token pair straight from the password once mfa is off, got %s", rr.Body.String())
+	}
+}
+
+func Test_application_mfaLoginLockout(t *testing.T) {
+	// a guard of our own, so the failures here don't leak into other tests
+	defer func(g *throttle.Guard) { app.Guard = g }(app.Guard)
+	app.Guard = throttle.NewGuard(app.DB)
+	app.Guard.MaxFailures = 3
+	app.Guard.Accounts = throttle.NewLimiter(100, time.Second, time.Minute)
+	defer func(m *metrics.Metrics) { app.Metrics = m }(app.Metrics)
+	app.Metrics = metrics.New()
+
+	id, _ := app.DB.InsertUser(context.Background(), data.User{Email: "mfa-lockout@example.com", Password: "secret", Verified: true})
+	user, _ := app.DB.GetUser(context.Background(), id)
+
+	secret, _, err := app.MFA.Begin(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := mfa.Code(secret, mfa.Step(time.Now()))
+	if _, err := app.MFA.Confirm(context.Background(), id, code); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the right password doesn't clear the failed codes before it, so they add up to a lock
+	var tests = []struct {
+		name               string
+		expectedStatusCode int
+	}{
+		{"first wrong code", http.StatusUnauthorized},
+		{"second wrong code", http.StatusUnauthorized},
+		{"third wrong code locks", http.StatusLocked},
+	}
+
+	for _, e := range tests {
+		rr := post("/auth", `{"email":"mfa-lockout@example.com","password":"secret"}`)
+		var challenge mfaChallenge
+		_ = json.NewDecoder(rr.Body).Decode(&challenge)
+		if challenge.MFAToken == "" {
+			t.Fatalf("%s: expected an mfa challenge, got status %d", e.name, rr.Code)
+		}
+
+		rr = post("/auth/mfa", fmt.Sprintf(`{"mfa_token":%q,"code":"000000"}`, challenge.MFAToken))
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	if rr := post("/auth", `{"email":"mfa-lockout@example.com","password":"secret"}`); rr.Code != http.StatusLocked {
+		t.Errorf("password while locked: expected status %d but got %d", http.StatusLocked, rr.Code)
+	}
+
+	// the right password isn't a successful login, and wrong codes aren't wrong passwords
+	rr := httptest.NewRecorder()
+	app.Metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{
+		`logins_total{outcome="mfa_required"} 3`,
+		`logins_total{outcome="bad_mfa_code"} 3`,
+		`logins_total{outcome="bad_password"} 0`,
+		`logins_total{outcome="success"} 0`,
+		`logins_total{outcome="blocked"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected the metrics to have %s", expected)
+		}
+	}
+}
+
+func Test_application_disableMFALockout(t *testing.T) {
+	// a guard of our own, so the failures here don't leak into other tests
+	defer func(g *throttle.Guard) { app.Guard = g }(app.Guard)
+	app.Guard = throttle.NewGuard(app.DB)
+	app.Guard.MaxFailures = 3
+	app.Guard.Accounts = throttle.NewLimiter(100, time.Second, time.Minute)
+
+	id, _ := app.DB.InsertUser(context.Background(), data.User{Email: "mfa-disable@example.com", Password: "secret", Verified: true})
+	user, _ := app.DB.GetUser(context.Background(), id)
+
+	secret, _, err := app.MFA.Begin(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := mfa.Code(secret, mfa.Step(time.Now()))
+	if _, err := app.MFA.Confirm(context.Background(), id, code); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := app.generateTokenPair(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disable := func(code string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/account/mfa", strings.NewReader(fmt.Sprintf(`{"code":%q}`, code)))
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a stolen access token can't be used to guess the code that turns two-factor off
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+	}{
+		{"first wrong code", "000000", http.StatusBadRequest},
+		{"second wrong code", "000000", http.StatusBadRequest},
+		{"third wrong code locks", "000000", http.StatusLocked},
+		{"right code while locked", code, http.StatusLocked},
+	}
+
+	for _, e := range tests {
+		if rr := disable(e.code); rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	enabled, err := app.MFA.Enabled(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Error("expected two-factor to still be on")
+	}
+}
@@ -1,6 +1,27 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"webapp/pkg/logging"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// it is recommended not to store primitive types in context, so creating a custom type.
+type contextKey string
+
+const contextClaimsKey contextKey = "claims"
+
+// errAuthRequired answers requests that reach a policy without having been authenticated
+var errAuthRequired = errors.New("authentication required")
+
+const (
+	roleAdmin = "admin"
+	roleUser  = "user"
+)
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -8,9 +29,11 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match, If-None-Match")
 			return
 		} else {
+			// so scripts can send a user's ETag back in If-Match
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -19,12 +42,81 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, r, err, http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if id, err := strconv.Atoi(claims.Subject); err == nil {
+			logging.SetUserID(r.Context(), id)
+		}
+
+		// keep the verified claims around for the policy middleware and handlers
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// claimsFromContext returns the claims put in the context by authRequired
+func (app *application) claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextClaimsKey).(*Claims)
+	return claims, ok
+}
+
+// userIDFromContext returns the id of the user the access token was issued to
+func (app *application) userIDFromContext(ctx context.Context) (int, bool) {
+	claims, ok := app.claimsFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// requireRole only lets through requests whose token carries the given role. It must run
+// after authRequired; a valid token without the role gets a 403, not a 401.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := app.claimsFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, r, errAuthRequired, http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasRole(role) {
+				app.errorJSON(w, r, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSelfOrAdmin lets admins through, and users acting on their own id, read from the
+// named url parameter
+func (app *application) requireSelfOrAdmin(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := app.claimsFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, r, errAuthRequired, http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasRole(roleAdmin) && claims.Subject != chi.URLParam(r, param) {
+				app.errorJSON(w, r, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
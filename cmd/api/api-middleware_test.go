@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func Test_application_enableCORS(t *testing.T) {
@@ -40,9 +44,12 @@ func Test_application_enableCORS(t *testing.T) {
 }
 
 func Test_application_authRequired(t *testing.T) {
-	//dummy
+	// make sure the verified claims are passed on
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
+		claims, ok := app.claimsFromContext(r.Context())
+		if !ok || claims.Subject != "1" {
+			t.Error("claims missing from the request context")
+		}
 	})
 	testUser := data.User{
 		ID:        1,
@@ -51,7 +58,7 @@ func Test_application_authRequired(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	var tests = []struct {
 		name       string
@@ -84,3 +91,117 @@ func Test_application_authRequired(t *testing.T) {
 		}
 	}
 }
+
+func Test_application_requireRole(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+	})
+
+	var tests = []struct {
+		name               string
+		claims             *Claims
+		role               string
+		expectedStatusCode int
+	}{
+		{"admin as admin", &Claims{Admin: true}, roleAdmin, http.StatusOK},
+		{"user as admin", &Claims{Admin: false}, roleAdmin, http.StatusForbidden},
+		{"user as user", &Claims{Admin: false}, roleUser, http.StatusOK},
+		{"unknown role", &Claims{Admin: true}, "owner", http.StatusForbidden},
+		{"no claims", nil, roleUser, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, e.claims))
+		}
+		rr := httptest.NewRecorder()
+
+		handlerToTest := app.requireRole(e.role)(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_application_requireSelfOrAdmin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+	})
+
+	var tests = []struct {
+		name               string
+		claims             *Claims
+		paramID            string
+		expectedStatusCode int
+	}{
+		{"self", &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "2"}}, "2", http.StatusOK},
+		{"someone else", &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "2"}}, "1", http.StatusForbidden},
+		{"admin", &Claims{Admin: true, RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}}, "2", http.StatusOK},
+		{"no claims", nil, "2", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+		if e.claims != nil {
+			ctx = context.WithValue(ctx, contextClaimsKey, e.claims)
+		}
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handlerToTest := app.requireSelfOrAdmin("userID")(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// checks that the policies are wired up on the real router
+func Test_application_userRoutesAuthorization(t *testing.T) {
+	admin := data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1}
+	user := data.User{ID: 2, FirstName: "Plain", LastName: "User"}
+
+	adminTokens, _ := app.generateTokenPair(context.Background(), &admin)
+	userTokens, _ := app.generateTokenPair(context.Background(), &user)
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		token              string
+		expectedStatusCode int
+	}{
+		{"list as admin", "GET", "/users/", adminTokens.Token, http.StatusOK},
+		{"list as user", "GET", "/users/", userTokens.Token, http.StatusForbidden},
+		{"list without token", "GET", "/users/", "", http.StatusUnauthorized},
+		{"get someone else as user", "GET", "/users/1", userTokens.Token, http.StatusForbidden},
+		{"get someone as admin", "GET", "/users/1", adminTokens.Token, http.StatusOK},
+		{"delete as user", "DELETE", "/users/1", userTokens.Token, http.StatusForbidden},
+		{"unlock as user", "POST", "/users/1/unlock", userTokens.Token, http.StatusForbidden},
+		{"unlock as admin", "POST", "/users/1/unlock", adminTokens.Token, http.StatusNoContent},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
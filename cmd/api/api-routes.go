@@ -2,30 +2,56 @@ package main
 
 import (
 	"net/http"
+	"webapp/pkg/logging"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// register middleware
-	mux.Use(middleware.Recoverer)
+	mux.Use(logging.Middleware(app.Logger))
+	mux.Use(app.Metrics.Middleware)
+	mux.Use(app.recoverPanic)
 	mux.Use(app.enableCORS)
 
+	// unknown routes and methods are answered with problems too
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.writeProblem(w, r, problem{Status: http.StatusNotFound})
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.writeProblem(w, r, problem{Status: http.StatusMethodNotAllowed})
+	})
+
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./html/"))))
 
+	// for prometheus to scrape
+	mux.Handle("/metrics", app.Metrics.Handler())
+
 	mux.Route("/web", func(mux chi.Router) {
 		mux.Post("/auth", app.authenticate)
+		mux.Post("/auth/mfa", app.authenticateMFA)
 		mux.Get("/refresh-token", app.refreshUsingCookie)
 		mux.Get("/logout", app.deleteRefreshCookie)
 	})
 
 	// authentication routes - auth handler, refresh tokens
 	mux.Post("/auth", app.authenticate)
+	mux.Post("/auth/mfa", app.authenticateMFA)
 	mux.Post("/refresh-token", app.refresh)
 
+	// self-registration
+	mux.Post("/register", app.register)
+	mux.Post("/verify-email", app.verifyEmail)
+
+	// password reset
+	mux.Post("/forgot-password", app.forgotPassword)
+	mux.Post("/reset-password", app.resetPassword)
+
+	// public keys for verifying our access tokens
+	mux.Get("/.well-known/jwks.json", app.jwks)
+
 	// test handler
 	mux.Get("/greeting", func(w http.ResponseWriter, r *http.Request) {
 		var payload = struct {
@@ -37,15 +63,39 @@ func (app *application) routes() http.Handler {
 		_ = app.writeJSON(w, http.StatusOK, payload)
 	})
 
+	// two-factor settings for the logged in user
+	mux.Route("/account/mfa", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+
+		mux.Get("/", app.mfaStatus)
+		mux.Post("/", app.beginMFA)
+		mux.Post("/confirm", app.confirmMFA)
+		mux.Post("/recovery-codes", app.regenerateRecoveryCodes)
+		mux.Delete("/", app.disableMFA)
+	})
+
 	// protected routes
 	mux.Route("/users", func(mux chi.Router) {
 		mux.Use(app.authRequired)
 
-		mux.Get("/", app.allUsers)
-		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/", app.insertUser)
-		mux.Patch("/", app.updateUser)
+		// users may read their own record, everything else is for admins
+		mux.With(app.requireRole(roleAdmin)).Get("/", app.allUsers)
+		mux.With(app.requireSelfOrAdmin("userID")).Get("/{userID}", app.getUser)
+		mux.With(app.requireRole(roleAdmin)).Delete("/{userID}", app.deleteUser)
+		mux.With(app.requireRole(roleAdmin)).Post("/{userID}/unlock", app.unlockUser)
+
+		// profile image history
+		mux.With(app.requireSelfOrAdmin("userID")).Get("/{userID}/images", app.userImages)
+		mux.With(app.requireSelfOrAdmin("userID")).Post("/{userID}/images/{imageID}/activate", app.activateUserImage)
+		mux.With(app.requireSelfOrAdmin("userID")).Delete("/{userID}/images/{imageID}", app.deleteUserImage)
+
+		// where the user is logged in
+		mux.With(app.requireSelfOrAdmin("userID")).Get("/{userID}/sessions", app.userSessions)
+		mux.With(app.requireSelfOrAdmin("userID")).Delete("/{userID}/sessions", app.revokeUserSessions)
+		mux.With(app.requireSelfOrAdmin("userID")).Delete("/{userID}/sessions/{sessionID}", app.revokeUserSession)
+		mux.With(app.requireRole(roleAdmin)).Put("/", app.insertUser)
+		mux.With(app.requireRole(roleAdmin)).Put("/{userID}", app.replaceUser)
+		mux.With(app.requireRole(roleAdmin)).Patch("/{userID}", app.patchUser)
 	})
 
 	return mux
@@ -14,11 +14,31 @@ func Test_application_routes(t *testing.T) {
 		method string
 	}{
 		{"/auth", "POST"},
+		{"/auth/mfa", "POST"},
 		{"/refresh-token", "POST"},
+		{"/.well-known/jwks.json", "GET"},
+		{"/metrics", "GET"},
+		{"/register", "POST"},
+		{"/verify-email", "POST"},
+		{"/forgot-password", "POST"},
+		{"/reset-password", "POST"},
+		{"/account/mfa/", "GET"},
+		{"/account/mfa/", "POST"},
+		{"/account/mfa/confirm", "POST"},
+		{"/account/mfa/recovery-codes", "POST"},
+		{"/account/mfa/", "DELETE"},
 		{"/users/", "GET"},
 		{"/users/{userID}", "GET"},
 		{"/users/{userID}", "DELETE"},
-		{"/users/", "PATCH"},
+		{"/users/{userID}/unlock", "POST"},
+		{"/users/{userID}/images", "GET"},
+		{"/users/{userID}/images/{imageID}/activate", "POST"},
+		{"/users/{userID}/images/{imageID}", "DELETE"},
+		{"/users/{userID}/sessions", "GET"},
+		{"/users/{userID}/sessions", "DELETE"},
+		{"/users/{userID}/sessions/{sessionID}", "DELETE"},
+		{"/users/{userID}", "PATCH"},
+		{"/users/{userID}", "PUT"},
 		{"/users/", "PUT"},
 	}
 
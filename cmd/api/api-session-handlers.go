@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// userSessions lists where a user is logged in, to the web app and the api. The session the
+// request was made with is marked current.
+func (app *application) userSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := app.DB.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	if sessions == nil {
+		sessions = []*data.UserSession{}
+	}
+
+	if current, ok := app.currentSession(r); ok {
+		for _, s := range sessions {
+			s.Current = s.ID == current.ID
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, sessions, "sessions")
+}
+
+// revokeUserSession logs a user out of one session. For an api session that takes effect
+// when its access token expires, as it can't be refreshed any more.
+func (app *application) revokeUserSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.DB.GetUserSession(r.Context(), sessionID)
+	if err != nil || s.UserID != userID {
+		app.errorJSON(w, r, errors.New("session not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.DB.RevokeUserSession(r.Context(), s.ID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserSessions logs a user out everywhere, except the session making the request
+func (app *application) revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var keep int
+	if current, ok := app.currentSession(r); ok && current.UserID == userID {
+		keep = current.ID
+	}
+
+	err = app.DB.RevokeUserSessions(r.Context(), userID, keep)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentSession returns the session the request's access token was issued in
+func (app *application) currentSession(r *http.Request) (*data.UserSession, bool) {
+	claims, ok := app.claimsFromContext(r.Context())
+	if !ok || claims.SessionID == "" {
+		return nil, false
+	}
+
+	s, err := app.DB.GetUserSessionByToken(r.Context(), data.SessionAPI, claims.SessionID)
+	if err != nil {
+		return nil, false
+	}
+
+	return s, true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_application_userSessions(t *testing.T) {
+	ctx := context.Background()
+	id, _ := app.DB.InsertUser(ctx, data.User{FirstName: "Many", LastName: "Devices", Email: "devices@example.com", Password: "password123", Verified: true})
+
+	routes := app.routes()
+
+	send := func(method, url, token string, header http.Header, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// log in from a laptop and a phone
+	login := func(userAgent string) TokenPairs {
+		rr := send("POST", "/auth", "", http.Header{"User-Agent": {userAgent}}, `{"email":"devices@example.com","password":"password123"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login: expected status %d but got %d", http.StatusOK, rr.Code)
+		}
+		var tokens TokenPairs
+		_ = json.NewDecoder(rr.Body).Decode(&tokens)
+		return tokens
+	}
+	laptop := login("Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0")
+	phone := login("Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36")
+
+	list := func(token string) []data.UserSession {
+		rr := send("GET", fmt.Sprintf("/users/%d/sessions", id), token, nil, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list: expected status %d but got %d", http.StatusOK, rr.Code)
+		}
+		var listed struct {
+			Sessions []data.UserSession `json:"sessions"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&listed)
+		return listed.Sessions
+	}
+
+	sessions := list(laptop.Token)
+	if len(sessions) != 2 {
+		t.Fatalf("list: expected 2 sessions but got %d", len(sessions))
+	}
+
+	var laptopSession, phoneSession data.UserSession
+	for _, s := range sessions {
+		switch s.Device {
+		case "Firefox on Linux":
+			laptopSession = s
+		case "Chrome on Android":
+			phoneSession = s
+		}
+	}
+	if !laptopSession.Current || phoneSession.Current {
+		t.Errorf("list: expected only the laptop session to be current, got %+v", sessions)
+	}
+	if phoneSession.ID == 0 || phoneSession.IP == "" {
+		t.Errorf("list: expected the phone session with its ip, got %+v", phoneSession)
+	}
+
+	// sign the phone out; its refresh token stops working
+	if rr := send("DELETE", fmt.Sprintf("/users/%d/sessions/%d", id, phoneSession.ID), laptop.Token, nil, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+	cookie := http.Header{"Cookie": {"__Host-refresh_token=" + phone.RefreshToken}}
+	if rr := send("GET", "/web/refresh-token", "", cookie, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after revoke: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if sessions := list(laptop.Token); len(sessions) != 1 {
+		t.Errorf("list after revoke: expected 1 session but got %d", len(sessions))
+	}
+
+	// someone else's session is not found, even for an admin
+	admin, _ := app.DB.GetUser(ctx, 1)
+	adminTokens, _ := app.generateTokenPair(ctx, admin)
+	if rr := send("DELETE", fmt.Sprintf("/users/1/sessions/%d", laptopSession.ID), adminTokens.Token, nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("revoke another user's session: expected status %d but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// signing out everywhere keeps the session doing it
+	other := login("curl/8.1.2")
+	if rr := send("DELETE", fmt.Sprintf("/users/%d/sessions", id), laptop.Token, nil, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke all: expected status %d but got %d", http.StatusNoContent, rr.Code)
+	}
+	sessions = list(laptop.Token)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("revoke all: expected only the current session to be left, got %+v", sessions)
+	}
+	cookie = http.Header{"Cookie": {"__Host-refresh_token=" + other.RefreshToken}}
+	if rr := send("GET", "/web/refresh-token", "", cookie, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after revoke all: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
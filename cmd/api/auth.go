@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/jwtkeys"
+	"webapp/pkg/useragent"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -16,16 +21,53 @@ var (
 	refreshTokenExpiry = time.Hour * 24
 )
 
+var errRefreshTokenReused = errors.New("refresh token has already been used")
+
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
+// loadKeys builds the key set from the command line flags. Without a signing key we fall
+// back to the shared HMAC secret, which can't be published in the JWKS.
+func (app *application) loadKeys() (*jwtkeys.KeySet, error) {
+	if app.JWTSigningKey == "" {
+		return jwtkeys.NewKeySet(jwtkeys.NewHMACKey("", []byte(app.JWTSecret)))
+	}
+
+	signing, err := jwtkeys.LoadKey(app.JWTKeyID, app.JWTSigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	verify, err := jwtkeys.LoadKeys(app.JWTVerifyKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwtkeys.NewKeySet(signing, verify...)
+}
+
 type Claims struct {
-	UserName string `json:"name"`
+	UserName  string `json:"name"`
+	Admin     bool   `json:"admin"`
+	SessionID string `json:"sid,omitempty"` // the refresh token family, which is the api session
 	jwt.RegisteredClaims
 }
 
+// HasRole reports whether the token grants a role. Every authenticated user has roleUser,
+// and roleAdmin comes from the admin claim set by generateTokenPair.
+func (c *Claims) HasRole(role string) bool {
+	switch role {
+	case roleUser:
+		return true
+	case roleAdmin:
+		return c.Admin
+	default:
+		return false
+	}
+}
+
 func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
 	// add a Header - good practice to add this, not compulsary
 	w.Header().Add("Vary", "Authorization")
@@ -54,14 +96,8 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 	// declare an empty Claims variable
 	claims := &Claims{}
 
-	// parse the token with our claims using out secret from reciever
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		// validate the signing method to make sure token is signed
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(app.JWTSecret), nil
-	})
+	// parse the token with our claims, the key set validates the kid and signing method
+	_, err := jwt.ParseWithClaims(token, claims, app.Keys.Keyfunc)
 
 	// error catches expired tokens too
 	if err != nil {
@@ -76,40 +112,102 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 		return "", nil, errors.New("incorrect issuer")
 	}
 
+	// and that it is an access token, not some other token we signed like an mfa challenge
+	if !claims.VerifyAudience(app.Domain, true) {
+		return "", nil, errors.New("incorrect audience")
+	}
+
 	// valid token
 
 	return token, claims, nil
 }
 
-func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
-	// create token
-	token := jwt.New(jwt.SigningMethodHS256)
+// generateTokenPair issues a token pair for a fresh login, starting a new refresh token family
+func (app *application) generateTokenPair(ctx context.Context, user *data.User) (TokenPairs, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return app.issueTokenPair(ctx, user, familyID)
+}
+
+// startSession logs a user in from a request: it issues a token pair in a new family, and
+// records the family as one of the user's sessions, with the device it was issued to
+func (app *application) startSession(r *http.Request, user *data.User) (TokenPairs, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	tokenPairs, err := app.issueTokenPair(r.Context(), user, familyID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	_, err = app.DB.InsertUserSession(r.Context(), data.UserSession{
+		UserID:    user.ID,
+		Kind:      data.SessionAPI,
+		TokenID:   familyID,
+		Device:    useragent.Describe(r.UserAgent()),
+		UserAgent: r.UserAgent(),
+		IP:        app.clientIP(r),
+		ExpiresAt: time.Now().Add(refreshTokenExpiry),
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokenPairs, nil
+}
 
+// issueTokenPair signs a new access and refresh token, and stores the refresh token in the
+// given family so that it can be exchanged exactly once
+func (app *application) issueTokenPair(ctx context.Context, user *data.User, familyID string) (TokenPairs, error) {
 	// set the claims for token
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = app.Domain
 	claims["iss"] = app.Domain
 
 	claims["admin"] = user.IsAdmin == 1
+	claims["sid"] = familyID
 
 	// set expiry
 	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
 
 	// create the signed token
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	signedAccessToken, err := app.Keys.Sign(claims)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	// a random id makes every refresh token unique, even when two are issued in the same second
+	tokenID, err := randomString(16)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// create the refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	expiresAt := time.Now().Add(refreshTokenExpiry)
+	refreshTokenClaims := jwt.MapClaims{}
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshTokenClaims["jti"] = tokenID
+	refreshTokenClaims["exp"] = expiresAt.Unix()
+
+	signedRefreshToken, err := app.Keys.Sign(refreshTokenClaims)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+	// only the hash of the refresh token is kept on our side
+	_, err = app.DB.InsertRefreshToken(ctx, data.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: data.HashToken(signedRefreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: expiresAt,
+	})
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -121,3 +219,67 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 
 	return tokenPairs, nil
 }
+
+// exchangeRefreshToken swaps a verified refresh token for a new token pair in the same family.
+// Every refresh token can be used once; presenting one that was already used means it has
+// probably been stolen, so the whole family is revoked and the legitimate holder has to log in again.
+// The session the family belongs to is marked as seen from ip.
+func (app *application) exchangeRefreshToken(ctx context.Context, refreshToken, ip string) (TokenPairs, error) {
+	stored, err := app.DB.GetRefreshToken(ctx, data.HashToken(refreshToken))
+	if err != nil {
+		return TokenPairs{}, errors.New("unknown refresh token")
+	}
+
+	if stored.Revoked {
+		_ = app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+		return TokenPairs{}, errRefreshTokenReused
+	}
+
+	// revoking is conditional in the database, so if two requests race only one of them wins
+	ok, err := app.DB.RevokeRefreshToken(ctx, stored.ID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	if !ok {
+		_ = app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+		return TokenPairs{}, errRefreshTokenReused
+	}
+
+	user, err := app.DB.GetUser(ctx, stored.UserID)
+	if err != nil {
+		return TokenPairs{}, errors.New("unknown user")
+	}
+
+	_ = app.DB.TouchUserSession(ctx, data.SessionAPI, stored.FamilyID, ip, time.Now().Add(refreshTokenExpiry))
+
+	return app.issueTokenPair(ctx, user, stored.FamilyID)
+}
+
+// revokeRefreshToken revokes the family of a refresh token, logging out that session on the server
+func (app *application) revokeRefreshToken(ctx context.Context, refreshToken string) error {
+	stored, err := app.DB.GetRefreshToken(ctx, data.HashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	return app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// clientIP returns the ip a request came from, as well as it can be told
+func (app *application) clientIP(r *http.Request) string {
+	ip, err := app.Proxies.ClientIP(r)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// randomString returns n random bytes, hex encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"webapp/pkg/data"
+	"webapp/pkg/jwtkeys"
 )
 
 func Test_application_getTokenFromHeaderAndVerify(t *testing.T) {
@@ -16,7 +22,7 @@ func Test_application_getTokenFromHeaderAndVerify(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	var tests = []struct {
 		name          string
@@ -37,7 +43,7 @@ func Test_application_getTokenFromHeaderAndVerify(t *testing.T) {
 	for _, e := range tests {
 		if e.issuer != app.Domain {
 			app.Domain = e.issuer
-			tokens, _ = app.generateTokenPair(&testUser)
+			tokens, _ = app.generateTokenPair(context.Background(), &testUser)
 		}
 		req, _ := http.NewRequest("GET", "/", nil)
 		if e.setHeader {
@@ -56,3 +62,48 @@ func Test_application_getTokenFromHeaderAndVerify(t *testing.T) {
 		app.Domain = "example.com"
 	}
 }
+
+func Test_application_asymmetricKeys(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	// a token signed with the HMAC secret, before we switch to an RSA key
+	hmacTokens, _ := app.generateTokenPair(context.Background(), &testUser)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signing, _ := jwtkeys.ParseKey("test-rsa", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+
+	hmacKeys := app.Keys
+	app.Keys, _ = jwtkeys.NewKeySet(signing)
+	defer func() { app.Keys = hmacKeys }()
+
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
+
+	var tests = []struct {
+		name          string
+		token         string
+		errorExpected bool
+	}{
+		{"RS256 token", tokens.Token, false},
+		{"old HMAC token", hmacTokens.Token, true},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.token))
+		rr := httptest.NewRecorder()
+
+		_, _, err := app.getTokenFromHeaderAndVerify(rr, req)
+
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got one - %s", e.name, err.Error())
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but got did not get one", e.name)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// userETag is the entity tag for a user, from their row version, which changes whenever they do.
+// failed_logins and locked_until are left out: they change on every failed login, and a
+// conditional edit shouldn't fail because someone mistyped a password.
+func userETag(u *data.User) string {
+	return fmt.Sprintf(`"%d"`, u.Version)
+}
+
+// etagListed reports whether an If-Match or If-None-Match header lists etag, or is "*". Weak
+// tags only count when weak is set: If-None-Match compares weakly, If-Match strongly.
+func etagListed(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion checks a write's If-Match header against the user as stored now. It returns
+// the version to limit the write to, or 0 when the request has no If-Match. When the user has
+// changed it answers 412 itself, and returns false.
+func (app *application) ifMatchVersion(w http.ResponseWriter, r *http.Request, userID int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, err)
+		return 0, false
+	}
+
+	if !etagListed(header, userETag(user), false) {
+		app.errorJSON(w, r, repository.ErrStale, http.StatusPreconditionFailed)
+		return 0, false
+	}
+
+	return user.Version, true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_etagListed(t *testing.T) {
+	var tests = []struct {
+		header string
+		weak   bool
+		listed bool
+	}{
+		{`"3"`, false, true},
+		{`"1", "3"`, false, true},
+		{`*`, false, true},
+		{`"4"`, false, false},
+		{`W/"3"`, false, false},
+		{`W/"3"`, true, true},
+		{`"33"`, true, false},
+	}
+
+	for _, e := range tests {
+		if got := etagListed(e.header, `"3"`, e.weak); got != e.listed {
+			t.Errorf("%s (weak %v): expected %v but got %v", e.header, e.weak, e.listed, got)
+		}
+	}
+}
+
+// userRequest builds a request for one user's resource, with the given conditional headers
+func userRequest(method string, userID int, body string, headers map[string]string) *http.Request {
+	req, _ := http.NewRequest(method, "/users/"+strconv.Itoa(userID), strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", strconv.Itoa(userID))
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+}
+
+func Test_application_userETags(t *testing.T) {
+	useFreshDB(t)
+
+	id, err := app.DB.InsertUser(context.Background(), data.User{FirstName: "Etag", LastName: "User", Email: "etag@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		method     string
+		body       string
+		headers    map[string]string
+		handler    http.HandlerFunc
+		expectCode int
+		expectETag string
+	}{
+		{"get", "GET", "", nil, app.getUser, http.StatusOK, `"1"`},
+		{"get unchanged", "GET", "", map[string]string{"If-None-Match": `"1"`}, app.getUser, http.StatusNotModified, `"1"`},
+		{"get unchanged weak", "GET", "", map[string]string{"If-None-Match": `W/"1"`}, app.getUser, http.StatusNotModified, `"1"`},
+		{"get changed", "GET", "", map[string]string{"If-None-Match": `"0"`}, app.getUser, http.StatusOK, `"1"`},
+		{"patch stale", "PATCH", `{"last_name":"Stale"}`, map[string]string{"Content-Type": mergePatchContentType, "If-Match": `"0"`}, app.patchUser, http.StatusPreconditionFailed, ""},
+		{"patch weak", "PATCH", `{"last_name":"Weak"}`, map[string]string{"Content-Type": mergePatchContentType, "If-Match": `W/"1"`}, app.patchUser, http.StatusPreconditionFailed, ""},
+		{"patch current", "PATCH", `{"last_name":"Patched"}`, map[string]string{"Content-Type": mergePatchContentType, "If-Match": `"1"`}, app.patchUser, http.StatusOK, `"2"`},
+		{"patch unconditional", "PATCH", `{"last_name":"Again"}`, map[string]string{"Content-Type": mergePatchContentType}, app.patchUser, http.StatusOK, `"3"`},
+		{"put stale", "PUT", `{"first_name":"Etag","last_name":"User","email":"etag@example.com","is_admin":0}`, map[string]string{"If-Match": `"2"`}, app.replaceUser, http.StatusPreconditionFailed, ""},
+		{"put current", "PUT", `{"first_name":"Etag","last_name":"User","email":"etag@example.com","is_admin":0}`, map[string]string{"If-Match": `"3"`}, app.replaceUser, http.StatusOK, `"4"`},
+		{"delete stale", "DELETE", "", map[string]string{"If-Match": `"3"`}, app.deleteUser, http.StatusPreconditionFailed, ""},
+		{"delete any version", "DELETE", "", map[string]string{"If-Match": `*`}, app.deleteUser, http.StatusNoContent, ""},
+		{"delete gone", "DELETE", "", map[string]string{"If-Match": `*`}, app.deleteUser, http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, userRequest(e.method, id, e.body, e.headers))
+
+		if rr.Code != e.expectCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectCode, rr.Code)
+		}
+		if got := rr.Header().Get("ETag"); got != e.expectETag {
+			t.Errorf("%s: expected ETag %q but got %q", e.name, e.expectETag, got)
+		}
+		if e.expectCode == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: expected no body with 304, got %q", e.name, rr.Body.String())
+		}
+	}
+}
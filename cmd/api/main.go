@@ -1,42 +1,136 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"webapp/pkg/accounts"
+	"webapp/pkg/images"
+	"webapp/pkg/jwtkeys"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
+	"webapp/pkg/migrations"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/storage"
+	"webapp/pkg/throttle"
 )
 
 const port = 8090
 
 type application struct {
-	DSN       string
-	DB        repository.DatabaseRepo
-	Domain    string
-	JWTSecret string
+	DSN           string
+	DB            repository.DatabaseRepo
+	Domain        string
+	JWTSecret     string
+	JWTSigningKey string
+	JWTKeyID      string
+	JWTVerifyKeys string
+	Keys          *jwtkeys.KeySet
+	WebURL        string
+	Mailer        mailer.Mailer
+	Accounts      *accounts.Notifier
+	Guard         *throttle.Guard
+	Proxies       throttle.Proxies
+	MFAKey        string
+	MFA           *mfa.Manager
+	Storage       storage.Storage
+	Images        *images.Library
+	Logger        *slog.Logger
+	Metrics       *metrics.Metrics
 }
 
 func main() {
 	var app application
+	var runMigrations bool
+	var mailDir string
+	var storageCfg storage.Config
+	var logLevel string
+	var trustedProxies string
 	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "sss", "signing secret")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", "sss", "signing secret, used when no signing key is given")
+	flag.StringVar(&app.JWTSigningKey, "jwt-signing-key", "", "path to a PEM encoded RSA or Ed25519 private key to sign tokens with")
+	flag.StringVar(&app.JWTKeyID, "jwt-kid", "", "key id of the signing key, sent in the kid header")
+	flag.StringVar(&app.JWTVerifyKeys, "jwt-verify-keys", "", "extra verification keys for rotation, as kid=path,kid=path")
+	flag.StringVar(&app.MFAKey, "mfa-key", "change-me-mfa-key", "key for encrypting two-factor secrets, shared with the web app")
+	flag.StringVar(&app.WebURL, "web-url", "http://localhost:9000", "base url of the web app, used for links in emails")
+	flag.StringVar(&mailDir, "mail-dir", "", "write outgoing email to files in this directory instead of the log")
+	flag.BoolVar(&runMigrations, "migrate", false, "apply pending database migrations before starting")
+	flag.StringVar(&logLevel, "log-level", "info", "least important log level written: debug, info, warn or error")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "comma separated ips or CIDR ranges of reverse proxies whose X-Forwarded-For is believed")
+	storageCfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Logger = logging.New(os.Stdout, level)
+	// anything still using the log package ends up as json too
+	slog.SetDefault(app.Logger)
+
+	app.Proxies, err = throttle.ParseProxies(trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := app.loadKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Keys = keys
+
+	app.Mailer = mailer.NewLogMailer(nil)
+	if mailDir != "" {
+		app.Mailer = &mailer.FileMailer{Dir: mailDir}
+	}
+
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if runMigrations {
+		applied, err := migrations.Run(context.Background(), conn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Logger.Info("applied migrations", "count", len(applied))
+	}
+
+	app.Metrics = metrics.New()
+	app.Metrics.RegisterDB("users", conn)
+
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn, Observe: app.Metrics.ObserveQuery}
+	app.Guard = throttle.NewGuard(app.DB)
 
-	log.Printf("Starting api on port %d\n", port)
+	box, err := mfa.NewBox(app.MFAKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.MFA = mfa.NewManager(app.DB, box)
+
+	// local files are served, and their signed urls checked, by the web app
+	storageCfg.BaseURL = app.WebURL + "/images"
+	app.Storage, err = storage.Open(storageCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Images = &images.Library{DB: app.DB, Storage: app.Storage}
+	app.Accounts = &accounts.Notifier{DB: app.DB, Mailer: app.Mailer, WebURL: app.WebURL}
+
+	app.Logger.Info("starting api", "port", port)
 
 	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
 	if err != nil {
-		log.Fatal()
+		log.Fatal(err)
 	}
 }
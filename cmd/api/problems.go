@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"webapp/pkg/logging"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem types, beyond about:blank for errors that say no more than their status code
+const (
+	problemTypeBlank      = "about:blank"
+	problemTypeValidation = "/problems/validation"
+)
+
+// problem is an RFC 7807 problem details document, the body of every error response
+type problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// writeProblem fills in what the problem leaves out, from its status and the request, and
+// writes it
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Type == "" {
+		p.Type = problemTypeBlank
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.Instance = r.URL.Path
+	p.RequestID = logging.RequestIDFromContext(r.Context())
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "encoding problem", "err", err)
+		w.WriteHeader(p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(out)
+}
+
+// errorJSON answers with a problem for err, with status 400 unless another is given. The
+// error's text is the detail, except for server errors, which are logged instead: their text
+// is for us, not the client.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
+	statusCode := http.StatusBadRequest
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	detail := err.Error()
+	if statusCode >= http.StatusInternalServerError {
+		app.Logger.ErrorContext(r.Context(), "request failed", "status", statusCode, "err", err)
+		detail = "something went wrong on our side, please try again later"
+	}
+
+	app.writeProblem(w, r, problem{Status: statusCode, Detail: detail})
+}
+
+// validationError answers with a 422 listing what is wrong with each field
+func (app *application) validationError(w http.ResponseWriter, r *http.Request, fields map[string]string) {
+	app.writeProblem(w, r, problem{
+		Type:   problemTypeValidation,
+		Title:  "Your request has invalid fields",
+		Status: http.StatusUnprocessableEntity,
+		Errors: fields,
+	})
+}
+
+// recoverPanic turns a panicking handler into a 500 problem, and logs the panic with its stack.
+// It stands in for chi's Recoverer, which answers with a bare status.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			// the server's way of aborting a response, not a bug
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			app.Logger.ErrorContext(r.Context(), "panic", "panic", rvr, "stack", string(debug.Stack()))
+			app.errorJSON(w, r, errors.New("panic"), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
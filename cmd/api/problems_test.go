@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/logging"
+)
+
+// readProblem checks a response is a problem document, and decodes it
+func readProblem(t *testing.T, rr *httptest.ResponseRecorder) problem {
+	t.Helper()
+
+	if ct := rr.Header().Get("Content-Type"); ct != problemContentType {
+		t.Fatalf("expected content type %s but got %q", problemContentType, ct)
+	}
+
+	var p problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatalf("decoding problem: %v", err)
+	}
+	if p.Status != rr.Code {
+		t.Errorf("problem status %d does not match response status %d", p.Status, rr.Code)
+	}
+
+	return p
+}
+
+func Test_application_errorJSON(t *testing.T) {
+	var tests = []struct {
+		name         string
+		err          error
+		status       []int
+		expectStatus int
+		expectDetail string
+	}{
+		{"default status", errors.New("bad input"), nil, http.StatusBadRequest, "bad input"},
+		{"client error", errors.New("no such thing"), []int{http.StatusNotFound}, http.StatusNotFound, "no such thing"},
+		{"server error hides detail", errors.New("connection refused"), []int{http.StatusInternalServerError}, http.StatusInternalServerError, "something went wrong on our side, please try again later"},
+	}
+
+	for _, e := range tests {
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			app.errorJSON(w, r, e.err, e.status...)
+		})
+		handler = logging.Middleware(app.Logger)(handler)
+
+		req, _ := http.NewRequest("GET", "/users/5", nil)
+		req.Header.Set("X-Request-ID", "problem-test")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectStatus, rr.Code)
+		}
+
+		p := readProblem(t, rr)
+		if p.Type != problemTypeBlank || p.Title != http.StatusText(e.expectStatus) {
+			t.Errorf("%s: unexpected type %q or title %q", e.name, p.Type, p.Title)
+		}
+		if p.Detail != e.expectDetail {
+			t.Errorf("%s: expected detail %q but got %q", e.name, e.expectDetail, p.Detail)
+		}
+		if p.Instance != "/users/5" {
+			t.Errorf("%s: expected instance /users/5 but got %q", e.name, p.Instance)
+		}
+		if p.RequestID != "problem-test" {
+			t.Errorf("%s: expected request id problem-test but got %q", e.name, p.RequestID)
+		}
+	}
+}
+
+func Test_application_validationError(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/register", strings.NewReader(`{"first_name":"","last_name":"User","email":"nope","password":"short"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.register).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 but got %d", rr.Code)
+	}
+
+	p := readProblem(t, rr)
+	if p.Type != problemTypeValidation {
+		t.Errorf("expected type %s but got %q", problemTypeValidation, p.Type)
+	}
+	for _, field := range []string{"first_name", "email", "password"} {
+		if p.Errors[field] == "" {
+			t.Errorf("expected an error for %s, got %v", field, p.Errors)
+		}
+	}
+	if _, ok := p.Errors["last_name"]; ok {
+		t.Errorf("did not expect an error for last_name, got %q", p.Errors["last_name"])
+	}
+}
+
+func Test_application_recoverPanic(t *testing.T) {
+	handler := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 but got %d", rr.Code)
+	}
+	if p := readProblem(t, rr); strings.Contains(p.Detail, "boom") {
+		t.Errorf("panic value leaked into the detail: %q", p.Detail)
+	}
+
+	// aborting a response is passed on to the server
+	aborting := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if rvr := recover(); rvr != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to be re-panicked, got %v", rvr)
+		}
+	}()
+	aborting.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func Test_application_authRequired_problem(t *testing.T) {
+	handler := app.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without a token")
+	}))
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 but got %d", rr.Code)
+	}
+	if p := readProblem(t, rr); p.Title != http.StatusText(http.StatusUnauthorized) {
+		t.Errorf("unexpected title %q", p.Title)
+	}
+}
+
+func Test_application_routes_problems(t *testing.T) {
+	var tests = []struct {
+		name         string
+		method       string
+		url          string
+		expectStatus int
+	}{
+		{"unknown route", "GET", "/no-such-route", http.StatusNotFound},
+		{"wrong method", "PUT", "/auth", http.StatusMethodNotAllowed},
+	}
+
+	routes := app.routes()
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectStatus, rr.Code)
+			continue
+		}
+		if p := readProblem(t, rr); p.Instance != e.url {
+			t.Errorf("%s: expected instance %s but got %q", e.name, e.url, p.Instance)
+		}
+	}
+}
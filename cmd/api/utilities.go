@@ -5,6 +5,9 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/repository"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -37,21 +40,27 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
-	statusCode := http.StatusBadRequest
-	if len(status) > 0 {
-		statusCode = status[0]
-	}
-
-	type jsonError struct {
-		Message string `json:"message"`
-	}
-
-	theError := jsonError{
-		Message: err.Error(),
+// repositoryError answers a failed repository call with the status its error calls for: 404,
+// 409 or 422, with the repository's message, or a 500
+func (app *application) repositoryError(w http.ResponseWriter, r *http.Request, err error) {
+	for _, known := range []struct {
+		err    error
+		status int
+	}{
+		{repository.ErrNotFound, http.StatusNotFound},
+		{repository.ErrDuplicateEmail, http.StatusConflict},
+		{repository.ErrConflict, http.StatusConflict},
+		{repository.ErrInvalid, http.StatusUnprocessableEntity},
+		// only writes made with If-Match are limited to a version
+		{repository.ErrStale, http.StatusPreconditionFailed},
+	} {
+		if errors.Is(err, known.err) {
+			app.errorJSON(w, r, known.err, known.status)
+			return
+		}
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	app.errorJSON(w, r, err, http.StatusInternalServerError)
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -74,3 +83,20 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 
 	return nil
 }
+
+// pageURL returns the url of the current request, pointing at another page
+func pageURL(r *http.Request, page int) string {
+	values := r.URL.Query()
+	values.Set("page", strconv.Itoa(page))
+
+	return r.URL.Path + "?" + values.Encode()
+}
+
+// parseTime accepts either a full RFC 3339 timestamp or a plain yyyy-mm-dd date
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", value)
+}
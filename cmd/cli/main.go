@@ -4,32 +4,49 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
+	"webapp/pkg/jwtkeys"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type application struct {
-	JWTSecret string
-	Action    string
+	JWTSecret  string
+	SigningKey string
+	KeyID      string
+	Action     string
 }
 
 // used to generate a token so we can test our api
 // Usage:
 // go run ./cmd/cli -action=valid for valid token
 // go run ./cmd/cli -action=expired for expired token
+// go run ./cmd/cli -signing-key=./keys/api.pem -kid=2023-06 to sign with an RSA or Ed25519 key
+// go run ./cmd/cli migrate up|down|status to manage the database schema, see migrate.go
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var app application
 	flag.StringVar(&app.JWTSecret, "jwt-secret", "sss", "secret")
+	flag.StringVar(&app.SigningKey, "signing-key", "", "path to a PEM encoded private key; the secret is used when empty")
+	flag.StringVar(&app.KeyID, "kid", "", "key id of the signing key")
 	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
 	flag.Parse()
 
-	// generate a token
-	token := jwt.New(jwt.SigningMethodHS256)
+	keys, err := app.keySet()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// set claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = "John Doe"
 	claims["sub"] = "1"
 	claims["admin"] = true
@@ -51,10 +68,24 @@ func main() {
 		fmt.Println("Expired token:")
 	}
 
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	signedAccessToken, err := keys.Sign(claims)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Println(string(signedAccessToken))
 }
+
+// keySet returns the key set to sign with, the same way the api builds its own
+func (app *application) keySet() (*jwtkeys.KeySet, error) {
+	if app.SigningKey == "" {
+		return jwtkeys.NewKeySet(jwtkeys.NewHMACKey("", []byte(app.JWTSecret)))
+	}
+
+	key, err := jwtkeys.LoadKey(app.KeyID, app.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwtkeys.NewKeySet(key)
+}
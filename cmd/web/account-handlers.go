@@ -0,0 +1,200 @@
+package main
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+func (app *application) RegisterPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "register.page.gohtml", &TemplateData{})
+}
+
+// Register signs up a new, unverified account and emails it a verification link
+func (app *application) Register(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password", "confirm_password")
+	form.IsName("first_name", "last_name")
+	form.IsEmail("email")
+	form.IsPassword("password", "email")
+	form.Check(form.Data.Get("password") == form.Data.Get("confirm_password"), "confirm_password", "Passwords do not match")
+
+	if !form.Valid() {
+		for _, field := range []string{"first_name", "last_name", "email", "password", "confirm_password"} {
+			if msg := form.Errors.Get(field); msg != "" {
+				app.Session.Put(r.Context(), "error", fmt.Sprintf("%s: %s", strings.ReplaceAll(field, "_", " "), msg))
+				break
+			}
+		}
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
+	}
+
+	user := data.User{
+		FirstName: strings.TrimSpace(form.Data.Get("first_name")),
+		LastName:  strings.TrimSpace(form.Data.Get("last_name")),
+		Email:     form.Data.Get("email"),
+		Password:  form.Data.Get("password"),
+	}
+
+	// a taken address gets the same answer as a new one, and its owner an email saying so
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	switch {
+	case stderrors.Is(err, repository.ErrDuplicateEmail):
+		var existing *data.User
+		existing, err = app.DB.GetUserByEmail(r.Context(), user.Email)
+		if err == nil {
+			err = app.Accounts.SendAlreadyRegistered(r.Context(), existing)
+		}
+		if err != nil {
+			app.Session.Put(r.Context(), "error", "We could not create your account, please try again")
+			http.Redirect(w, r, "/register", http.StatusSeeOther)
+			return
+		}
+	case err != nil:
+		app.Session.Put(r.Context(), "error", "We could not create your account, please try again")
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
+	default:
+		err = app.Accounts.SendVerification(r.Context(), &user)
+		if err != nil {
+			app.Session.Put(r.Context(), "error", "Your account was created, but we could not send the confirmation email")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "Thanks for signing up! Follow the link we've emailed you to confirm your address, then log in")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// VerifyEmail confirms an account's email address from the link in its verification email
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	userToken, err := app.DB.ConsumeUserToken(r.Context(), data.ScopeVerification, data.HashToken(token))
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "That confirmation link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.VerifyUser(r.Context(), userToken.UserID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "We could not confirm your email address, please try again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.DB.DeleteUserTokens(r.Context(), userToken.UserID, data.ScopeVerification)
+
+	app.Session.Put(r.Context(), "flash", "Your email address is confirmed, you can log in now")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "forgot-password.page.gohtml", &TemplateData{})
+}
+
+// ForgotPassword emails a reset link. The flash message is the same whether or not the
+// address has an account, so the form can't be used to find out who is registered.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Please enter your email address")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), form.Data.Get("email"))
+	if err == nil {
+		err = app.Accounts.SendPasswordReset(r.Context(), user)
+		if err != nil {
+			app.Session.Put(r.Context(), "error", "We could not send the reset email, please try again later")
+			http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If that address has an account, we've emailed it a link to reset the password")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.Session.Put(r.Context(), "error", "That reset link is not valid")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "reset-password.page.gohtml", &TemplateData{Data: map[string]any{"token": token}})
+}
+
+// ResetPassword sets a new password from a reset link. The token is single-use, and checked
+// only once the new password is acceptable so that a typo doesn't burn it.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("token", "password", "confirm_password")
+	form.IsPassword("password", "")
+	form.Check(form.Data.Get("password") == form.Data.Get("confirm_password"), "confirm_password", "Passwords do not match")
+
+	token := form.Data.Get("token")
+	retry := "/reset-password?token=" + url.QueryEscape(token)
+
+	if !form.Valid() {
+		for _, field := range []string{"token", "password", "confirm_password"} {
+			if msg := form.Errors.Get(field); msg != "" {
+				app.Session.Put(r.Context(), "error", msg)
+				break
+			}
+		}
+		http.Redirect(w, r, retry, http.StatusSeeOther)
+		return
+	}
+
+	userToken, err := app.DB.ConsumeUserToken(r.Context(), data.ScopePasswordReset, data.HashToken(token))
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "That reset link is invalid or has expired")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), userToken.UserID, form.Data.Get("password"))
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "We could not reset your password, please try again")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	// other reset links are stale now, and sessions opened with the old password end
+	_ = app.DB.DeleteUserTokens(r.Context(), userToken.UserID, data.ScopePasswordReset)
+	_ = app.DB.RevokeUserRefreshTokens(r.Context(), userToken.UserID)
+	_ = app.DB.RevokeUserSessions(r.Context(), userToken.UserID, 0)
+
+	app.Session.Put(r.Context(), "flash", "Your password has been reset, you can log in now")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tokenInMail = regexp.MustCompile(`token=([^\s]+)`)
+
+// lastMailedToken returns the token from the last link that was emailed
+func lastMailedToken(t *testing.T) string {
+	matches := tokenInMail.FindAllStringSubmatch(sentMail.String(), -1)
+	if len(matches) == 0 {
+		t.Fatal("no token found in sent mail")
+	}
+
+	token, err := url.QueryUnescape(matches[len(matches)-1][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func postForm(target string, form url.Values, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_application_ForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name        string
+		postedData  url.Values
+		expectedLoc string
+		expectMail  bool
+	}{
+		{"known user", url.Values{"email": {"admin@example.com"}}, "/", true},
+		{"known user in other capitals", url.Values{"email": {"Admin@EXAMPLE.com"}}, "/", true},
+		{"unknown user", url.Values{"email": {"nobody@example.com"}}, "/", false},
+		{"missing email", url.Values{"email": {""}}, "/forgot-password", false},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		rr := postForm("/forgot-password", e.postedData, app.ForgotPassword)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, loc)
+		}
+
+		sent := strings.Contains(sentMail.String(), "http://localhost:9000/reset-password?token=")
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %v, but it was %v", e.name, e.expectMail, sent)
+		}
+	}
+}
+
+func Test_application_ResetPassword(t *testing.T) {
+	sentMail.Reset()
+	rr := postForm("/forgot-password", url.Values{"email": {"admin@example.com"}}, app.ForgotPassword)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("forgot password: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	token := lastMailedToken(t)
+	retry := "/reset-password?token=" + url.QueryEscape(token)
+
+	var tests = []struct {
+		name        string
+		postedData  url.Values
+		expectedLoc string
+	}{
+		{"too short", url.Values{"token": {token}, "password": {"short"}, "confirm_password": {"short"}}, retry},
+		{"mismatch", url.Values{"token": {token}, "password": {"new-password"}, "confirm_password": {"other-password"}}, retry},
+		{"bad token", url.Values{"token": {"nope"}, "password": {"new-password"}, "confirm_password": {"new-password"}}, "/forgot-password"},
+		{"valid", url.Values{"token": {token}, "password": {"new-password"}, "confirm_password": {"new-password"}}, "/"},
+		{"token reused", url.Values{"token": {token}, "password": {"new-password"}, "confirm_password": {"new-password"}}, "/forgot-password"},
+	}
+
+	for _, e := range tests {
+		rr := postForm("/reset-password", e.postedData, app.ResetPassword)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, loc)
+		}
+	}
+}
+
+func Test_application_ResetPasswordPage(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"with token", "/reset-password?token=abc", http.StatusOK},
+		{"without token", "/reset-password", http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.ResetPasswordPage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), `value="abc"`) {
+			t.Errorf("%s: expected the token in a hidden field", e.name)
+		}
+	}
+}
+
+func Test_application_Register(t *testing.T) {
+	valid := url.Values{
+		"first_name":       {"New"},
+		"last_name":        {"User"},
+		"email":            {"new@example.com"},
+		"password":         {"long enough"},
+		"confirm_password": {"long enough"},
+	}
+
+	with := func(field, value string) url.Values {
+		v := url.Values{}
+		for k, vals := range valid {
+			v[k] = vals
+		}
+		v.Set(field, value)
+		return v
+	}
+
+	var tests = []struct {
+		name        string
+		postedData  url.Values
+		expectedLoc string
+		expectMail  bool
+		expectTaken bool
+	}{
+		{"valid", valid, "/", true, false},
+		{"email taken", with("email", "admin@example.com"), "/", false, true},
+		{"email taken in other capitals", with("email", "Admin@Example.com"), "/", false, true},
+		{"bad email", with("email", "not an email"), "/register", false, false},
+		{"short password", with("password", "short"), "/register", false, false},
+		{"passwords differ", with("confirm_password", "something else"), "/register", false, false},
+		{"missing name", with("first_name", ""), "/register", false, false},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		rr := postForm("/register", e.postedData, app.Register)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, loc)
+		}
+
+		sent := strings.Contains(sentMail.String(), "http://localhost:9000/verify-email?token=")
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %v, but it was %v", e.name, e.expectMail, sent)
+		}
+
+		taken := strings.Contains(sentMail.String(), "http://localhost:9000/forgot-password")
+		if taken != e.expectTaken {
+			t.Errorf("%s: expected already registered mail sent to be %v, but it was %v", e.name, e.expectTaken, taken)
+		}
+	}
+}
+
+func Test_application_VerifyEmail(t *testing.T) {
+	sentMail.Reset()
+
+	rr := postForm("/register", url.Values{
+		"first_name":       {"Verify"},
+		"last_name":        {"Me"},
+		"email":            {"verify@example.com"},
+		"password":         {"long enough"},
+		"confirm_password": {"long enough"},
+	}, app.Register)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("register: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	token := lastMailedToken(t)
+
+	login := func() string {
+		rr := postForm("/login", url.Values{"email": {"verify@example.com"}, "password": {"long enough"}}, app.Login)
+		return rr.Header().Get("Location")
+	}
+
+	if loc := login(); loc != "/" {
+		t.Errorf("login before verifying: expected to be sent to / but got %s", loc)
+	}
+
+	var tests = []struct {
+		name      string
+		token     string
+		expectErr bool
+	}{
+		{"wrong token", "not-the-token", true},
+		{"valid", token, false},
+		{"token already used", token, true},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/verify-email?token="+url.QueryEscape(e.token), nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VerifyEmail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if app.Session.Exists(req.Context(), "error") != e.expectErr {
+			t.Errorf("%s: expected an error message to be %v", e.name, e.expectErr)
+		}
+	}
+
+	if loc := login(); loc != "/user/profile" {
+		t.Errorf("login after verifying: expected to be sent to /user/profile but got %s", loc)
+	}
+}
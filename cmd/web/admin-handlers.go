@@ -0,0 +1,365 @@
+package main
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const adminPageSize = 20
+
+// AdminUsers lists users a page at a time. The search box matches an exact email address, or
+// the start of a first or last name.
+func (app *application) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	q := repository.UserQuery{Page: 1, PageSize: adminPageSize, Sort: "last_name"}
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page > 1 {
+		q.Page = page
+	}
+	if strings.Contains(search, "@") {
+		q.Email = search
+	} else {
+		q.NamePrefix = search
+	}
+
+	users, total, err := app.DB.AllUsers(r.Context(), q)
+	if err != nil {
+		app.repositoryError(w, r, "listing users", err)
+		return
+	}
+
+	pageLink := func(page int) string {
+		v := url.Values{"page": {strconv.Itoa(page)}}
+		if search != "" {
+			v.Set("q", search)
+		}
+		return "/admin/users?" + v.Encode()
+	}
+
+	var prev, next string
+	if q.Page > 1 {
+		prev = pageLink(q.Page - 1)
+	}
+	if q.Page*q.PageSize < total {
+		next = pageLink(q.Page + 1)
+	}
+
+	_ = app.render(w, r, "admin-users.page.gohtml", &TemplateData{Data: map[string]any{
+		"users": users,
+		"total": total,
+		"page":  q.Page,
+		"q":     search,
+		"prev":  prev,
+		"next":  next,
+	}})
+}
+
+// AdminNewUserPage shows an empty form for creating a user
+func (app *application) AdminNewUserPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: NewForm(url.Values{})})
+}
+
+// AdminCreateUser creates a user. An admin vouches for the accounts they create, so they
+// start out verified.
+func (app *application) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	app.checkUserForm(r, form, 0)
+	form.Required("password", "confirm_password")
+	checkNewPassword(form)
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	user := userFromForm(form)
+	user.Password = form.Data.Get("password")
+	user.Verified = true
+
+	id, err := app.DB.InsertUser(r.Context(), user)
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		// taken since the form was checked, or by the same address in other capitals
+		app.duplicateEmail(w, r, form, nil)
+		return
+	}
+	if err != nil {
+		app.repositoryError(w, r, "creating user", err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("Created %s %s", user.FirstName, user.LastName))
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+}
+
+// AdminUserPage shows the edit form for one user
+func (app *application) AdminUserPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: adminUserForm(user), Data: map[string]any{"user": user}})
+}
+
+// AdminUpdateUser saves the edit form for one user
+func (app *application) AdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	app.checkUserForm(r, form, user.ID)
+
+	// an admin can't take away their own access, there might be no one left to give it back
+	self := user.ID == app.Session.Get(r.Context(), "user").(data.User).ID
+	if self {
+		form.Check(form.Has("is_admin"), "is_admin", "You can't remove your own admin access")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: form, Data: map[string]any{"user": user}})
+		return
+	}
+
+	updated := userFromForm(form)
+	updated.ID = user.ID
+	// the version the form was filled in from, so another admin's changes since aren't lost
+	updated.Version, _ = strconv.Atoi(form.Data.Get("version"))
+
+	err = app.DB.UpdateUser(r.Context(), updated)
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		app.duplicateEmail(w, r, form, user)
+		return
+	}
+	if stderrors.Is(err, repository.ErrStale) {
+		app.Session.Put(r.Context(), "error", "Someone else changed this user while you were editing, please check their details and save again")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.repositoryError(w, r, "updating user", err)
+		return
+	}
+
+	if self {
+		if err := app.refreshSessionUser(r, user.ID); err != nil {
+			app.Logger.ErrorContext(r.Context(), "refreshing session user", "err", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "Saved the changes")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+}
+
+// AdminResetPassword sets a new password for a user, and ends their api sessions
+func (app *application) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	passwordForm := NewForm(r.PostForm)
+	passwordForm.Required("password", "confirm_password")
+	checkNewPassword(passwordForm)
+
+	if !passwordForm.Valid() {
+		form := adminUserForm(user)
+		form.Errors = passwordForm.Errors
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: form, Data: map[string]any{"user": user}})
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), user.ID, passwordForm.Data.Get("password"))
+	if err != nil {
+		app.repositoryError(w, r, "resetting password", err)
+		return
+	}
+
+	// reset links, api logins and sessions from before the change stop working, except the
+	// admin's own session
+	var keep int
+	if current, ok := app.currentSession(r); ok && current.UserID == user.ID {
+		keep = current.ID
+	}
+	_ = app.DB.DeleteUserTokens(r.Context(), user.ID, data.ScopePasswordReset)
+	_ = app.DB.RevokeUserRefreshTokens(r.Context(), user.ID)
+	_ = app.DB.RevokeUserSessions(r.Context(), user.ID, keep)
+
+	app.Session.Put(r.Context(), "flash", "The password has been reset")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+}
+
+// AdminUnlockUser clears a login lockout, along with the account's failed logins
+func (app *application) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Guard.Unlock(r.Context(), user)
+	if err != nil {
+		app.repositoryError(w, r, "unlocking user", err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "The account has been unlocked")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteUser deletes a user, and the files of the images they uploaded
+func (app *application) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == app.Session.Get(r.Context(), "user").(data.User).ID {
+		app.Session.Put(r.Context(), "error", "You can't delete your own account")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", user.ID), http.StatusSeeOther)
+		return
+	}
+
+	userImages, err := app.Images.Images(r.Context(), user.ID)
+	if err != nil {
+		app.repositoryError(w, r, "listing images", err)
+		return
+	}
+	for _, i := range userImages {
+		if err := app.Images.Delete(r.Context(), user.ID, i.ID); err != nil {
+			app.Logger.ErrorContext(r.Context(), "deleting image", "err", err)
+		}
+	}
+
+	err = app.DB.DeleteUser(r.Context(), user.ID, 0)
+	if err != nil {
+		app.repositoryError(w, r, "deleting user", err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("Deleted %s %s", user.FirstName, user.LastName))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserForm is the edit form for user, filled in with what is saved now
+func adminUserForm(user *data.User) *Form {
+	form := NewForm(url.Values{
+		"first_name": {user.FirstName},
+		"last_name":  {user.LastName},
+		"email":      {user.Email},
+		"version":    {strconv.Itoa(user.Version)},
+	})
+	if user.IsAdmin == 1 {
+		form.Data.Set("is_admin", "1")
+	}
+	return form
+}
+
+// adminTarget loads the user named in the url. When there isn't one it has already written
+// the response, and returns false.
+func (app *application) adminTarget(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	user, err := app.DB.GetUser(r.Context(), id)
+	if stderrors.Is(err, repository.ErrNotFound) {
+		app.Session.Put(r.Context(), "error", "That user doesn't exist")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return nil, false
+	}
+	if err != nil {
+		app.repositoryError(w, r, "loading user", err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// duplicateEmail shows the create or edit form again, for a user whose email address the
+// database turned down as taken
+func (app *application) duplicateEmail(w http.ResponseWriter, r *http.Request, form *Form, user *data.User) {
+	form.Errors.Add("email", "Another account already uses this email address")
+
+	td := &TemplateData{Form: form}
+	if user != nil {
+		td.Data = map[string]any{"user": user}
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	_ = app.render(w, r, "admin-user.page.gohtml", td)
+}
+
+// checkUserForm validates the fields shared by the create and edit forms. id is the user
+// being edited, or 0 for a new one, so their own address doesn't count as taken.
+func (app *application) checkUserForm(r *http.Request, form *Form, id int) {
+	form.Required("first_name", "last_name", "email")
+	for _, field := range []string{"first_name", "last_name"} {
+		if form.Has(field) {
+			form.IsName(field)
+		}
+	}
+	if form.Has("email") {
+		form.IsEmail("email")
+	}
+
+	if form.Errors.Get("email") == "" {
+		existing, err := app.DB.GetUserByEmail(r.Context(), form.Data.Get("email"))
+		form.Check(err != nil || existing.ID == id, "email", "Another account already uses this email address")
+	}
+}
+
+// checkNewPassword checks a password and its confirmation
+func checkNewPassword(form *Form) {
+	if form.Has("password") {
+		form.IsPassword("password", "email")
+	}
+	if form.Has("confirm_password") {
+		form.Check(form.Data.Get("password") == form.Data.Get("confirm_password"), "confirm_password", "Passwords do not match")
+	}
+}
+
+// userFromForm reads the name, email and admin fields of a validated user form
+func userFromForm(form *Form) data.User {
+	user := data.User{
+		FirstName: strings.TrimSpace(form.Data.Get("first_name")),
+		LastName:  strings.TrimSpace(form.Data.Get("last_name")),
+		Email:     form.Data.Get("email"),
+	}
+	if form.Has("is_admin") {
+		user.IsAdmin = 1
+	}
+	return user
+}
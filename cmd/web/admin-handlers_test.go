@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// asAdmin sends a request to an admin handler as the fixture admin, with userID in the url
+func asAdmin(method, target string, userID int, form url.Values, handler http.HandlerFunc) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	admin, _ := app.DB.GetUser(req.Context(), 1)
+	app.Session.Put(req.Context(), "user", *admin)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("userID", strconv.Itoa(userID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, req
+}
+
+func Test_application_AdminCreateUser(t *testing.T) {
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+		expectedText string
+	}{
+		{"valid", url.Values{"first_name": {"New"}, "last_name": {"Person"}, "email": {"new.person@example.com"}, "password": {"password123"}, "confirm_password": {"password123"}}, http.StatusSeeOther, ""},
+		{"missing name", url.Values{"last_name": {"Person"}, "email": {"nameless@example.com"}, "password": {"password123"}, "confirm_password": {"password123"}}, http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"bad email", url.Values{"first_name": {"New"}, "last_name": {"Person"}, "email": {"nope"}, "password": {"password123"}, "confirm_password": {"password123"}}, http.StatusUnprocessableEntity, "Email must be a valid address"},
+		{"taken email", url.Values{"first_name": {"New"}, "last_name": {"Person"}, "email": {"admin@example.com"}, "password": {"password123"}, "confirm_password": {"password123"}}, http.StatusUnprocessableEntity, "Another account already uses this email address"},
+		{"taken email in other capitals", url.Values{"first_name": {"New"}, "last_name": {"Person"}, "email": {"ADMIN@example.com"}, "password": {"password123"}, "confirm_password": {"password123"}}, http.StatusUnprocessableEntity, "Another account already uses this email address"},
+		{"short password", url.Values{"first_name": {"New"}, "last_name": {"Person"}, "email": {"short@example.com"}, "password": {"short"}, "confirm_password": {"short"}}, http.StatusUnprocessableEntity, "Password must be at least"},
+	}
+
+	for _, e := range tests {
+		rr, _ := asAdmin("POST", "/admin/users/new", 0, e.postedData, app.AdminCreateUser)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected the form to show %q", e.name, e.expectedText)
+		}
+
+		// the form keeps what was typed, except the passwords
+		if e.expectedCode != http.StatusSeeOther && strings.Contains(rr.Body.String(), "password123") {
+			t.Errorf("%s: expected the password not to be echoed back", e.name)
+		}
+	}
+
+	created, err := app.DB.GetUserByEmail(context.Background(), "new.person@example.com")
+	if err != nil {
+		t.Fatal("expected the valid user to be created")
+	}
+	if !created.Verified {
+		t.Error("expected a user created by an admin to be verified")
+	}
+}
+
+func Test_application_AdminUsers(t *testing.T) {
+	ctx := context.Background()
+	_, _ = app.DB.InsertUser(ctx, data.User{FirstName: "Searchable", LastName: "Person", Email: "searchable@example.com", Password: "secret"})
+
+	var tests = []struct {
+		name     string
+		query    string
+		expected string
+		missing  string
+	}{
+		{"everyone", "", "admin@example.com", ""},
+		{"by name", "?q=Search", "searchable@example.com", "admin@example.com"},
+		{"by email", "?q=admin@example.com", "admin@example.com", "searchable@example.com"},
+		{"no match", "?q=Zzz", "No users found", "admin@example.com"},
+	}
+
+	for _, e := range tests {
+		rr, _ := asAdmin("GET", "/admin/users"+e.query, 0, nil, app.AdminUsers)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expected) {
+			t.Errorf("%s: expected the page to contain %q", e.name, e.expected)
+		}
+		if e.missing != "" && strings.Contains(rr.Body.String(), e.missing) {
+			t.Errorf("%s: expected the page not to contain %q", e.name, e.missing)
+		}
+	}
+}
+
+func Test_application_AdminManageUser(t *testing.T) {
+	ctx := context.Background()
+	id, _ := app.DB.InsertUser(ctx, data.User{FirstName: "Managed", LastName: "User", Email: "managed@example.com", Password: "secret", Verified: true})
+	target := "/admin/users/" + strconv.Itoa(id)
+
+	// the edit page is filled in
+	rr, _ := asAdmin("GET", target, id, nil, app.AdminUserPage)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="managed@example.com"`) {
+		t.Errorf("edit page: expected the form to be filled in, got status %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `name="version" value="1"`) {
+		t.Error("edit page: expected the form to carry the user's version")
+	}
+
+	// edit, with a mistake first
+	rr, _ = asAdmin("POST", target, id, url.Values{"first_name": {"Renamed"}, "last_name": {"User"}, "email": {"admin@example.com"}}, app.AdminUpdateUser)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "Another account already uses this email address") {
+		t.Errorf("edit with a taken email: expected an inline error, got status %d", rr.Code)
+	}
+
+	rr, _ = asAdmin("POST", target, id, url.Values{"first_name": {"Renamed"}, "last_name": {"User"}, "email": {"managed@example.com"}, "is_admin": {"1"}}, app.AdminUpdateUser)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("edit: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if u, _ := app.DB.GetUser(ctx, id); u.FirstName != "Renamed" || u.IsAdmin != 1 {
+		t.Errorf("edit: expected the changes to be saved, got %s with is_admin %d", u.FirstName, u.IsAdmin)
+	}
+
+	// a form filled in before that edit doesn't overwrite it
+	rr, req := asAdmin("POST", target, id, url.Values{"first_name": {"Stale"}, "last_name": {"User"}, "email": {"managed@example.com"}, "version": {"1"}}, app.AdminUpdateUser)
+	if rr.Code != http.StatusSeeOther || app.Session.GetString(req.Context(), "error") == "" {
+		t.Errorf("stale edit: expected a redirect with an error, got status %d", rr.Code)
+	}
+	if u, _ := app.DB.GetUser(ctx, id); u.FirstName != "Renamed" {
+		t.Errorf("stale edit: expected the earlier changes to be kept, got %s", u.FirstName)
+	}
+
+	// the admin can't demote themselves
+	rr, _ = asAdmin("POST", "/admin/users/1", 1, url.Values{"first_name": {"Admin"}, "last_name": {"User"}, "email": {"admin@example.com"}}, app.AdminUpdateUser)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("demote self: expected status %d but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// reset the password
+	rr, _ = asAdmin("POST", target+"/password", id, url.Values{"password": {"new password"}, "confirm_password": {"not the same"}}, app.AdminResetPassword)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "Passwords do not match") {
+		t.Errorf("reset password with a mismatch: expected an inline error, got status %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `name="version" value="2"`) {
+		t.Error("reset password with a mismatch: expected the form to still carry the user's version")
+	}
+
+	refreshID, _ := app.DB.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, TokenHash: data.HashToken("managed refresh"), FamilyID: "managed", ExpiresAt: time.Now().Add(time.Hour)})
+
+	rr, _ = asAdmin("POST", target+"/password", id, url.Values{"password": {"new password"}, "confirm_password": {"new password"}}, app.AdminResetPassword)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("reset password: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if rt, _ := app.DB.GetRefreshToken(ctx, data.HashToken("managed refresh")); rt == nil || !rt.Revoked {
+		t.Errorf("reset password: expected refresh token %d to be revoked", refreshID)
+	}
+
+	// unlock
+	_ = app.DB.LockUser(ctx, id, time.Now().Add(time.Hour))
+	rr, _ = asAdmin("POST", target+"/unlock", id, nil, app.AdminUnlockUser)
+	if u, _ := app.DB.GetUser(ctx, id); rr.Code != http.StatusSeeOther || u.IsLocked() {
+		t.Errorf("unlock: expected the account to be unlocked, got status %d", rr.Code)
+	}
+
+	// delete, but not yourself
+	rr, req = asAdmin("POST", "/admin/users/1/delete", 1, nil, app.AdminDeleteUser)
+	if app.Session.GetString(req.Context(), "error") == "" {
+		t.Error("delete self: expected an error")
+	}
+	if _, err := app.DB.GetUser(ctx, 1); err != nil {
+		t.Error("delete self: expected the admin to still exist")
+	}
+
+	rr, _ = asAdmin("POST", target+"/delete", id, nil, app.AdminDeleteUser)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("delete: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if _, err := app.DB.GetUser(ctx, id); err == nil {
+		t.Error("delete: expected the user to be gone")
+	}
+
+	// a user that doesn't exist
+	rr, _ = asAdmin("GET", target, id, nil, app.AdminUserPage)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.Path != "/admin/users" {
+		t.Errorf("missing user: expected a redirect to the list, got status %d", rr.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfField      = "csrf_token"   // the hidden form field every post form carries
+	csrfHeader     = "X-CSRF-Token" // for scripts, which can send a header instead
+)
+
+// how much of a multipart body is read looking for the token, which comes first in the form
+const csrfPeekBytes = 8 << 10
+
+// csrfToken returns the session's synchronizer token, creating one the first time. Every form
+// a page renders posts it back, and csrf checks it against the session.
+func (app *application) csrfToken(ctx context.Context) string {
+	if token := app.Session.GetString(ctx, csrfSessionKey); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	app.Session.Put(ctx, csrfSessionKey, token)
+	return token
+}
+
+// csrf turns away state-changing requests that don't carry the session's token, so another
+// site can't post a form on behalf of someone logged in here. GET, HEAD and OPTIONS requests
+// don't change anything and are let through.
+func (app *application) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected := app.Session.GetString(r.Context(), csrfSessionKey)
+		sent := requestCSRFToken(r)
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(sent)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			_ = app.render(w, r, "csrf.page.gohtml", &TemplateData{})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestCSRFToken finds the token in the header or the form. A multipart body isn't parsed,
+// since the upload handlers apply their own limits when they do that: only the first part is
+// read, and the body is put back together for the handler.
+func requestCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.PostFormValue(csrfField)
+	}
+
+	var read bytes.Buffer
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(r.Body, csrfPeekBytes), &read), params["boundary"])
+
+	var token string
+	if part, err := mr.NextPart(); err == nil && part.FormName() == csrfField {
+		b, _ := io.ReadAll(io.LimitReader(part, 256))
+		token = string(b)
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&read, r.Body), r.Body}
+
+	return token
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_application_csrf(t *testing.T) {
+	var body string
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	})
+
+	// a multipart body with the token first, the way the templates lay out the upload form
+	multipartBody := func(token string) (string, string) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		_ = mw.WriteField(csrfField, token)
+		fw, _ := mw.CreateFormFile("image", "picture.png")
+		_, _ = fw.Write([]byte("not really a picture"))
+		_ = mw.Close()
+		return buf.String(), mw.FormDataContentType()
+	}
+
+	var tests = []struct {
+		name         string
+		method       string
+		token        func(sessionToken string) (body, contentType, header string)
+		expectedCode int
+	}{
+		{"get", "GET", func(string) (string, string, string) { return "", "", "" }, http.StatusOK},
+		{"post without a token", "POST", func(string) (string, string, string) {
+			return "email=a@example.com", "application/x-www-form-urlencoded", ""
+		}, http.StatusForbidden},
+		{"post with the wrong token", "POST", func(string) (string, string, string) {
+			return url.Values{csrfField: {"guessed"}}.Encode(), "application/x-www-form-urlencoded", ""
+		}, http.StatusForbidden},
+		{"post with the form token", "POST", func(token string) (string, string, string) {
+			return url.Values{csrfField: {token}}.Encode(), "application/x-www-form-urlencoded", ""
+		}, http.StatusOK},
+		{"post with the header", "POST", func(token string) (string, string, string) {
+			return "", "", token
+		}, http.StatusOK},
+		{"multipart with the token", "POST", func(token string) (string, string, string) {
+			b, ct := multipartBody(token)
+			return b, ct, ""
+		}, http.StatusOK},
+		{"multipart with the wrong token", "POST", func(token string) (string, string, string) {
+			b, ct := multipartBody("guessed")
+			return b, ct, ""
+		}, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		// a session that has rendered a page, and so has a token
+		req := httptest.NewRequest(e.method, "/", nil)
+		req = addContextAndSessionToRequest(req, app)
+		sessionToken := app.csrfToken(req.Context())
+
+		sent, contentType, header := e.token(sessionToken)
+		req.Body = io.NopCloser(strings.NewReader(sent))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+
+		body = ""
+		rr := httptest.NewRecorder()
+		app.csrf(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusForbidden && !strings.Contains(rr.Body.String(), "This form has expired") {
+			t.Errorf("%s: expected the error page", e.name)
+		}
+
+		// the handler still gets the whole multipart body to parse
+		if e.expectedCode == http.StatusOK && strings.HasPrefix(contentType, "multipart/") && body != sent {
+			t.Errorf("%s: expected the handler to read the body unchanged", e.name)
+		}
+	}
+}
+
+func Test_application_csrfTokenInTemplates(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+
+	rr := httptest.NewRecorder()
+	app.Home(rr, req)
+
+	token := app.Session.GetString(req.Context(), csrfSessionKey)
+	if token == "" {
+		t.Fatal("expected rendering a page to create a csrf token")
+	}
+	if !strings.Contains(rr.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Error("expected the login form to carry the csrf token")
+	}
+}
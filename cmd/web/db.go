@@ -2,7 +2,9 @@ package main
 
 import (
 	"database/sql"
-	"log"
+	stderrors "errors" // errors is the form errors type in this package
+	"net/http"
+	"webapp/pkg/repository"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -32,6 +34,30 @@ func (app *application) connectToDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("Connected to Postgres!")
+	app.Logger.Info("connected to postgres")
 	return connection, nil
 }
+
+// repositoryStatus picks the status for a failed repository call: 404, 409 or 422 for the
+// errors that say something about the request, 500 for the rest
+func repositoryStatus(err error) int {
+	switch {
+	case stderrors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case stderrors.Is(err, repository.ErrDuplicateEmail), stderrors.Is(err, repository.ErrConflict), stderrors.Is(err, repository.ErrStale):
+		return http.StatusConflict
+	case stderrors.Is(err, repository.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// repositoryError answers a failed repository call with its status text, never the database's
+// message, and logs the failures that are the server's fault
+func (app *application) repositoryError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	status := repositoryStatus(err)
+	if status == http.StatusInternalServerError {
+		app.Logger.ErrorContext(r.Context(), action, "err", err)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/url"
 	"strings"
+	"webapp/pkg/validate"
 )
 
 type errors map[string][]string
@@ -54,6 +55,52 @@ func (f *Form) Check(ok bool, key, message string) {
 	}
 }
 
+// Validate records the outcome of one of the validate package's checks against a field, with
+// the field's label in front, e.g. "Password must be at least 8 characters long"
+func (f *Form) Validate(field string, err error) {
+	if err != nil {
+		f.Errors.Add(field, label(field)+" "+err.Error())
+	}
+}
+
+// IsEmail checks that the field holds a single plain email address, and normalises it
+func (f *Form) IsEmail(field string) {
+	f.normalise(field, validate.Email)
+}
+
+// IsName checks and trims first or last name fields
+func (f *Form) IsName(fields ...string) {
+	for _, field := range fields {
+		f.normalise(field, validate.Name)
+	}
+}
+
+// IsPassword checks that the field holds a strong enough new password. emailField names the
+// field with the account's email address, if the form has one.
+func (f *Form) IsPassword(field, emailField string) {
+	f.Validate(field, validate.Password(f.Data.Get(field), f.Data.Get(emailField)))
+}
+
+// normalise runs a validate check on a field, replacing its value with the normalised one
+// when it passes
+func (f *Form) normalise(field string, check func(string) (string, error)) {
+	value, err := check(f.Data.Get(field))
+	if err != nil {
+		f.Validate(field, err)
+		return
+	}
+	f.Data.Set(field, value)
+}
+
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
 }
+
+// label turns a field name into words for an error message: first_name becomes "First name"
+func label(field string) string {
+	words := strings.ReplaceAll(field, "_", " ")
+	if words == "" {
+		return ""
+	}
+	return strings.ToUpper(words[:1]) + words[1:]
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -70,6 +71,28 @@ func TestForm_Check(t *testing.T) {
 	}
 }
 
+func TestForm_IsEmail(t *testing.T) {
+	var tests = []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"plain address", "me@example.com", true},
+		{"no at sign", "me.example.com", false},
+		{"with display name", "Me <me@example.com>", false},
+		{"empty", "", false},
+	}
+
+	for _, e := range tests {
+		form := NewForm(url.Values{"email": {e.value}})
+		form.IsEmail("email")
+
+		if form.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %v, but got %v", e.name, e.valid, form.Valid())
+		}
+	}
+}
+
 func TestForm_ErrorGet(t *testing.T) {
 	form := NewForm(nil)
 	form.Check(false, "password", "password is invalid")
@@ -84,3 +107,40 @@ func TestForm_ErrorGet(t *testing.T) {
 		t.Error("Should not have an error returned from Get, but did")
 	}
 }
+
+func TestForm_IsName(t *testing.T) {
+	form := NewForm(url.Values{"first_name": {"  Ada "}, "last_name": {"Love\nlace"}})
+	form.IsName("first_name", "last_name")
+
+	if form.Data.Get("first_name") != "Ada" {
+		t.Errorf("expected the first name to be trimmed, got %q", form.Data.Get("first_name"))
+	}
+	if form.Errors.Get("first_name") != "" {
+		t.Errorf("did not expect an error for first_name, got %q", form.Errors.Get("first_name"))
+	}
+	if msg := form.Errors.Get("last_name"); !strings.HasPrefix(msg, "Last name ") {
+		t.Errorf("expected a labelled error for last_name, got %q", msg)
+	}
+}
+
+func TestForm_IsPassword(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"strong enough", "correct horse", true},
+		{"too short", "short", false},
+		{"common", "password", false},
+		{"own email", "me@example.com", false},
+	}
+
+	for _, e := range tests {
+		form := NewForm(url.Values{"password": {e.password}, "email": {"me@example.com"}})
+		form.IsPassword("password", "email")
+
+		if form.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %v, but got %v", e.name, e.valid, form.Valid())
+		}
+	}
+}
@@ -1,20 +1,26 @@
 package main
 
 import (
-	"fmt"
+	stderrors "errors"
 	"html/template"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
+	"strconv"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/images"
+	"webapp/pkg/metrics"
+	"webapp/pkg/storage"
+	"webapp/pkg/throttle"
+
+	"github.com/go-chi/chi/v5"
 )
 
 var pathToTemplates = "./templates/"
-var uploadPath = "./static/img"
+
+// how long the signed image urls on a page keep working
+var imageURLExpiry = time.Hour
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	var templateData = make(map[string]any)
@@ -28,22 +34,35 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
 
-	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
+	// every image the user has uploaded, to switch back to or delete
+	userImages, err := app.Images.Images(r.Context(), user.ID)
+	if err != nil {
+		app.repositoryError(w, r, "listing images", err)
+		return
+	}
 
+	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Data: map[string]any{"images": userImages}})
 }
 
 type TemplateData struct {
-	IP    string
-	Data  map[string]any
-	Error string
-	Flash string
-	User  data.User // currently authenticated user
+	IP        string
+	Data      map[string]any
+	Error     string
+	Flash     string
+	User      data.User // currently authenticated user
+	Form      *Form     // the submitted form, to show its values and field errors
+	CSRFToken string    // posted back by every form on the page
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, tmpl string, td *TemplateData) error {
 	// parse the template from disk
-	parsedTemplate, err := template.ParseFiles(path.Join(pathToTemplates, tmpl), path.Join(pathToTemplates, "base.layout.gohtml"))
+	parsedTemplate, err := template.New(tmpl).Funcs(template.FuncMap{
+		"imageURL": func(key string) (string, error) {
+			return app.Storage.SignedURL(r.Context(), key, imageURLExpiry)
+		},
+	}).ParseFiles(path.Join(pathToTemplates, tmpl), path.Join(pathToTemplates, "base.layout.gohtml"))
 
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -51,6 +70,7 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, tmpl stri
 	}
 
 	td.IP = app.ipFromContext(r.Context())
+	td.CSRFToken = app.csrfToken(r.Context())
 
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -71,7 +91,7 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, tmpl stri
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		app.Logger.ErrorContext(r.Context(), "parsing login form", "err", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
@@ -90,26 +110,83 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
+	ip := app.ipFromContext(r.Context())
 
-	user, err := app.DB.GetUserByEmail(email)
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
 	if err != nil {
-		app.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		user = nil
+	}
+
+	// refuse clients and accounts that are backing off before spending time on bcrypt
+	if blocked := app.Guard.Check(ip, email, user); blocked != nil {
+		app.Metrics.Login(metrics.LoginBlocked)
+		app.loginBlocked(w, r, blocked)
 		return
 	}
 
-	log.Println(password, user.FirstName)
+	if user == nil {
+		app.loginFailed(w, r, ip, email, nil, metrics.LoginUnknownUser)
+		return
+	}
+
+	// unverified accounts can't log in yet. Only say why to someone who has the password, so
+	// the message doesn't reveal which addresses have signed up
+	if !user.Verified {
+		if valid, _ := user.PasswordMatches(password); !valid {
+			app.loginFailed(w, r, ip, email, user, metrics.LoginBadPassword)
+			return
+		}
+		app.Metrics.Login(metrics.LoginUnverified)
+		app.Session.Put(r.Context(), "error", "Please confirm your email address before logging in, we've emailed you a link")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	// authenticate user
 	// if not authenticated, redirect with error
-	if !app.authenticate(r, user, password) {
-		app.Session.Put(r.Context(), "error", "Invalid login!")
+	if !app.authenticate(user, password) {
+		app.loginFailed(w, r, ip, email, user, metrics.LoginBadPassword)
+		return
+	}
+
+	mfaEnabled, err := app.MFA.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Something went wrong, please try again")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+
 	// prevent session fixation attack
 	// we renew session token every time page is reloaded
 	_ = app.Session.RenewToken(r.Context())
+	// and the csrf token along with it, the next page gets a new one
+	app.Session.Remove(r.Context(), csrfSessionKey)
+
+	// with two-factor on, the user isn't logged in until they give a code, so only their id
+	// goes in the session for now
+	if mfaEnabled {
+		app.Metrics.Login(metrics.LoginMFARequired)
+		app.Session.Remove(r.Context(), "user")
+		app.Session.Put(r.Context(), "mfa_user_id", user.ID)
+		app.Session.Put(r.Context(), "mfa_expires", time.Now().Add(mfaLoginExpiry).Unix())
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	// the failures are only cleared once the whole login has succeeded, so a stolen password
+	// doesn't buy unlimited guesses at the code
+	_ = app.Guard.Succeeded(r.Context(), ip, email, user)
+	app.Metrics.Login(metrics.LoginSuccess)
+	app.Session.Put(r.Context(), "user", *user)
+
+	err = app.recordSession(r, user.ID)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "recording session", "err", err)
+		app.Session.Remove(r.Context(), "user")
+		app.Session.Put(r.Context(), "error", "Something went wrong, please try again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	// store success message in session
 
@@ -118,100 +195,136 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
-func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
+// loginFailed counts a failed login with outcome, records it with the guard, and sends the
+// user back to the login form
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, ip, email string, user *data.User, outcome string) {
+	app.Metrics.Login(outcome)
+
+	blocked, err := app.Guard.Failed(r.Context(), ip, email, user)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "recording failed login", "err", err)
+	}
+	if blocked != nil {
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	app.Session.Put(r.Context(), "error", "Invalid login credentials")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// loginBlocked shows the login form again with the 429 or 423 status of a refused attempt
+func (app *application) loginBlocked(w http.ResponseWriter, r *http.Request, blocked *throttle.BlockedError) {
+	app.Session.Put(r.Context(), "error", blocked.Error())
+	w.Header().Set("Retry-After", blocked.RetryAfterHeader())
+	w.WriteHeader(blocked.StatusCode())
+	_ = app.render(w, r, "home.page.gohtml", &TemplateData{})
+}
+
+func (app *application) authenticate(user *data.User, password string) bool {
 	if valid, err := user.PasswordMatches(password); err != nil || !valid {
 		return false
 	}
-	app.Session.Put(r.Context(), "user", user)
 	return true
 }
 
 func (app *application) UploadProfilePicture(w http.ResponseWriter, r *http.Request) {
+	// get the user from the session
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
 	// call a function that extracts a file from an upload (request)
-	files, err := app.UploadFiles(r, uploadPath)
+	files, err := app.UploadFiles(r, user.ID)
+	var uploadErr *UploadError
+	if stderrors.As(err, &uploadErr) {
+		// something the user can fix, so say what on the profile page
+		app.Session.Put(r.Context(), "error", uploadErr.Error())
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.Logger.ErrorContext(r.Context(), "uploading profile picture", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// get the user from the session
-	user := app.Session.Get(r.Context(), "user").(data.User)
+	// a user image for every file, with every size that was generated. Each one becomes the
+	// profile picture as it is inserted, so the last file uploaded is the one left showing.
+	for k, file := range files {
+		var i = data.UserImage{
+			UserID:   user.ID,
+			Variants: file.Variants,
+		}
+		if original := i.Variant(images.Original); original != nil {
+			i.FileName = original.FileName
+		}
 
-	// create a variable of type data.UserImage
-	var i = data.UserImage{
-		UserID:   user.ID,
-		FileName: files[0].OriginalFileName,
-	}
+		_, err = app.DB.InsertUserImage(r.Context(), i)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "inserting user image", "err", err)
 
-	// insert a user image into user_images
-	_, err = app.DB.InsertUserImage(i)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+			// the files already stored for this one and the rest would never be seen again
+			var orphaned []data.ImageVariant
+			for _, f := range files[k:] {
+				orphaned = append(orphaned, f.Variants...)
+			}
+			if err := app.Images.Discard(r.Context(), user.ID, orphaned); err != nil {
+				app.Logger.ErrorContext(r.Context(), "discarding uploaded files", "err", err)
+			}
+
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// refrest the sessional variable "user" with the now correct user info with user image
-	updatedUser, err := app.DB.GetUser(user.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// refresh the session user, so it has the new picture
+	if err := app.refreshSessionUser(r, user.ID); err != nil {
+		app.Logger.ErrorContext(r.Context(), "refreshing session user", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	app.Session.Put(r.Context(), "user", updatedUser)
-
 	// redirect back to the profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
-type UploadedFile struct {
-	OriginalFileName string
-	FileSize         int64
-}
-
-func (app *application) UploadFiles(r *http.Request, uploadDir string) ([]*UploadedFile, error) {
-	var uploadedFiles []*UploadedFile
+// ServeImage streams an uploaded image out of the local storage backend. Only signed urls are
+// served, so an image can't be fetched by someone who only knows, or guesses, its name. Caches
+// may keep a response only as long as its url stays valid, and only in the browser, so neither
+// outlives the signature. Other backends hand out their own signed urls, and aren't served from here.
+func (app *application) ServeImage(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
 
-	err := r.ParseMultipartForm(int64(1024 * 1024 * 5)) // MAXSIZE 5MB
+	local, ok := app.Storage.(*storage.Local)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := local.Verify(key, r.URL.Query()); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
+	obj, err := app.Storage.Get(r.Context(), key)
+	if stderrors.Is(err, storage.ErrNotFound) || stderrors.Is(err, storage.ErrInvalidKey) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		return nil, fmt.Errorf("the uploaded file is too big, must be less than %d bytes", 1024*1024*5)
+		app.Logger.ErrorContext(r.Context(), "reading image", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-
-				uploadedFile.OriginalFileName = hdr.Filename
-
-				var outFile *os.File
-				defer outFile.Close()
-
-				if outFile, err = os.Create(filepath.Join(uploadDir, uploadedFile.OriginalFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outFile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-
-				return uploadedFiles, nil
-			}(uploadedFiles)
-
-			if err != nil {
-				return uploadedFiles, err
-			}
-		}
+	defer obj.Body.Close()
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Verify has already checked expires is a time still to come
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	maxAge := max(expires-time.Now().Unix(), 0)
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(maxAge, 10))
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
 	}
 
-	return uploadedFiles, nil
+	_, _ = io.Copy(w, obj.Body)
 }
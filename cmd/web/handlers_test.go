@@ -4,21 +4,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	stderrors "errors"
+	"flag"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"webapp/pkg/data"
-
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
-	"path"
 	"strings"
-	"sync"
 	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/storage"
+	"webapp/pkg/throttle"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -182,6 +183,15 @@ func Test_application_Login(t *testing.T) {
 			expectedStatusCode: http.StatusSeeOther,
 			expectedLoc:        "/user/profile",
 		},
+		{
+			name: "email in other capitals",
+			postedData: url.Values{
+				"email":    {"Admin@EXAMPLE.com"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLoc:        "/user/profile",
+		},
 		{
 			name: "missing form data",
 			postedData: url.Values{
@@ -233,74 +243,51 @@ func Test_application_Login(t *testing.T) {
 	}
 }
 
-func Test_application_UploadFiles(t *testing.T) {
-	// set up pipes
-	pr, pw := io.Pipe() // dummy reader and writer
+func Test_application_LoginThrottled(t *testing.T) {
+	// a guard of our own, so the failures here don't leak into other tests
+	defer func(g *throttle.Guard) { app.Guard = g }(app.Guard)
+	app.Guard = throttle.NewGuard(app.DB)
+	app.Guard.MaxFailures = 2
+	app.Guard.Accounts = throttle.NewLimiter(100, time.Second, time.Minute)
 
-	// create new writer of type *io.Writer
-	writer := multipart.NewWriter(pw)
-
-	// simulate uploading file using goroutine and writer, concurrent
-	// start a go routine that runs concurrent with current request
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-
-	go simulateFileUpload("./testdata/test.jpg", writer, t, wg)
-
-	// read from pipe, which will recieve data
-	request := httptest.NewRequest("POST", "/", pr)
-	request.Header.Add("Content-Type", writer.FormDataContentType())
-
-	// call app.UploadFiles
-	uploadedFiles, err := app.UploadFiles(request, "./testdata/uploads/")
-	if err != nil {
-		t.Error(err)
-	}
-	// perform tests
-	// check to see uploaded file exists, error if not
-	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName)); os.IsNotExist(err) {
-		t.Errorf("expected file to exist: %s", err.Error())
-	}
-
-	// clean up
-	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName))
-	wg.Wait()
-}
+	_, _ = app.DB.InsertUser(context.Background(), data.User{Email: "throttled@example.com", Password: "secret", Verified: true})
 
-func simulateFileUpload(fileToUpload string, writer *multipart.Writer, t *testing.T, wg *sync.WaitGroup) {
-	defer writer.Close()
-	defer wg.Done()
-
-	// create the form data field `file`, with value fileName
-
-	part, err := writer.CreateFormFile("file", path.Base(fileToUpload))
-	if err != nil {
-		t.Error(err)
+	var tests = []struct {
+		name               string
+		password           string
+		expectedStatusCode int
+	}{
+		{"wrong password", "wrong", http.StatusSeeOther},
+		{"wrong password locks", "wrong", http.StatusLocked},
+		{"right password while locked", "secret", http.StatusLocked},
 	}
 
-	// open the actual file
-	f, err := os.Open(fileToUpload)
-	if err != nil {
-		t.Error(err)
-	}
+	for _, e := range tests {
+		postedData := url.Values{"email": {"throttled@example.com"}, "password": {e.password}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
 
-	defer f.Close()
+		handler := http.HandlerFunc(app.Login)
+		handler.ServeHTTP(rr, req)
 
-	// decode the image
-	img, _, err := image.Decode(f)
-	if err != nil {
-		t.Error("Error decoding the image", err)
-	}
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
 
-	// write the image to io.Writer
-	err = jpeg.Encode(part, img, nil)
-	if err != nil {
-		t.Error(err)
+		if rr.Code == http.StatusLocked {
+			if rr.Header().Get("Retry-After") == "" {
+				t.Errorf("%s: expected a Retry-After header", e.name)
+			}
+			if !strings.Contains(rr.Body.String(), "account locked") {
+				t.Errorf("%s: expected the login page with the lock message", e.name)
+			}
+		}
 	}
 }
 
 func Test_application_UploadProfilePicture(t *testing.T) {
-	uploadPath = "./testdata/uploads"
 	filePath := "./testdata/test.jpg"
 
 	//specify a field name for the form
@@ -343,5 +330,218 @@ func Test_application_UploadProfilePicture(t *testing.T) {
 		t.Errorf("wrong status code")
 	}
 
-	_ = os.Remove("./testdata/uploads/test.jpg")
+	user := app.Session.Get(req.Context(), "user").(data.User)
+
+	// the profile page shows the medium variant
+	profile := httptest.NewRecorder()
+	app.Profile(profile, req)
+	if medium := user.ProfilePic.Variant("medium"); medium == nil || !strings.Contains(profile.Body.String(), medium.FileName) {
+		t.Error("expected the profile page to show the medium variant")
+	}
+	if !strings.Contains(profile.Body.String(), "signature=") {
+		t.Error("expected the profile page to link to signed image urls")
+	}
+
+	for _, name := range []string{"thumbnail", "medium", "original"} {
+		if user.ProfilePic.Variant(name) == nil {
+			t.Errorf("expected the profile pic to have a %s variant", name)
+		}
+	}
+
+	_ = os.RemoveAll("./testdata/uploads/users")
+}
+
+func Test_application_UploadProfilePicture_severalFiles(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := app.Uploads
+	defer func() { app.Uploads = defaults }()
+	app.Uploads.MaxFiles = 2
+
+	id, _ := app.DB.InsertUser(context.Background(), data.User{Email: "several@example.com", Password: "secret"})
+
+	req := uploadRequest(t, map[string][]byte{"a.jpg": jpg, "b.jpg": jpg})
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: id})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.UploadProfilePicture).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	// every file stored is an image the user can see and delete, none are left orphaned
+	userImages, _ := app.DB.GetUserImages(context.Background(), id)
+	if len(userImages) != 2 {
+		t.Errorf("expected an image for each of the 2 files, got %d", len(userImages))
+	}
+
+	_ = os.RemoveAll("./testdata/uploads/users")
+}
+
+// failingImageRepo is a repository that can't record uploaded images
+type failingImageRepo struct {
+	repository.DatabaseRepo
+}
+
+func (failingImageRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	return 0, stderrors.New("connection refused")
+}
+
+func Test_application_UploadProfilePicture_insertFails(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user with no images yet, so none of the stored files are in use
+	id, _ := app.DB.InsertUser(context.Background(), data.User{Email: "insert-fails@example.com", Password: "secret"})
+
+	defer func(db repository.DatabaseRepo) { app.DB = db }(app.DB)
+	app.DB = failingImageRepo{app.DB}
+
+	req := uploadRequest(t, map[string][]byte{"a.jpg": jpg})
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: id})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.UploadProfilePicture).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "connection refused") {
+		t.Errorf("the database error leaked into the response: %q", rr.Body.String())
+	}
+
+	// the files stored for the upload went with it
+	entries, _ := os.ReadDir(fmt.Sprintf("./testdata/uploads/users/%d", id))
+	if len(entries) != 0 {
+		t.Errorf("expected the stored files to be deleted, found %d", len(entries))
+	}
+
+	_ = os.RemoveAll("./testdata/uploads/users")
+}
+
+func Test_application_UploadProfilePicture_notAnImage(t *testing.T) {
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	// a script with an image name and content type still has to look like an image
+	w, err := mw.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="file"; filename="evil.jpg"`},
+		"Content-Type":        {"image/jpeg"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("<script>alert('hi')</script>"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.UploadProfilePicture)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	if msg := app.Session.GetString(req.Context(), "error"); !strings.Contains(msg, "evil.jpg") {
+		t.Errorf("expected an error about evil.jpg in the session but got %q", msg)
+	}
+
+	entries, _ := os.ReadDir("./testdata/uploads")
+	for _, e := range entries {
+		if e.Name() != ".gitkeep" {
+			t.Errorf("expected nothing to be written, found %s", e.Name())
+		}
+	}
+}
+
+func Test_application_ServeImage(t *testing.T) {
+	ctx := context.Background()
+	_ = app.Storage.Put(ctx, "served.png", strings.NewReader("png bytes"), "image/png")
+	defer app.Storage.Delete(ctx, "served.png")
+
+	signed, _ := app.Storage.SignedURL(ctx, "served.png", time.Minute)
+	signedURL, _ := url.Parse(signed)
+	missing, _ := app.Storage.SignedURL(ctx, "missing.png", time.Minute)
+	missingURL, _ := url.Parse(missing)
+
+	var tests = []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{"signed url", signedURL.RequestURI(), http.StatusOK},
+		{"unsigned url", "/images/served.png", http.StatusForbidden},
+		{"bad signature", "/images/served.png?expires=9999999999&signature=abc", http.StatusForbidden},
+		{"missing image", missingURL.RequestURI(), http.StatusNotFound},
+		{"outside the store", "/images/..%2Fhandlers.go", http.StatusForbidden},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if e.expectedStatus == http.StatusOK {
+			if rr.Body.String() != "png bytes" || rr.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("%s: got %q of type %s", e.name, rr.Body.String(), rr.Header().Get("Content-Type"))
+			}
+
+			// cached privately, and no longer than the url's minute
+			cacheControl := rr.Header().Get("Cache-Control")
+			var maxAge int
+			if _, err := fmt.Sscanf(cacheControl, "private, max-age=%d", &maxAge); err != nil || maxAge < 1 || maxAge > 60 {
+				t.Errorf("%s: expected private caching for at most a minute, got %q", e.name, cacheControl)
+			}
+		}
+	}
+}
+
+func Test_application_uploadsNotStatic(t *testing.T) {
+	// the local store with its default settings, as the app runs without flags
+	var cfg storage.Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	_ = fs.Parse(nil)
+
+	if _, err := os.Stat(cfg.Dir); os.IsNotExist(err) {
+		defer os.RemoveAll(cfg.Dir)
+	}
+
+	store, err := storage.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Put(context.Background(), "users/1/uploaded.png", strings.NewReader("png bytes"), "image/png")
+	defer store.Delete(context.Background(), "users/1/uploaded.png")
+
+	// uploads are only served through signed urls, never as static files or listings
+	routes := app.routes()
+	for _, u := range []string{"/static/img/users/1/uploaded.png", "/static/img/", "/static/img/users/1/", "/static/users/1/uploaded.png"} {
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, httptest.NewRequest("GET", u, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d but got %d", u, http.StatusNotFound, rr.Code)
+		}
+	}
 }
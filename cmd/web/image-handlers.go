@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"webapp/pkg/data"
+	"webapp/pkg/images"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ActivateImage makes one of the user's earlier images their profile picture again
+func (app *application) ActivateImage(w http.ResponseWriter, r *http.Request) {
+	app.changeImage(w, r, app.Images.Activate, "Profile picture changed")
+}
+
+// DeleteImage removes one of the user's images, along with its stored files
+func (app *application) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	app.changeImage(w, r, app.Images.Delete, "Image deleted")
+}
+
+// changeImage runs change for the image in the url, then refreshes the session user so the
+// profile page shows the right picture
+func (app *application) changeImage(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, imageID int) error, flash string) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	imageID, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	err = change(r.Context(), user.ID, imageID)
+	if err == images.ErrNotFound {
+		app.Session.Put(r.Context(), "error", "That image doesn't exist")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "changing image", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.refreshSessionUser(r, user.ID); err != nil {
+		app.Logger.ErrorContext(r.Context(), "refreshing session user", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// refreshSessionUser reloads the logged in user, after a change to what the session copy holds
+func (app *application) refreshSessionUser(r *http.Request, id int) error {
+	user, err := app.DB.GetUser(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	app.Session.Put(r.Context(), "user", *user)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// changeImageAs posts to an image handler as user, with imageID in the url
+func changeImageAs(user data.User, imageID int, handler http.HandlerFunc) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest("POST", "/user/images/"+strconv.Itoa(imageID)+"/delete", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", user)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("imageID", strconv.Itoa(imageID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, req
+}
+
+func Test_application_ImageHistory(t *testing.T) {
+	ctx := context.Background()
+	id, _ := app.DB.InsertUser(ctx, data.User{FirstName: "Has", LastName: "Pictures", Email: "pictures@example.com", Password: "secret", Verified: true})
+	defer app.Storage.Delete(ctx, "users/pictures/new.png")
+
+	// an older and a newer picture, the newer one active
+	var imageIDs []int
+	for _, name := range []string{"old", "new"} {
+		key := "users/pictures/" + name + ".png"
+		_ = app.Storage.Put(ctx, key, strings.NewReader(name), "image/png")
+		imageID, _ := app.DB.InsertUserImage(ctx, data.UserImage{
+			UserID:   id,
+			FileName: key,
+			Variants: []data.ImageVariant{{Variant: "thumbnail", FileName: key, Width: 150, Height: 150}},
+		})
+		imageIDs = append(imageIDs, imageID)
+	}
+	oldID, newID := imageIDs[0], imageIDs[1]
+
+	user, _ := app.DB.GetUser(ctx, id)
+
+	// the profile page lists both
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", *user)
+	app.Profile(rr, req)
+
+	for _, imageID := range imageIDs {
+		if !strings.Contains(rr.Body.String(), "/user/images/"+strconv.Itoa(imageID)+"/delete") {
+			t.Errorf("expected the profile page to list image %d", imageID)
+		}
+	}
+
+	// switch back to the old one
+	rr, req = changeImageAs(*user, oldID, app.ActivateImage)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("activate: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if u := app.Session.Get(req.Context(), "user").(data.User); u.ProfilePic.ID != oldID {
+		t.Errorf("activate: expected the session user to have image %d, got %d", oldID, u.ProfilePic.ID)
+	}
+
+	// delete it, which takes its file with it
+	rr, req = changeImageAs(*user, oldID, app.DeleteImage)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("delete: expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if u := app.Session.Get(req.Context(), "user").(data.User); u.ProfilePic.ID != 0 {
+		t.Errorf("delete: expected no profile picture left, got %d", u.ProfilePic.ID)
+	}
+	if _, err := app.Storage.Get(ctx, "users/pictures/old.png"); err == nil {
+		t.Error("delete: expected the stored file to be removed")
+	}
+
+	// another user's image is not found
+	admin, _ := app.DB.GetUser(ctx, 1)
+	rr, req = changeImageAs(*admin, newID, app.DeleteImage)
+	if msg := app.Session.GetString(req.Context(), "error"); rr.Code != http.StatusSeeOther || msg == "" {
+		t.Errorf("someone else's image: expected a redirect with an error, got %d %q", rr.Code, msg)
+	}
+	if _, err := app.DB.GetUserImage(ctx, newID); err != nil {
+		t.Error("someone else's image: expected it to still exist")
+	}
+}
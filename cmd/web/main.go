@@ -1,22 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"webapp/pkg/accounts"
 	"webapp/pkg/data"
+	"webapp/pkg/images"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
+	"webapp/pkg/migrations"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/storage"
+	"webapp/pkg/throttle"
 
 	"github.com/alexedwards/scs/v2"
 )
 
 type application struct {
-	Session *scs.SessionManager
-	DSN     string
-	DB      repository.DatabaseRepo
+	Session  *scs.SessionManager
+	DSN      string
+	DB       repository.DatabaseRepo
+	BaseURL  string
+	Mailer   mailer.Mailer
+	Accounts *accounts.Notifier
+	Guard    *throttle.Guard
+	Proxies  throttle.Proxies
+	MFA      *mfa.Manager
+	Storage  storage.Storage
+	Images   *images.Library
+	Uploads  uploadLimits
+	Logger   *slog.Logger
+	Metrics  *metrics.Metrics
 }
 
 func main() {
@@ -26,27 +49,101 @@ func main() {
 	port := 9000
 	// set up an app config
 	app := application{}
+	var runMigrations bool
+	var mailDir string
+	var mfaKey string
+	var storageCfg storage.Config
+	var sessionStore string
+	var logLevel string
+	var trustedProxies string
 
 	// read DSN as flag from commandline when starting
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	flag.StringVar(&app.BaseURL, "base-url", "http://localhost:9000", "base url of this app, used for links in emails")
+	flag.StringVar(&mailDir, "mail-dir", "", "write outgoing email to files in this directory instead of the log")
+	flag.StringVar(&mfaKey, "mfa-key", "change-me-mfa-key", "key for encrypting two-factor secrets, shared with the api")
+	flag.BoolVar(&runMigrations, "migrate", false, "apply pending database migrations before starting")
+	flag.StringVar(&sessionStore, "session-store", "postgres", "where sessions are kept: postgres, or memory (lost on restart)")
+	flag.Int64Var(&app.Uploads.MaxBytes, "upload-max-bytes", 10<<20, "largest upload request, in bytes")
+	flag.IntVar(&app.Uploads.MaxFiles, "upload-max-files", 1, "most files in one upload, 0 for no limit")
+	flag.Int64Var(&app.Uploads.Quota, "upload-quota", 50<<20, "bytes of images each user can store, 0 for no limit")
+	flag.StringVar(&logLevel, "log-level", "info", "least important log level written: debug, info, warn or error")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "comma separated ips or CIDR ranges of reverse proxies whose X-Forwarded-For is believed")
+	storageCfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Logger = logging.New(os.Stdout, level)
+	// anything still using the log package ends up as json too
+	slog.SetDefault(app.Logger)
+
+	app.Proxies, err = throttle.ParseProxies(trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if runMigrations {
+		applied, err := migrations.Run(context.Background(), conn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Logger.Info("applied migrations", "count", len(applied))
+	}
+
+	app.Metrics = metrics.New()
+	app.Metrics.RegisterDB("users", conn)
+
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn, Observe: app.Metrics.ObserveQuery}
+	app.Guard = throttle.NewGuard(app.DB)
+
+	box, err := mfa.NewBox(mfaKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.MFA = mfa.NewManager(app.DB, box)
+
+	// local files are served, and their signed urls checked, by the /images route
+	storageCfg.BaseURL = app.BaseURL + "/images"
+	app.Storage, err = storage.Open(storageCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Images = &images.Library{DB: app.DB, Storage: app.Storage}
+
+	app.Mailer = mailer.NewLogMailer(nil)
+	if mailDir != "" {
+		app.Mailer = &mailer.FileMailer{Dir: mailDir}
+	}
+	app.Accounts = &accounts.Notifier{DB: app.DB, Mailer: app.Mailer, WebURL: app.BaseURL}
 
 	// get a session manager
 	app.Session = getSession()
 
+	switch sessionStore {
+	case "postgres":
+		store := dbrepo.NewPostgresSessionStore(conn, sessionCleanupInterval)
+		defer store.StopCleanup()
+		app.Session.Store = store
+	case "memory":
+		// the scs default
+	default:
+		log.Fatalf("unknown session store %q", sessionStore)
+	}
+
 	// get application route
 	mux := app.routes()
 
 	// print out a message
-	log.Printf("Starting server on port %d", port)
+	app.Logger.Info("starting server", "port", port)
 
 	// start the server
 	err = http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
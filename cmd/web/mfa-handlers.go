@@ -0,0 +1,238 @@
+package main
+
+import (
+	stderrors "errors"
+	"html/template"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
+)
+
+// how long a user has, after getting their password right, to enter a two-factor code
+var mfaLoginExpiry = 5 * time.Minute
+
+// pendingMFAUser returns the id of the user who got their password right and still has to
+// give a code, if that was recent enough
+func (app *application) pendingMFAUser(r *http.Request) (int, bool) {
+	userID := app.Session.GetInt(r.Context(), "mfa_user_id")
+	expires := app.Session.GetInt64(r.Context(), "mfa_expires")
+
+	if userID == 0 || time.Now().Unix() > expires {
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func (app *application) MFALoginPage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.pendingMFAUser(r); !ok {
+		app.Session.Put(r.Context(), "error", "Please log in first")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "login-mfa.page.gohtml", &TemplateData{})
+}
+
+// MFALogin is the second login step, taking a code from the user's app or a recovery code.
+// Only now does the user go in the session.
+func (app *application) MFALogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := app.pendingMFAUser(r)
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Your login took too long, please log in again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Please log in again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Please enter a code")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	// wrong codes count as failed logins, so guessing them is throttled like passwords
+	ip := app.ipFromContext(r.Context())
+	if blocked := app.Guard.Check(ip, user.Email, user); blocked != nil {
+		app.Metrics.Login(metrics.LoginBlocked)
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	err = app.MFA.Verify(r.Context(), user.ID, form.Data.Get("code"))
+	if err != nil && !stderrors.Is(err, mfa.ErrInvalidCode) && !stderrors.Is(err, mfa.ErrNotEnrolled) {
+		app.Logger.ErrorContext(r.Context(), "verifying two-factor code", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		app.Metrics.Login(metrics.LoginBadMFACode)
+		blocked, err := app.Guard.Failed(r.Context(), ip, user.Email, user)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "recording failed login", "err", err)
+		}
+		if blocked != nil {
+			app.Session.Remove(r.Context(), "mfa_user_id")
+			app.loginBlocked(w, r, blocked)
+			return
+		}
+		app.Session.Put(r.Context(), "error", "That code didn't work, please try again")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+	_ = app.Guard.Succeeded(r.Context(), ip, user.Email, user)
+	app.Metrics.Login(metrics.LoginSuccess)
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Remove(r.Context(), "mfa_expires")
+	_ = app.Session.RenewToken(r.Context())
+	app.Session.Remove(r.Context(), csrfSessionKey)
+	app.Session.Put(r.Context(), "user", *user)
+
+	err = app.recordSession(r, user.ID)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "recording session", "err", err)
+		app.Session.Remove(r.Context(), "user")
+		app.Session.Put(r.Context(), "error", "Something went wrong, please try again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Successfully logged in!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// MFASettings shows whether two-factor is on, with the forms to change it
+func (app *application) MFASettings(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	enabled, err := app.MFA.Enabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{Data: map[string]any{"enabled": enabled}})
+}
+
+// BeginMFA starts enrollment, and shows the secret to add to an authenticator app
+func (app *application) BeginMFA(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	secret, uri, err := app.MFA.Begin(r.Context(), &user)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Could not start two-factor setup: "+err.Error())
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+
+	qrCode, err := mfa.QRCodeDataURL(uri)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Could not start two-factor setup: "+err.Error())
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{Data: map[string]any{
+		"secret": secret,
+		"uri":    uri,
+		// a data: url is only allowed in src once marked safe, and we built this one ourselves
+		"qr": template.URL(qrCode),
+	}})
+}
+
+// ConfirmMFA turns two-factor on with a first code, and shows the recovery codes, once
+func (app *application) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(user data.User, code string) ([]string, error) {
+		return app.MFA.Confirm(r.Context(), user.ID, code)
+	}, "Two-factor authentication is on")
+}
+
+// RegenerateRecoveryCodes swaps the recovery codes for new ones, and shows them
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(user data.User, code string) ([]string, error) {
+		return app.MFA.RegenerateRecoveryCodes(r.Context(), user.ID, code)
+	}, "New recovery codes made, the old ones no longer work")
+}
+
+// DisableMFA turns two-factor off, which takes a current code
+func (app *application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	app.withMFACode(w, r, func(user data.User, code string) ([]string, error) {
+		return nil, app.MFA.Disable(r.Context(), user.ID, code)
+	}, "Two-factor authentication is off")
+}
+
+// withMFACode runs fn with the code posted by the logged in user. Recovery codes it returns are
+// shown on the settings page; without any, the user is sent back there with the message. Wrong
+// codes count as failed logins, so a stolen session can't guess its way to turning two-factor off.
+func (app *application) withMFACode(w http.ResponseWriter, r *http.Request, fn func(user data.User, code string) ([]string, error), message string) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Please enter a code")
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+
+	ip := app.ipFromContext(r.Context())
+	if blocked := app.Guard.Check(ip, user.Email, &user); blocked != nil {
+		app.loginBlocked(w, r, blocked)
+		return
+	}
+
+	codes, err := fn(user, form.Data.Get("code"))
+	if stderrors.Is(err, mfa.ErrInvalidCode) {
+		blocked, err := app.Guard.Failed(r.Context(), ip, user.Email, &user)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "recording failed code", "err", err)
+		}
+		if blocked != nil {
+			app.loginBlocked(w, r, blocked)
+			return
+		}
+		app.Session.Put(r.Context(), "error", "That code didn't work, please try again")
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", message)
+
+	if codes == nil {
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{Data: map[string]any{
+		"enabled":        true,
+		"recovery_codes": codes,
+	}})
+}
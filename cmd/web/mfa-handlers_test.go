@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+)
+
+var secretOnPage = regexp.MustCompile(`<code>([A-Z2-7]+)</code>`)
+
+// asUser runs a handler for a request whose session is logged in as user
+func asUser(user data.User, method, target string, form url.Values, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app.Session.Put(req.Context(), "user", user)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_application_MFA(t *testing.T) {
+	id, _ := app.DB.InsertUser(context.Background(), data.User{FirstName: "Two", LastName: "Factor", Email: "mfa@example.com", Password: "secret", Verified: true})
+	user, _ := app.DB.GetUser(context.Background(), id)
+
+	// enroll
+	rr := asUser(*user, "POST", "/user/mfa/enroll", nil, app.BeginMFA)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "data:image/png;base64,") {
+		t.Error("expected a QR code on the setup page")
+	}
+
+	matches := secretOnPage.FindStringSubmatch(rr.Body.String())
+	if matches == nil {
+		t.Fatal("no secret shown on the setup page")
+	}
+	secret := matches[1]
+
+	step := mfa.Step(time.Now())
+	code, _ := mfa.Code(secret, step)
+
+	rr = asUser(*user, "POST", "/user/mfa/confirm", url.Values{"code": {"000000"}}, app.ConfirmMFA)
+	if loc := rr.Header().Get("Location"); loc != "/user/mfa" {
+		t.Errorf("confirm with a wrong code: expected to be sent to /user/mfa but got %q", loc)
+	}
+
+	rr = asUser(*user, "POST", "/user/mfa/confirm", url.Values{"code": {code}}, app.ConfirmMFA)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "recovery codes") {
+		t.Fatalf("confirm: expected the recovery codes page, got status %d", rr.Code)
+	}
+
+	// the password now only gets as far as the code page
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(url.Values{"email": {"mfa@example.com"}, "password": {"secret"}}.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/login/mfa" {
+		t.Fatalf("password step: expected to be sent to /login/mfa but got %q", loc)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("user should not be in the session before giving a code")
+	}
+	if app.Session.GetInt(req.Context(), "mfa_user_id") != id {
+		t.Error("expected the pending user id in the session")
+	}
+
+	next, _ := mfa.Code(secret, step+1)
+
+	var tests = []struct {
+		name        string
+		code        string
+		expires     time.Time
+		expectedLoc string
+		loggedIn    bool
+	}{
+		{"wrong code", "000000", time.Now().Add(time.Minute), "/login/mfa", false},
+		{"too slow", next, time.Now().Add(-time.Minute), "/", false},
+		{"valid code", next, time.Now().Add(time.Minute), "/user/profile", true},
+		{"replayed code", next, time.Now().Add(time.Minute), "/login/mfa", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(url.Values{"code": {e.code}}.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.Session.Put(req.Context(), "mfa_user_id", id)
+		app.Session.Put(req.Context(), "mfa_expires", e.expires.Unix())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.MFALogin).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected to be sent to %s but got %q", e.name, e.expectedLoc, loc)
+		}
+
+		if app.Session.Exists(req.Context(), "user") != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v", e.name, e.loggedIn)
+		}
+	}
+}
+
+func Test_application_MFALoginPage(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/login/mfa", nil)
+	req = addContextAndSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.MFALoginPage)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d without a pending login, but got %d", http.StatusSeeOther, rr.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -89,3 +90,35 @@ func Test_application_auth(t *testing.T) {
 		}
 	}
 }
+
+func Test_application_requireAdmin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	userID, _ := app.DB.InsertUser(context.Background(), data.User{Email: "not-admin@example.com", Password: "secret"})
+
+	// what the database says counts, not the copy in the session
+	var tests = []struct {
+		name         string
+		sessionUser  data.User
+		expectedCode int
+	}{
+		{"admin", data.User{ID: 1, IsAdmin: 1}, http.StatusOK},
+		{"promoted since logging in", data.User{ID: 1, IsAdmin: 0}, http.StatusOK},
+		{"not an admin", data.User{ID: userID, IsAdmin: 0}, http.StatusSeeOther},
+		{"demoted since logging in", data.User{ID: userID, IsAdmin: 1}, http.StatusSeeOther},
+		{"deleted since logging in", data.User{ID: 9999, IsAdmin: 1}, http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "http://testing/admin/users", nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", e.sessionUser)
+
+		rr := httptest.NewRecorder()
+		app.requireAdmin(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
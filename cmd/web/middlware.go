@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
+	stderrors "errors"
 	"net"
 	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/logging"
+	"webapp/pkg/repository"
 )
 
 // it is recommended not to store primitive types in context, so creating a custom type.
@@ -20,7 +23,7 @@ func (app *application) addIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var ctx = context.Background()
 		// get the ip as accurately as possible
-		ip, err := getIP(r)
+		ip, err := app.Proxies.ClientIP(r)
 		if err != nil {
 			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
 			if len(ip) == 0 {
@@ -32,38 +35,62 @@ func (app *application) addIPToContext(next http.Handler) http.Handler {
 	})
 }
 
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-
-	if err != nil {
-		return "unknown", err
-	}
-
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("UserIP: %q is not in IP:port format", r.RemoteAddr)
-	}
+func (app *application) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.Session.Exists(r.Context(), "user") {
+			app.Session.Put(r.Context(), "error", "Plear Login first")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
 
-	forward := r.Header.Get("X-Forwarded-For")
-	// if this header exists, the request came through a proxy
-	if len(forward) > 0 {
-		ip = forward
-	}
+		// the session may have been revoked from another device, or by an admin
+		active, err := app.sessionActive(r)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "checking session", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			_ = app.Session.Destroy(r.Context())
+			app.Session.Put(r.Context(), "error", "You have been logged out, please log in again")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
 
-	if len(ip) == 0 {
-		ip = forward
-	}
+		user := app.Session.Get(r.Context(), "user").(data.User)
+		logging.SetUserID(r.Context(), user.ID)
 
-	return ip, nil
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (app *application) auth(next http.Handler) http.Handler {
+// requireAdmin lets only administrators through. It runs after auth, so there is a user. The
+// user is loaded again rather than trusting the session copy, which still says admin after an
+// admin has been demoted, or deleted, until they log out.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !app.Session.Exists(r.Context(), "user") {
-			app.Session.Put(r.Context(), "error", "Plear Login first")
-			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		sessionUser, ok := app.Session.Get(r.Context(), "user").(data.User)
+		if !ok {
+			app.Session.Put(r.Context(), "error", "You don't have access to that page")
+			http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 			return
 		}
+
+		user, err := app.DB.GetUser(r.Context(), sessionUser.ID)
+		if err != nil && !stderrors.Is(err, repository.ErrNotFound) {
+			app.Logger.ErrorContext(r.Context(), "loading user", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err != nil || user.IsAdmin != 1 {
+			app.Session.Put(r.Context(), "error", "You don't have access to that page")
+			http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+			return
+		}
+
+		// keep the session copy current, for the pages that show it
+		app.Session.Put(r.Context(), "user", *user)
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"webapp/pkg/logging"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,22 +12,79 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// register middleware
+	mux.Use(logging.Middleware(app.Logger))
+	mux.Use(app.Metrics.Middleware)
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.addIPToContext)
 
 	// loads and saves the session with every request
 	mux.Use(app.Session.LoadAndSave)
 
+	// every post has to carry the session's csrf token
+	mux.Use(app.csrf)
+
 	// register routes
 	mux.Get("/", app.Home)
 
+	// for prometheus to scrape
+	mux.Handle("/metrics", app.Metrics.Handler())
+
 	// applying auth middleware to only one route
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
 		mux.Get("/profile", app.Profile)
 		mux.Post("/upload-profile-pic", app.UploadProfilePicture)
+		mux.Post("/images/{imageID}/activate", app.ActivateImage)
+		mux.Post("/images/{imageID}/delete", app.DeleteImage)
+
+		// two-factor settings
+		mux.Get("/mfa", app.MFASettings)
+		mux.Post("/mfa/enroll", app.BeginMFA)
+		mux.Post("/mfa/confirm", app.ConfirmMFA)
+		mux.Post("/mfa/recovery-codes", app.RegenerateRecoveryCodes)
+		mux.Post("/mfa/disable", app.DisableMFA)
+
+		// where the user is logged in
+		mux.Get("/sessions", app.Sessions)
+		mux.Post("/sessions/{sessionID}/revoke", app.RevokeSession)
+		mux.Post("/sessions/revoke-all", app.RevokeAllSessions)
 	})
+
+	// user management, for administrators only
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.auth)
+		mux.Use(app.requireAdmin)
+		mux.Get("/", http.RedirectHandler("/admin/users", http.StatusSeeOther).ServeHTTP)
+		mux.Get("/users", app.AdminUsers)
+		mux.Get("/users/new", app.AdminNewUserPage)
+		mux.Post("/users/new", app.AdminCreateUser)
+		mux.Get("/users/{userID}", app.AdminUserPage)
+		mux.Post("/users/{userID}", app.AdminUpdateUser)
+		mux.Post("/users/{userID}/password", app.AdminResetPassword)
+		mux.Post("/users/{userID}/unlock", app.AdminUnlockUser)
+		mux.Post("/users/{userID}/delete", app.AdminDeleteUser)
+		mux.Get("/users/{userID}/sessions", app.AdminSessions)
+		mux.Post("/users/{userID}/sessions/{sessionID}/revoke", app.AdminRevokeSession)
+		mux.Post("/users/{userID}/sessions/revoke-all", app.AdminRevokeAllSessions)
+	})
+
 	mux.Post("/login", app.Login)
+	mux.Get("/login/mfa", app.MFALoginPage)
+	mux.Post("/login/mfa", app.MFALogin)
+
+	// self-registration
+	mux.Get("/register", app.RegisterPage)
+	mux.Post("/register", app.Register)
+	mux.Get("/verify-email", app.VerifyEmail)
+
+	// password reset
+	mux.Get("/forgot-password", app.ForgotPasswordPage)
+	mux.Post("/forgot-password", app.ForgotPassword)
+	mux.Get("/reset-password", app.ResetPasswordPage)
+	mux.Post("/reset-password", app.ResetPassword)
+
+	// uploaded images, from whichever storage backend is configured
+	mux.Get("/images/*", app.ServeImage)
 
 	// static assets
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -14,9 +14,43 @@ func Test_application_routes(t *testing.T) {
 		method string
 	}{
 		{"/", "GET"},
+		{"/metrics", "GET"},
+		{"/images/*", "GET"},
 		{"/static/*", "GET"},
 		{"/login", "POST"},
+		{"/login/mfa", "GET"},
+		{"/login/mfa", "POST"},
+		{"/user/mfa", "GET"},
+		{"/user/mfa/enroll", "POST"},
+		{"/user/mfa/confirm", "POST"},
+		{"/user/mfa/recovery-codes", "POST"},
+		{"/user/mfa/disable", "POST"},
+		{"/register", "GET"},
+		{"/register", "POST"},
+		{"/verify-email", "GET"},
+		{"/forgot-password", "GET"},
+		{"/forgot-password", "POST"},
+		{"/reset-password", "GET"},
+		{"/reset-password", "POST"},
 		{"/user/profile", "GET"},
+		{"/user/upload-profile-pic", "POST"},
+		{"/user/images/{imageID}/activate", "POST"},
+		{"/user/images/{imageID}/delete", "POST"},
+		{"/admin/", "GET"},
+		{"/admin/users", "GET"},
+		{"/admin/users/new", "GET"},
+		{"/admin/users/new", "POST"},
+		{"/admin/users/{userID}", "GET"},
+		{"/admin/users/{userID}", "POST"},
+		{"/admin/users/{userID}/password", "POST"},
+		{"/admin/users/{userID}/unlock", "POST"},
+		{"/admin/users/{userID}/delete", "POST"},
+		{"/admin/users/{userID}/sessions", "GET"},
+		{"/admin/users/{userID}/sessions/{sessionID}/revoke", "POST"},
+		{"/admin/users/{userID}/sessions/revoke-all", "POST"},
+		{"/user/sessions", "GET"},
+		{"/user/sessions/{sessionID}/revoke", "POST"},
+		{"/user/sessions/revoke-all", "POST"},
 	}
 
 	mux := app.routes()
@@ -0,0 +1,203 @@
+package main
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/useragent"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// the session key holding the id of the login's user_sessions record
+const sessionIDKey = "sid"
+
+// how often a session's last seen time is written, at most, while it is in use
+var sessionTouchInterval = time.Minute
+
+// recordSession records a fresh login as one of the user's sessions, so it can be listed and
+// revoked from anywhere. It runs after the session token has been renewed.
+func (app *application) recordSession(r *http.Request, userID int) error {
+	sid, _, err := data.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = app.DB.InsertUserSession(r.Context(), data.UserSession{
+		UserID:    userID,
+		Kind:      data.SessionWeb,
+		TokenID:   sid,
+		Device:    useragent.Describe(r.UserAgent()),
+		UserAgent: r.UserAgent(),
+		IP:        app.ipFromContext(r.Context()),
+		ExpiresAt: time.Now().Add(app.Session.Lifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	app.Session.Put(r.Context(), sessionIDKey, sid)
+	return nil
+}
+
+// sessionActive reports whether the logged in session hasn't been revoked, and notes that it
+// was seen. Sessions from before logins were recorded have no id; they can't be revoked, and
+// run out with the session lifetime.
+func (app *application) sessionActive(r *http.Request) (bool, error) {
+	sid := app.Session.GetString(r.Context(), sessionIDKey)
+	if sid == "" {
+		return true, nil
+	}
+
+	s, err := app.DB.GetUserSessionByToken(r.Context(), data.SessionWeb, sid)
+	if stderrors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !s.Active() {
+		return false, nil
+	}
+
+	ip := app.ipFromContext(r.Context())
+	if time.Since(s.LastSeenAt) > sessionTouchInterval || s.IP != ip {
+		if err := app.DB.TouchUserSession(r.Context(), data.SessionWeb, sid, ip, time.Time{}); err != nil {
+			app.Logger.ErrorContext(r.Context(), "touching session", "err", err)
+		}
+	}
+
+	return true, nil
+}
+
+// currentSession returns the user_sessions record of the request's login, if it has one
+func (app *application) currentSession(r *http.Request) (*data.UserSession, bool) {
+	sid := app.Session.GetString(r.Context(), sessionIDKey)
+	if sid == "" {
+		return nil, false
+	}
+
+	s, err := app.DB.GetUserSessionByToken(r.Context(), data.SessionWeb, sid)
+	if err != nil {
+		return nil, false
+	}
+
+	return s, true
+}
+
+// Sessions lists where the logged in user is logged in, to this app and the api
+func (app *application) Sessions(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	app.showSessions(w, r, user.ID, "/user/sessions", map[string]any{})
+}
+
+// RevokeSession logs the user out of one of their sessions
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	app.revokeSession(w, r, user.ID, "/user/sessions")
+}
+
+// RevokeAllSessions logs the user out everywhere but here
+func (app *application) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	app.revokeAllSessions(w, r, user.ID, "/user/sessions")
+}
+
+// AdminSessions lists where a user is logged in
+func (app *application) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	app.showSessions(w, r, user.ID, fmt.Sprintf("/admin/users/%d/sessions", user.ID), map[string]any{"user": user})
+}
+
+// AdminRevokeSession logs a user out of one of their sessions
+func (app *application) AdminRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	app.revokeSession(w, r, user.ID, fmt.Sprintf("/admin/users/%d/sessions", user.ID))
+}
+
+// AdminRevokeAllSessions logs a user out everywhere, except the admin's own session
+func (app *application) AdminRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	app.revokeAllSessions(w, r, user.ID, fmt.Sprintf("/admin/users/%d/sessions", user.ID))
+}
+
+// showSessions renders the sessions page for userID, with its forms posting under base
+func (app *application) showSessions(w http.ResponseWriter, r *http.Request, userID int, base string, td map[string]any) {
+	sessions, err := app.DB.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		app.repositoryError(w, r, "listing sessions", err)
+		return
+	}
+
+	if current, ok := app.currentSession(r); ok {
+		for _, s := range sessions {
+			s.Current = s.ID == current.ID
+		}
+	}
+
+	td["sessions"] = sessions
+	td["base"] = base
+	_ = app.render(w, r, "sessions.page.gohtml", &TemplateData{Data: td})
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request, userID int, base string) {
+	sessionID, err := strconv.Atoi(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s, err := app.DB.GetUserSession(r.Context(), sessionID)
+	if err != nil || s.UserID != userID {
+		app.Session.Put(r.Context(), "error", "That session doesn't exist")
+		http.Redirect(w, r, base, http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.RevokeUserSession(r.Context(), s.ID)
+	if err != nil {
+		app.repositoryError(w, r, "revoking session", err)
+		return
+	}
+
+	// revoking the session in use is logging out
+	if current, ok := app.currentSession(r); ok && current.ID == s.ID {
+		_ = app.Session.Destroy(r.Context())
+		app.Session.Put(r.Context(), "flash", "You have been logged out")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Logged out of "+s.Device)
+	http.Redirect(w, r, base, http.StatusSeeOther)
+}
+
+func (app *application) revokeAllSessions(w http.ResponseWriter, r *http.Request, userID int, base string) {
+	var keep int
+	if current, ok := app.currentSession(r); ok && current.UserID == userID {
+		keep = current.ID
+	}
+
+	err := app.DB.RevokeUserSessions(r.Context(), userID, keep)
+	if err != nil {
+		app.repositoryError(w, r, "revoking sessions", err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Logged out of every other session")
+	http.Redirect(w, r, base, http.StatusSeeOther)
+}
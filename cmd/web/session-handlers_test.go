@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loggedInSession logs the fixture user userID in on a new request, recording the session
+func loggedInSession(t *testing.T, method, target string, userID int) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0")
+	req = addContextAndSessionToRequest(req, app)
+
+	user, _ := app.DB.GetUser(req.Context(), userID)
+	app.Session.Put(req.Context(), "user", *user)
+	if err := app.recordSession(req, userID); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func Test_application_auth_revokedSession(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := loggedInSession(t, "GET", "/user/profile", 1)
+	current, ok := app.currentSession(req)
+	if !ok {
+		t.Fatal("expected the login to be recorded")
+	}
+	if current.Device != "Firefox on Linux" {
+		t.Errorf("expected the device to be described from the user agent, got %q", current.Device)
+	}
+
+	rr := httptest.NewRecorder()
+	app.auth(nextHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected an active session to pass, got %d", rr.Code)
+	}
+
+	_ = app.DB.RevokeUserSession(req.Context(), current.ID)
+
+	rr = httptest.NewRecorder()
+	app.auth(nextHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected a revoked session to be sent to log in again, got %d", rr.Code)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("expected the revoked session to be logged out")
+	}
+}
+
+func Test_application_Sessions(t *testing.T) {
+	req := loggedInSession(t, "GET", "/user/sessions", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Sessions)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "This session") {
+		t.Error("expected the current session to be marked")
+	}
+}
+
+func Test_application_RevokeSession(t *testing.T) {
+	ctx := context.Background()
+	other, _ := app.DB.InsertUserSession(ctx, data.UserSession{UserID: 2, Kind: data.SessionWeb, TokenID: "someone-elses", Device: "curl", ExpiresAt: time.Now().Add(time.Hour)})
+
+	var tests = []struct {
+		name          string
+		sessionID     func(own int) int
+		expectedFlash string
+		expectedError string
+	}{
+		{"another user's session", func(int) int { return other }, "", "That session doesn't exist"},
+		{"unknown session", func(int) int { return 99999 }, "", "That session doesn't exist"},
+		{"own session", func(own int) int { return own }, "You have been logged out", ""},
+	}
+
+	for _, e := range tests {
+		req := loggedInSession(t, "POST", "/user/sessions/revoke", 1)
+		own, _ := app.currentSession(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("sessionID", strconv.Itoa(e.sessionID(own.ID)))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.RevokeSession)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if flash := app.Session.GetString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := app.Session.GetString(req.Context(), "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+	}
+
+	s, _ := app.DB.GetUserSession(ctx, other)
+	if !s.Active() {
+		t.Error("expected another user's session to be left alone")
+	}
+}
+
+func Test_application_RevokeAllSessions(t *testing.T) {
+	ctx := context.Background()
+	elsewhere, _ := app.DB.InsertUserSession(ctx, data.UserSession{UserID: 1, Kind: data.SessionWeb, TokenID: "elsewhere", Device: "curl", ExpiresAt: time.Now().Add(time.Hour)})
+
+	req := loggedInSession(t, "POST", "/user/sessions/revoke-all", 1)
+	own, _ := app.currentSession(req)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.RevokeAllSessions)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	if s, _ := app.DB.GetUserSession(ctx, elsewhere); s.Active() {
+		t.Error("expected the other session to be revoked")
+	}
+	if s, _ := app.DB.GetUserSession(ctx, own.ID); !s.Active() {
+		t.Error("expected the current session to be kept")
+	}
+}
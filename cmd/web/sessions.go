@@ -7,6 +7,11 @@ import (
 	"github.com/alexedwards/scs/v2"
 )
 
+// how often the postgres session store deletes expired sessions
+var sessionCleanupInterval = 5 * time.Minute
+
+// getSession returns the session manager, with the in-memory store the tests use. main swaps
+// in the store picked by the -session-store flag.
 func getSession() *scs.SessionManager {
 	session := scs.New()
 	session.Lifetime = 24 * time.Hour
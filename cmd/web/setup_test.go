@@ -1,13 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"log/slog"
 	"os"
 	"testing"
+	"webapp/pkg/accounts"
+	"webapp/pkg/images"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/mfa"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/storage"
+	"webapp/pkg/throttle"
 )
 
 var app application
 
+// sentMail collects every email the app sends during the tests
+var sentMail bytes.Buffer
+
 // this function is always executed before any test runs
 // this is useful for setting up databases, sessions, etc that will then
 // not be needed to defined in every single test
@@ -15,8 +29,21 @@ func TestMain(m *testing.M) {
 	pathToTemplates = "./../../templates"
 	// get a session manager
 	app.Session = getSession()
+	app.Logger = logging.New(io.Discard, slog.LevelInfo)
+	app.Metrics = metrics.New()
 
 	app.DB = &dbrepo.TestDBRepo{}
+	app.Guard = throttle.NewGuard(app.DB)
+
+	box, _ := mfa.NewBox("test mfa key")
+	app.MFA = mfa.NewManager(app.DB, box)
+
+	app.BaseURL = "http://localhost:9000"
+	app.Mailer = mailer.NewLogMailer(&sentMail)
+	app.Accounts = &accounts.Notifier{DB: app.DB, Mailer: app.Mailer, WebURL: app.BaseURL}
+	app.Uploads = uploadLimits{MaxBytes: 5 << 20, MaxFiles: 1, Quota: 10 << 20}
+	app.Storage = &storage.Local{Dir: "./testdata/uploads", BaseURL: app.BaseURL + "/images", Secret: []byte("test storage key")}
+	app.Images = &images.Library{DB: app.DB, Storage: app.Storage}
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/images"
+)
+
+// uploadLimits caps what one user can upload. Zero means no limit, except for MaxBytes.
+type uploadLimits struct {
+	MaxBytes int64 // the whole request body, every file and field included
+	MaxFiles int   // files in one request
+	Quota    int64 // bytes of stored image variants per user
+}
+
+// keep at most this much of a multipart form in memory, the rest is spooled to temp files
+const uploadMemory = 1 << 20
+
+// the ways an upload can be refused
+const (
+	UploadMalformed = iota + 1
+	UploadTooLarge
+	UploadTooManyFiles
+	UploadNoFiles
+	UploadNotAnImage
+	UploadQuotaExceeded
+)
+
+// UploadError is returned by UploadFiles when an upload is refused. Nothing has been stored when
+// it is returned.
+type UploadError struct {
+	Kind  int    // one of the Upload... constants
+	Limit int64  // the limit that was hit, in bytes or files
+	File  string // the client's name for the offending file, if there is one
+	Err   error  // the underlying cause, for UploadMalformed and UploadNotAnImage
+}
+
+func (e *UploadError) Error() string {
+	switch e.Kind {
+	case UploadMalformed:
+		return "the upload could not be read"
+	case UploadTooLarge:
+		return fmt.Sprintf("the upload is too big, it can be at most %d bytes", e.Limit)
+	case UploadTooManyFiles:
+		return fmt.Sprintf("too many files, at most %d can be uploaded at once", e.Limit)
+	case UploadNoFiles:
+		return "no file was uploaded"
+	case UploadNotAnImage:
+		return fmt.Sprintf("%s: %s", e.File, e.Err)
+	case UploadQuotaExceeded:
+		return fmt.Sprintf("%s: this would take you over your %d byte storage quota", e.File, e.Limit)
+	}
+	return "upload refused"
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode is the http status to answer a refused upload with
+func (e *UploadError) StatusCode() int {
+	switch e.Kind {
+	case UploadTooLarge, UploadQuotaExceeded:
+		return http.StatusRequestEntityTooLarge
+	case UploadNotAnImage:
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// UploadedFile describes one uploaded image. The client's file name is kept for display only,
+// what is stored are the Variants, under names made from the user id and their content.
+type UploadedFile struct {
+	OriginalFileName string
+	FileSize         int64
+	Variants         []data.ImageVariant
+}
+
+// cappedBody fails reads once more than its limit has been read, and remembers that it did, so
+// the cause can be told apart from other multipart errors
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	// allow one byte past the limit, to tell a body of exactly limit bytes from a bigger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+
+	if b.remaining < 0 {
+		b.exceeded = true
+		return n, fmt.Errorf("request body larger than the upload limit")
+	}
+	return n, err
+}
+
+// UploadFiles checks that every uploaded file is a PNG, JPEG or GIF image, and puts its
+// thumbnail, medium and original variants in app.Storage under users/{userID}/. The client
+// never chooses a name. The limits in app.Uploads are checked before anything is stored.
+func (app *application) UploadFiles(r *http.Request, userID int) ([]*UploadedFile, error) {
+	limits := app.Uploads
+
+	body := &cappedBody{ReadCloser: r.Body, remaining: limits.MaxBytes}
+	r.Body = body
+
+	err := r.ParseMultipartForm(uploadMemory)
+	if body.exceeded {
+		return nil, &UploadError{Kind: UploadTooLarge, Limit: limits.MaxBytes}
+	}
+	if err != nil {
+		return nil, &UploadError{Kind: UploadMalformed, Err: err}
+	}
+
+	var count int
+	for _, fHeaders := range r.MultipartForm.File {
+		count += len(fHeaders)
+	}
+	if count == 0 {
+		return nil, &UploadError{Kind: UploadNoFiles}
+	}
+	if limits.MaxFiles > 0 && count > limits.MaxFiles {
+		return nil, &UploadError{Kind: UploadTooManyFiles, Limit: int64(limits.MaxFiles)}
+	}
+
+	usage, err := app.DB.UserImageUsage(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// process everything first, so a refused file doesn't leave the others half stored
+	var uploadedFiles []*UploadedFile
+	var pending []images.Variant
+
+	for _, fHeaders := range r.MultipartForm.File {
+		for _, hdr := range fHeaders {
+			uploadedFile, variants, err := processUpload(hdr, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, v := range variants {
+				usage += int64(len(v.Data))
+			}
+			if limits.Quota > 0 && usage > limits.Quota {
+				return nil, &UploadError{Kind: UploadQuotaExceeded, Limit: limits.Quota, File: hdr.Filename}
+			}
+
+			uploadedFiles = append(uploadedFiles, uploadedFile)
+			pending = append(pending, variants...)
+		}
+	}
+
+	for _, v := range pending {
+		if err := app.Storage.Put(r.Context(), v.FileName, bytes.NewReader(v.Data), v.MIMEType); err != nil {
+			return nil, err
+		}
+	}
+
+	return uploadedFiles, nil
+}
+
+// processUpload reads one uploaded file and turns it into image variants, named under the user's prefix
+func processUpload(hdr *multipart.FileHeader, userID int) (*UploadedFile, []images.Variant, error) {
+	infile, err := hdr.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer infile.Close()
+
+	content, err := io.ReadAll(infile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variants, err := images.Process(content)
+	if err != nil {
+		return nil, nil, &UploadError{Kind: UploadNotAnImage, File: hdr.Filename, Err: err}
+	}
+
+	uploadedFile := &UploadedFile{OriginalFileName: hdr.Filename, FileSize: int64(len(content))}
+
+	for i := range variants {
+		variants[i].FileName = fmt.Sprintf("users/%d/%s", userID, variants[i].FileName)
+
+		uploadedFile.Variants = append(uploadedFile.Variants, data.ImageVariant{
+			Variant:  variants[i].Name,
+			FileName: variants[i].FileName,
+			MIMEType: variants[i].MIMEType,
+			Width:    variants[i].Width,
+			Height:   variants[i].Height,
+			Size:     int64(len(variants[i].Data)),
+		})
+	}
+
+	return uploadedFile, variants, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_application_UploadFiles(t *testing.T) {
+	// set up pipes
+	pr, pw := io.Pipe() // dummy reader and writer
+
+	// create new writer of type *io.Writer
+	writer := multipart.NewWriter(pw)
+
+	// simulate uploading file using goroutine and writer, concurrent
+	// start a go routine that runs concurrent with current request
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	go simulateFileUpload("./testdata/test.jpg", writer, t, wg)
+
+	// read from pipe, which will recieve data
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	// call app.UploadFiles
+	uploadedFiles, err := app.UploadFiles(request, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	// perform tests
+	// check every variant was stored under its content hashed name, error if not
+	if len(uploadedFiles) != 1 || len(uploadedFiles[0].Variants) != 3 {
+		t.Fatalf("expected one file with 3 variants, got %d files", len(uploadedFiles))
+	}
+
+	for _, v := range uploadedFiles[0].Variants {
+		if !strings.HasPrefix(v.FileName, "users/1/") || v.MIMEType != "image/jpeg" {
+			t.Errorf("%s: expected a hashed jpeg file name under users/1/, got %s (%s)", v.Variant, v.FileName, v.MIMEType)
+		}
+
+		if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", v.FileName)); os.IsNotExist(err) {
+			t.Errorf("expected file to exist: %s", err.Error())
+		}
+	}
+
+	// clean up
+	_ = os.RemoveAll("./testdata/uploads/users")
+
+	wg.Wait()
+}
+
+func simulateFileUpload(fileToUpload string, writer *multipart.Writer, t *testing.T, wg *sync.WaitGroup) {
+	defer writer.Close()
+	defer wg.Done()
+
+	// create the form data field `file`, with value fileName
+
+	part, err := writer.CreateFormFile("file", path.Base(fileToUpload))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// open the actual file
+	f, err := os.Open(fileToUpload)
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer f.Close()
+
+	// decode the image
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Error("Error decoding the image", err)
+	}
+
+	// write the image to io.Writer
+	err = jpeg.Encode(part, img, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// uploadRequest builds a multipart request with one part per file, named as given
+func uploadRequest(t *testing.T, files map[string][]byte) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	for name, content := range files {
+		w, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(content)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func Test_application_UploadFilesLimits(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := app.Uploads
+	defer func() { app.Uploads = defaults }()
+
+	var tests = []struct {
+		name         string
+		files        map[string][]byte
+		limits       uploadLimits
+		expectedKind int
+		expectedCode int
+	}{
+		{"too many bytes", map[string][]byte{"a.jpg": jpg}, uploadLimits{MaxBytes: 1024}, UploadTooLarge, http.StatusRequestEntityTooLarge},
+		{"too many files", map[string][]byte{"a.jpg": jpg, "b.jpg": jpg}, uploadLimits{MaxBytes: 5 << 20, MaxFiles: 1}, UploadTooManyFiles, http.StatusBadRequest},
+		{"no files", map[string][]byte{}, uploadLimits{MaxBytes: 5 << 20}, UploadNoFiles, http.StatusBadRequest},
+		{"not an image", map[string][]byte{"a.jpg": []byte("hello")}, uploadLimits{MaxBytes: 5 << 20}, UploadNotAnImage, http.StatusUnsupportedMediaType},
+		{"over quota", map[string][]byte{"a.jpg": jpg}, uploadLimits{MaxBytes: 5 << 20, Quota: 1000}, UploadQuotaExceeded, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, e := range tests {
+		app.Uploads = e.limits
+
+		_, err := app.UploadFiles(uploadRequest(t, e.files), 1)
+
+		uploadErr, ok := err.(*UploadError)
+		if !ok {
+			t.Errorf("%s: expected an *UploadError but got %v", e.name, err)
+			continue
+		}
+
+		if uploadErr.Kind != e.expectedKind || uploadErr.StatusCode() != e.expectedCode {
+			t.Errorf("%s: expected kind %d and status %d but got %d and %d (%s)", e.name, e.expectedKind, e.expectedCode, uploadErr.Kind, uploadErr.StatusCode(), uploadErr)
+		}
+	}
+
+	if _, err := os.Stat("./testdata/uploads/users"); !os.IsNotExist(err) {
+		t.Error("expected nothing to be stored for refused uploads")
+		_ = os.RemoveAll("./testdata/uploads/users")
+	}
+}
+
+func Test_application_UploadFilesNames(t *testing.T) {
+	jpg, err := os.ReadFile("./testdata/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("./testdata/uploads/users")
+
+	// the name the client sends is never used for storing
+	files, err := app.UploadFiles(uploadRequest(t, map[string][]byte{"../../handlers.go": jpg}), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range files[0].Variants {
+		if !strings.HasPrefix(v.FileName, "users/2/") || strings.Contains(v.FileName, "..") {
+			t.Errorf("%s: expected a name under users/2/ but got %s", v.Variant, v.FileName)
+		}
+	}
+}
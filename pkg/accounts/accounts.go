@@ -0,0 +1,94 @@
+// Package accounts emails users the single-use links that reset a password or confirm an email
+// address. The api and the web app both send them, and both point the links at the web app.
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+)
+
+var (
+	// PasswordResetExpiry is how long a password reset link works for
+	PasswordResetExpiry = time.Hour
+	// VerificationExpiry is how long an email verification link works for
+	VerificationExpiry = 24 * time.Hour
+)
+
+// Notifier stores the tokens behind account emails, and sends the emails
+type Notifier struct {
+	DB     repository.DatabaseRepo
+	Mailer mailer.Mailer
+	// WebURL is the base url of the web app, which has the pages the links open
+	WebURL string
+}
+
+// SendPasswordReset stores a new reset token for the user and emails them a link to it
+func (n *Notifier) SendPasswordReset(ctx context.Context, user *data.User) error {
+	link, err := n.newLink(ctx, user, data.ScopePasswordReset, PasswordResetExpiry, "/reset-password")
+	if err != nil {
+		return err
+	}
+
+	return n.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link to choose a new password:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't ask for this, you can ignore this email.\n",
+			user.FirstName, link, PasswordResetExpiry),
+	})
+}
+
+// SendVerification stores a new verification token for the user and emails them a link to it
+func (n *Notifier) SendVerification(ctx context.Context, user *data.User) error {
+	link, err := n.newLink(ctx, user, data.ScopeVerification, VerificationExpiry, "/verify-email")
+	if err != nil {
+		return err
+	}
+
+	return n.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nThanks for signing up. Follow this link to confirm your email address:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't sign up, you can ignore this email.\n",
+			user.FirstName, link, VerificationExpiry),
+	})
+}
+
+// SendAlreadyRegistered tells the user that someone tried to sign up with their address. The
+// sign up forms answer as if the account was new, so this email is the only place the
+// difference shows, and only the address's owner sees it.
+func (n *Notifier) SendAlreadyRegistered(ctx context.Context, user *data.User) error {
+	return n.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "You already have an account",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone tried to sign up using this email address, but it already has an account. "+
+			"You can log in as usual, or choose a new password here if you've forgotten it:\n\n%s/forgot-password\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			user.FirstName, n.WebURL),
+	})
+}
+
+// newLink stores a new token for the user and returns the link to page that carries it
+func (n *Notifier) newLink(ctx context.Context, user *data.User, scope string, expiry time.Duration, page string) (string, error) {
+	plainText, hash, err := data.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = n.DB.InsertUserToken(ctx, data.UserToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(expiry),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s?token=%s", n.WebURL, page, url.QueryEscape(plainText)), nil
+}
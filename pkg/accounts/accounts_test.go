@@ -0,0 +1,73 @@
+package accounts
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository/dbrepo"
+)
+
+var linkPattern = regexp.MustCompile(`http://web\.test(/[a-z-]+)\?token=(\S+)`)
+
+func TestNotifier(t *testing.T) {
+	var tests = []struct {
+		name    string
+		send    func(n *Notifier, ctx context.Context, u *data.User) error
+		page    string
+		scope   string
+		subject string
+	}{
+		{"password reset", (*Notifier).SendPasswordReset, "/reset-password", data.ScopePasswordReset, "Reset your password"},
+		{"verification", (*Notifier).SendVerification, "/verify-email", data.ScopeVerification, "Confirm your email address"},
+	}
+
+	for _, e := range tests {
+		var sent bytes.Buffer
+		db := &dbrepo.TestDBRepo{}
+		n := &Notifier{DB: db, Mailer: mailer.NewLogMailer(&sent), WebURL: "http://web.test"}
+		user := &data.User{ID: 1, FirstName: "Admin", Email: "admin@example.com"}
+
+		if err := e.send(n, context.Background(), user); err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		if !bytes.Contains(sent.Bytes(), []byte("Subject: "+e.subject)) || !bytes.Contains(sent.Bytes(), []byte("To: admin@example.com")) {
+			t.Errorf("%s: unexpected email %q", e.name, sent.String())
+		}
+
+		m := linkPattern.FindSubmatch(sent.Bytes())
+		if m == nil || string(m[1]) != e.page {
+			t.Fatalf("%s: expected a link to %s in %q", e.name, e.page, sent.String())
+		}
+
+		// the emailed token is the one stored, in the right scope
+		token, _ := url.QueryUnescape(string(m[2]))
+		stored, err := db.ConsumeUserToken(context.Background(), e.scope, data.HashToken(token))
+		if err != nil || stored.UserID != user.ID {
+			t.Errorf("%s: expected the emailed token to be stored for user %d, got %v", e.name, user.ID, err)
+		}
+	}
+}
+
+func TestNotifier_SendAlreadyRegistered(t *testing.T) {
+	var sent bytes.Buffer
+	n := &Notifier{DB: &dbrepo.TestDBRepo{}, Mailer: mailer.NewLogMailer(&sent), WebURL: "http://web.test"}
+	user := &data.User{ID: 1, FirstName: "Admin", Email: "admin@example.com"}
+
+	if err := n.SendAlreadyRegistered(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"To: admin@example.com", "Subject: You already have an account", "http://web.test/forgot-password"} {
+		if !bytes.Contains(sent.Bytes(), []byte(want)) {
+			t.Errorf("expected %q in %q", want, sent.String())
+		}
+	}
+	if linkPattern.Match(sent.Bytes()) {
+		t.Errorf("did not expect a token in %q", sent.String())
+	}
+}
@@ -0,0 +1,27 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// the type for a refresh token issued by the api. Only the hash of the token
+// is ever stored; tokens issued from the same login share a FamilyID.
+type RefreshToken struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	FamilyID  string    `json:"family_id"`
+	Revoked   bool      `json:"revoked"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// HashToken returns the hex encoded sha256 hash of a plain text token, which is
+// what we store in the database in place of the token itself.
+func HashToken(plainText string) string {
+	hash := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(hash[:])
+}
@@ -2,11 +2,36 @@ package data
 
 import "time"
 
-// the type for user profile images
+// the type for user profile images. FileName is the original variant, kept so older code and
+// templates that only know about one file keep working. A user keeps every image they upload;
+// the Active one is their profile picture.
 type UserImage struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	FileName  string    `json:"file_name"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID        int            `json:"id"`
+	UserID    int            `json:"user_id"`
+	FileName  string         `json:"file_name"`
+	Active    bool           `json:"active"`
+	Variants  []ImageVariant `json:"variants"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"-"`
+}
+
+// the type for one stored size of a user image, e.g. "thumbnail", "medium" or "original"
+type ImageVariant struct {
+	Variant  string `json:"variant"`
+	FileName string `json:"file_name"`
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url,omitempty"` // not stored, filled in by the api from the storage backend
+}
+
+// Variant returns the named variant, or nil when the image doesn't have it
+func (i UserImage) Variant(name string) *ImageVariant {
+	for k := range i.Variants {
+		if i.Variants[k].Variant == name {
+			return &i.Variants[k]
+		}
+	}
+	return nil
 }
@@ -0,0 +1,14 @@
+package data
+
+import "time"
+
+// the type for a user's TOTP two-factor settings. Secret is encrypted with an mfa.Box, and
+// stays unused until Enabled is set by the user confirming a first code.
+type UserMFA struct {
+	UserID       int       `json:"user_id"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"` // the time step of the last accepted code, so a code works only once
+	CreatedAt    time.Time `json:"-"`
+	UpdatedAt    time.Time `json:"-"`
+}
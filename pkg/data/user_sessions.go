@@ -0,0 +1,31 @@
+package data
+
+import "time"
+
+// kinds of user session
+const (
+	SessionWeb = "web" // a login to the web app, kept in its session store
+	SessionAPI = "api" // an api login, the family of refresh tokens it was issued
+)
+
+// the type for one place a user is logged in. TokenID ties it to the session: the id the web
+// app keeps in its session data, or the refresh token family id for the api.
+type UserSession struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Kind       string    `json:"kind"`
+	TokenID    string    `json:"-"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Revoked    bool      `json:"-"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current"` // not stored, set for the session making the request
+}
+
+// Active reports whether the session can still be used
+func (s *UserSession) Active() bool {
+	return !s.Revoked && time.Now().Before(s.ExpiresAt)
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+// scopes for single-use tokens that are emailed to users
+const (
+	ScopePasswordReset = "password-reset"
+	ScopeVerification  = "verification"
+)
+
+// the type for a single-use token emailed to a user, like a password reset link.
+// Only the hash is stored.
+type UserToken struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// GenerateToken returns a random url safe token, and the hash to store for it
+func GenerateToken() (plainText string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	plainText = base64.RawURLEncoding.EncodeToString(b)
+
+	return plainText, HashToken(plainText), nil
+}
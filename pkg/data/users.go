@@ -8,15 +8,24 @@ import (
 )
 
 type User struct {
-	ID         int       `json:"id"`
-	FirstName  string    `json:"first_name"`
-	LastName   string    `json:"last_name"`
-	Email      string    `json:"email"`
-	Password   string    `json:"-"` //- means to not include this field in json when marshalling
-	IsAdmin    int       `json:"is_admin"`
-	CreatedAt  time.Time `json:"-"`
-	UpdatedAt  time.Time `json:"-"`
-	ProfilePic UserImage `json:"-"`
+	ID           int        `json:"id"`
+	FirstName    string     `json:"first_name"`
+	LastName     string     `json:"last_name"`
+	Email        string     `json:"email"`
+	Password     string     `json:"-"` //- means to not include this field in json when marshalling
+	IsAdmin      int        `json:"is_admin"`
+	Verified     bool       `json:"verified"` // whether the email address has been confirmed
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"` // nil unless the account has been locked
+	Version      int        `json:"-"`                      // bumped on every change but to the login counters, the api sends it as the ETag
+	CreatedAt    time.Time  `json:"-"`
+	UpdatedAt    time.Time  `json:"-"`
+	ProfilePic   UserImage  `json:"-"`
+}
+
+// IsLocked reports whether the account is locked out of logging in right now
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
 }
 
 func (u *User) PasswordMatches(plainText string) (bool, error) {
@@ -0,0 +1,178 @@
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+)
+
+// the MIME types accepted for uploads
+const (
+	MIMEJPEG = "image/jpeg"
+	MIMEPNG  = "image/png"
+	MIMEGIF  = "image/gif"
+)
+
+// the names of the variants Process generates, smallest first
+const (
+	Thumbnail = "thumbnail"
+	Medium    = "medium"
+	Original  = "original"
+)
+
+var (
+	// ThumbnailSize is the width and height of the square thumbnail
+	ThumbnailSize = 150
+	// MediumSize is the longest side of the medium variant
+	MediumSize = 600
+	// MaxPixels caps width*height, checked before decoding so a small file can't claim a huge image
+	MaxPixels = 25_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("the file is not a PNG, JPEG or GIF image")
+	ErrTooLarge        = errors.New("the image dimensions are too large")
+	ErrInvalidImage    = errors.New("the image could not be decoded")
+)
+
+// Variant is one re-encoded size of an uploaded image. FileName is derived from the hash of
+// Data, so the same bytes always get the same name.
+type Variant struct {
+	Name     string
+	FileName string
+	MIMEType string
+	Width    int
+	Height   int
+	Data     []byte
+}
+
+// Sniff returns the MIME type of an image from its magic bytes, or "" when it isn't one we accept.
+// The file name and the Content-Type the client sent are never trusted.
+func Sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return MIMEPNG
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return MIMEJPEG
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return MIMEGIF
+	}
+	return ""
+}
+
+// Process validates an uploaded image and returns its thumbnail, medium and original variants.
+// Everything is decoded and encoded again, which drops EXIF and any other metadata; a JPEG's
+// orientation tag is applied to the pixels first so the picture isn't left on its side. JPEGs
+// stay JPEGs, PNGs and GIFs become PNGs (only the first frame of an animated GIF is kept).
+func Process(b []byte) ([]Variant, error) {
+	mimeType := Sniff(b)
+	if mimeType == "" {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxPixels/cfg.Height {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	outType := MIMEPNG
+	if mimeType == MIMEJPEG {
+		outType = MIMEJPEG
+		img = orient(img, jpegOrientation(b))
+	}
+
+	bounds := img.Bounds()
+	mw, mh := fit(bounds.Dx(), bounds.Dy(), MediumSize)
+
+	sizes := []struct {
+		name string
+		img  image.Image
+	}{
+		{Thumbnail, scale(img, squareCrop(bounds), ThumbnailSize, ThumbnailSize)},
+		{Medium, scale(img, bounds, mw, mh)},
+		{Original, img},
+	}
+
+	var variants []Variant
+	for _, s := range sizes {
+		v, err := encode(s.img, outType)
+		if err != nil {
+			return nil, err
+		}
+		v.Name = s.name
+		variants = append(variants, v)
+	}
+
+	return variants, nil
+}
+
+// encode writes img in the given format and names the result after its content hash
+func encode(img image.Image, mimeType string) (Variant, error) {
+	var buf bytes.Buffer
+	var err error
+	ext := ".png"
+
+	switch mimeType {
+	case MIMEJPEG:
+		ext = ".jpg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return Variant{}, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+
+	return Variant{
+		FileName: hex.EncodeToString(sum[:16]) + ext,
+		MIMEType: mimeType,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		Data:     buf.Bytes(),
+	}, nil
+}
+
+// fit returns the size of a w by h image scaled down to have no side longer than max.
+// Images that already fit are left alone.
+func fit(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+	if w >= h {
+		return max, atLeastOne(h * max / w)
+	}
+	return atLeastOne(w * max / h), max
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// squareCrop returns the largest centred square inside r
+func squareCrop(r image.Rectangle) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w > h {
+		x := r.Min.X + (w-h)/2
+		return image.Rect(x, r.Min.Y, x+h, r.Max.Y)
+	}
+	y := r.Min.Y + (h-w)/2
+	return image.Rect(r.Min.X, y, r.Max.X, y+w)
+}
@@ -0,0 +1,204 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a w by h image, red on the left half and blue on the right
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation puts an EXIF segment holding the orientation tag right after the JPEG's start marker
+func withOrientation(b []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // value padding and the next IFD offset
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, b[:2]...)
+	out = append(out, segment...)
+	return append(out, b[2:]...)
+}
+
+func TestSniff(t *testing.T) {
+	var pngBuf, gifBuf bytes.Buffer
+	_ = png.Encode(&pngBuf, testImage(2, 2))
+	_ = gif.Encode(&gifBuf, testImage(2, 2), nil)
+
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"png", pngBuf.Bytes(), MIMEPNG},
+		{"jpeg", encodeJPEG(t, testImage(2, 2)), MIMEJPEG},
+		{"gif", gifBuf.Bytes(), MIMEGIF},
+		{"html", []byte("<html><body>hello</body></html>"), ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, e := range tests {
+		if got := Sniff(e.data); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestProcess_variants(t *testing.T) {
+	variants, err := Process(encodeJPEG(t, testImage(1200, 800)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		width  int
+		height int
+	}{
+		{Thumbnail, ThumbnailSize, ThumbnailSize},
+		{Medium, MediumSize, 400},
+		{Original, 1200, 800},
+	}
+
+	if len(variants) != len(tests) {
+		t.Fatalf("expected %d variants but got %d", len(tests), len(variants))
+	}
+
+	for i, e := range tests {
+		v := variants[i]
+		if v.Name != e.name || v.Width != e.width || v.Height != e.height {
+			t.Errorf("%s: expected %dx%d but got %s %dx%d", e.name, e.width, e.height, v.Name, v.Width, v.Height)
+		}
+		if v.MIMEType != MIMEJPEG {
+			t.Errorf("%s: expected %s but got %s", e.name, MIMEJPEG, v.MIMEType)
+		}
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(v.Data))
+		if err != nil || cfg.Width != e.width || cfg.Height != e.height {
+			t.Errorf("%s: encoded data doesn't match the recorded size", e.name)
+		}
+	}
+
+	again, _ := Process(encodeJPEG(t, testImage(1200, 800)))
+	if again[0].FileName != variants[0].FileName {
+		t.Error("expected the same content to get the same file name")
+	}
+	if variants[0].FileName == variants[1].FileName {
+		t.Error("expected different variants to get different file names")
+	}
+}
+
+func TestProcess_pngAndGIF(t *testing.T) {
+	var pngBuf, gifBuf bytes.Buffer
+	_ = png.Encode(&pngBuf, testImage(100, 300))
+	_ = gif.Encode(&gifBuf, testImage(100, 300), nil)
+
+	for name, b := range map[string][]byte{"png": pngBuf.Bytes(), "gif": gifBuf.Bytes()} {
+		variants, err := Process(b)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		for _, v := range variants {
+			if v.MIMEType != MIMEPNG || Sniff(v.Data) != MIMEPNG {
+				t.Errorf("%s: expected the %s variant to be a png", name, v.Name)
+			}
+		}
+		// smaller than the medium size, so it is not enlarged
+		if variants[1].Width != 100 || variants[1].Height != 300 {
+			t.Errorf("%s: expected the medium variant to stay 100x300 but got %dx%d", name, variants[1].Width, variants[1].Height)
+		}
+	}
+}
+
+func TestProcess_stripsEXIFAndOrients(t *testing.T) {
+	b := withOrientation(encodeJPEG(t, testImage(40, 20)), 6)
+
+	if jpegOrientation(b) != 6 {
+		t.Fatalf("expected orientation 6 but got %d", jpegOrientation(b))
+	}
+
+	variants, err := Process(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := variants[2]
+	if original.Width != 20 || original.Height != 40 {
+		t.Fatalf("expected the rotated original to be 20x40 but got %dx%d", original.Width, original.Height)
+	}
+
+	for _, v := range variants {
+		if bytes.Contains(v.Data, []byte("Exif")) {
+			t.Errorf("%s: expected the EXIF data to be removed", v.Name)
+		}
+	}
+
+	// a quarter turn clockwise moves the red left half to the top
+	img, _ := jpeg.Decode(bytes.NewReader(original.Data))
+	r, _, b2, _ := img.At(10, 2).RGBA()
+	if r < b2 {
+		t.Error("expected the top of the rotated image to be red")
+	}
+}
+
+func TestProcess_rejects(t *testing.T) {
+	var gifBuf bytes.Buffer
+	_ = gif.Encode(&gifBuf, testImage(2, 2), nil)
+	huge := append([]byte{}, gifBuf.Bytes()...)
+	// claim a 65535x65535 screen in the GIF header
+	binary.LittleEndian.PutUint16(huge[6:], 0xffff)
+	binary.LittleEndian.PutUint16(huge[8:], 0xffff)
+
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"text", []byte("just some text, not an image"), ErrUnsupportedType},
+		{"truncated png", []byte("\x89PNG\r\n\x1a\nnot really"), ErrInvalidImage},
+		{"huge gif", huge, ErrTooLarge},
+	}
+
+	for _, e := range tests {
+		_, err := Process(e.data)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
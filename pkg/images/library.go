@@ -0,0 +1,109 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/storage"
+)
+
+// ErrNotFound is returned for an image that doesn't exist, or belongs to another user
+var ErrNotFound = errors.New("image not found")
+
+// Library looks after a user's image history: the user_images records and the variant files
+// they point at in the storage backend. The web app and the api both go through it, so the
+// records and the files can't drift apart.
+type Library struct {
+	DB      repository.DatabaseRepo
+	Storage storage.Storage
+}
+
+// Images returns a user's images, newest first
+func (l *Library) Images(ctx context.Context, userID int) ([]*data.UserImage, error) {
+	return l.DB.GetUserImages(ctx, userID)
+}
+
+// Image returns one of a user's images
+func (l *Library) Image(ctx context.Context, userID, imageID int) (*data.UserImage, error) {
+	i, err := l.DB.GetUserImage(ctx, imageID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && i.UserID != userID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Activate makes one of a user's earlier images their profile picture again
+func (l *Library) Activate(ctx context.Context, userID, imageID int) error {
+	err := l.DB.SetActiveUserImage(ctx, userID, imageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete removes one of a user's images and its stored files. Files that another of the user's
+// images also uses (the same picture uploaded twice gets the same names) are kept. Deleting the
+// active image leaves the user without a profile picture.
+//
+// Once the record is gone, a file that can't be deleted is only logged: the user asked for the
+// image to go and it has, from their point of view.
+func (l *Library) Delete(ctx context.Context, userID, imageID int) error {
+	i, err := l.Image(ctx, userID, imageID)
+	if err != nil {
+		return err
+	}
+
+	if err := l.DB.DeleteUserImage(ctx, i.ID); err != nil {
+		return err
+	}
+
+	return l.Discard(ctx, userID, i.Variants)
+}
+
+// Discard deletes the stored files of variants that no longer have a record, such as those of
+// an upload whose record couldn't be inserted. Files that one of the user's images uses are
+// kept, and a file that can't be deleted is only logged.
+func (l *Library) Discard(ctx context.Context, userID int, variants []data.ImageVariant) error {
+	remaining, err := l.DB.GetUserImages(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	inUse := make(map[string]bool)
+	for _, other := range remaining {
+		for _, v := range other.Variants {
+			inUse[v.FileName] = true
+		}
+	}
+
+	for _, v := range variants {
+		if inUse[v.FileName] {
+			continue
+		}
+		if err := l.Storage.Delete(ctx, v.FileName); err != nil {
+			slog.ErrorContext(ctx, "deleting image variant", "file", v.FileName, "user_id", userID, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// WithURLs fills in a signed url for every variant of the images, valid for expiry
+func (l *Library) WithURLs(ctx context.Context, userImages []*data.UserImage, expiry time.Duration) error {
+	for _, i := range userImages {
+		for k := range i.Variants {
+			url, err := l.Storage.SignedURL(ctx, i.Variants[k].FileName, expiry)
+			if err != nil {
+				return err
+			}
+			i.Variants[k].URL = url
+		}
+	}
+	return nil
+}
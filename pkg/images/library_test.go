@@ -0,0 +1,97 @@
+package images
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/storage"
+)
+
+func TestLibrary_history(t *testing.T) {
+	ctx := context.Background()
+	repo := &dbrepo.TestDBRepo{}
+	store := &storage.Local{Dir: t.TempDir(), BaseURL: "http://localhost:9000/images", Secret: []byte("secret")}
+	lib := &Library{DB: repo, Storage: store}
+
+	// two uploads for user 1, sharing the thumbnail file, and one for user 2
+	insert := func(userID int, files ...string) int {
+		var i = data.UserImage{UserID: userID, FileName: files[len(files)-1]}
+		for _, f := range files {
+			_ = store.Put(ctx, f, strings.NewReader(f), "image/png")
+			i.Variants = append(i.Variants, data.ImageVariant{Variant: f, FileName: f})
+		}
+		id, _ := repo.InsertUserImage(ctx, i)
+		return id
+	}
+	first := insert(1, "users/1/shared.png", "users/1/first.png")
+	second := insert(1, "users/1/shared.png", "users/1/second.png")
+	other := insert(2, "users/2/other.png")
+
+	user, _ := repo.GetUser(ctx, 1)
+	if user.ProfilePic.ID != second {
+		t.Fatalf("expected the newest image to be active, got %d", user.ProfilePic.ID)
+	}
+
+	if err := lib.Activate(ctx, 1, first); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = repo.GetUser(ctx, 1)
+	if user.ProfilePic.ID != first {
+		t.Errorf("expected image %d to be active again, got %d", first, user.ProfilePic.ID)
+	}
+
+	if err := lib.Activate(ctx, 1, other); err != ErrNotFound {
+		t.Errorf("expected another user's image to be not found, got %v", err)
+	}
+	if err := lib.Delete(ctx, 1, other); err != ErrNotFound {
+		t.Errorf("expected deleting another user's image to fail, got %v", err)
+	}
+
+	if err := lib.Delete(ctx, 1, first); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(ctx, "users/1/first.png"); err != storage.ErrNotFound {
+		t.Errorf("expected the deleted image's file to be gone, got %v", err)
+	}
+	if obj, err := store.Get(ctx, "users/1/shared.png"); err != nil {
+		t.Errorf("expected the file still used by another image to be kept, got %v", err)
+	} else {
+		obj.Body.Close()
+	}
+
+	userImages, _ := lib.Images(ctx, 1)
+	if len(userImages) != 1 || userImages[0].ID != second {
+		t.Fatalf("expected only image %d to be left, got %d images", second, len(userImages))
+	}
+
+	user, _ = repo.GetUser(ctx, 1)
+	if user.ProfilePic.ID != 0 {
+		t.Errorf("expected no profile picture after deleting the active one, got %d", user.ProfilePic.ID)
+	}
+
+	if err := lib.WithURLs(ctx, userImages, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if u := userImages[0].Variants[0].URL; !strings.HasPrefix(u, "http://localhost:9000/images/users/1/") {
+		t.Errorf("expected a signed url for the variant, got %s", u)
+	}
+
+	// files stored for an upload that never got a record go, unless an image shares them
+	_ = store.Put(ctx, "users/1/unrecorded.png", strings.NewReader("unrecorded"), "image/png")
+	unrecorded := []data.ImageVariant{{FileName: "users/1/shared.png"}, {FileName: "users/1/unrecorded.png"}}
+	if err := lib.Discard(ctx, 1, unrecorded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "users/1/unrecorded.png"); err != storage.ErrNotFound {
+		t.Errorf("expected the unrecorded file to be gone, got %v", err)
+	}
+	if obj, err := store.Get(ctx, "users/1/shared.png"); err != nil {
+		t.Errorf("expected the shared file to be kept, got %v", err)
+	} else {
+		obj.Body.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package images
+
+import (
+	"image"
+	"image/color"
+)
+
+// scale resamples the sr part of src to a w by h image. Each destination pixel is the average
+// of the source pixels it covers, which is all a downscale needs; when enlarging the nearest
+// pixel is used.
+func scale(src image.Image, sr image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := sr.Dx(), sr.Dy()
+
+	for dy := 0; dy < h; dy++ {
+		y0 := sr.Min.Y + dy*sh/h
+		y1 := sr.Min.Y + (dy+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for dx := 0; dx < w; dx++ {
+			x0 := sr.Min.X + dx*sw/w
+			x1 := sr.Min.X + (dx+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := src.At(x, y).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// orient turns the pixels of a JPEG the way its EXIF orientation (1 to 8) says it should be
+// shown. Unknown values and 1 leave the image alone.
+func orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var tx, ty int
+			switch orientation {
+			case 2: // mirrored
+				tx, ty = w-1-x, y
+			case 3: // upside down
+				tx, ty = w-1-x, h-1-y
+			case 4: // mirrored and upside down
+				tx, ty = x, h-1-y
+			case 5: // mirrored and on its side
+				tx, ty = y, x
+			case 6: // needs a quarter turn clockwise
+				tx, ty = h-1-y, x
+			case 7: // mirrored and on its other side
+				tx, ty = h-1-y, w-1-x
+			case 8: // needs a quarter turn anticlockwise
+				tx, ty = y, w-1-x
+			}
+			dst.Set(tx, ty, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,171 @@
+// Package jwtkeys holds the keys used to sign and verify our JWTs. A key set has one
+// signing key and any number of verification keys, looked up by the kid header, so that
+// keys can be rotated without invalidating tokens that are still in flight.
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single signing or verification key
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns a symmetric key that both signs and verifies with the shared secret
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// CanSign reports whether the key holds private material
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// LoadKey reads a PEM encoded RSA or Ed25519 key from disk. Private keys can sign and verify,
+// public keys can only verify.
+func LoadKey(id, path string) (*Key, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKey(id, pemBytes)
+}
+
+// ParseKey parses a PEM encoded RSA or Ed25519 private or public key
+func ParseKey(id string, pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", parsed)
+}
+
+// LoadKeys loads a comma separated list of kid=path pairs, e.g. "2023-01=old.pem,2023-06=new.pem"
+func LoadKeys(spec string) ([]*Key, error) {
+	var keys []*Key
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, path, found := strings.Cut(entry, "=")
+		if !found || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid=path", entry)
+		}
+
+		key, err := LoadKey(id, path)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// KeySet signs tokens with one key and verifies them with any of its keys
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet returns a key set that signs with signing, and verifies with signing plus any
+// of the extra verification keys
+func NewKeySet(signing *Key, verify ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.CanSign() {
+		return nil, errors.New("signing key must contain a private key")
+	}
+
+	set := &KeySet{
+		signing: signing,
+		keys:    map[string]*Key{signing.ID: signing},
+	}
+
+	for _, k := range verify {
+		if _, exists := set.keys[k.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		set.keys[k.ID] = k
+	}
+
+	return set, nil
+}
+
+// Sign signs the claims with the signing key, setting the kid header when the key has an id
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	if s.signing.ID != "" {
+		token.Header["kid"] = s.signing.ID
+	}
+
+	return token.SignedString(s.signing.signKey)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key named by the kid header, and
+// rejects tokens whose algorithm does not match that key
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
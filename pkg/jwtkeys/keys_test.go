@@ -0,0 +1,195 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writePEM writes a key to a temporary file and returns its path
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func Test_LoadKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	pkcs8RSA, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	pkcs8Ed, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	pkixRSA, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pkixEd, _ := x509.MarshalPKIXPublicKey(edPub)
+
+	var tests = []struct {
+		name      string
+		blockType string
+		der       []byte
+		alg       string
+		canSign   bool
+	}{
+		{"rsa pkcs1 private", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), "RS256", true},
+		{"rsa pkcs8 private", "PRIVATE KEY", pkcs8RSA, "RS256", true},
+		{"rsa public", "PUBLIC KEY", pkixRSA, "RS256", false},
+		{"rsa pkcs1 public", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), "RS256", false},
+		{"ed25519 private", "PRIVATE KEY", pkcs8Ed, "EdDSA", true},
+		{"ed25519 public", "PUBLIC KEY", pkixEd, "EdDSA", false},
+	}
+
+	for _, e := range tests {
+		key, err := LoadKey("kid", writePEM(t, e.blockType, e.der))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if key.Method.Alg() != e.alg {
+			t.Errorf("%s: expected alg %s but got %s", e.name, e.alg, key.Method.Alg())
+		}
+
+		if key.CanSign() != e.canSign {
+			t.Errorf("%s: expected CanSign to be %v", e.name, e.canSign)
+		}
+	}
+
+	// garbage is rejected
+	if _, err := LoadKey("kid", writePEM(t, "CERTIFICATE", []byte("nope"))); err == nil {
+		t.Error("expected an error loading an unsupported PEM block")
+	}
+	if _, err := ParseKey("kid", []byte("not pem")); err == nil {
+		t.Error("expected an error parsing data that is not PEM")
+	}
+}
+
+func Test_LoadKeys(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	keys, err := LoadKeys(fmt.Sprintf("one=%s, two=%s", path, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "one" || keys[1].ID != "two" {
+		t.Errorf("got wrong keys back: %+v", keys)
+	}
+
+	if _, err := LoadKeys("missing-path"); err == nil {
+		t.Error("expected an error for an entry without a path")
+	}
+
+	keys, err = LoadKeys("")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys and no error for an empty list, got %d, %v", len(keys), err)
+	}
+}
+
+func Test_KeySet_rotation(t *testing.T) {
+	oldRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, newEd, _ := ed25519.GenerateKey(rand.Reader)
+
+	oldKey, _ := ParseKey("old", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(oldRSA)}))
+	der, _ := x509.MarshalPKCS8PrivateKey(newEd)
+	newKey, _ := ParseKey("new", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	oldSet, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, _ := oldSet.Sign(testClaims())
+
+	// the new key signs, the old one is kept for verification only
+	set, err := NewKeySet(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, _ := set.Sign(testClaims())
+
+	for name, tkn := range map[string]string{"old": oldToken, "new": newToken} {
+		parsed, err := jwt.Parse(tkn, set.Keyfunc)
+		if err != nil {
+			t.Errorf("%s token: did not verify: %s", name, err)
+			continue
+		}
+		if parsed.Header["kid"] != name {
+			t.Errorf("%s token: wrong kid header %v", name, parsed.Header["kid"])
+		}
+	}
+
+	// a token from a key we don't know about is rejected
+	_, stranger, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ = x509.MarshalPKCS8PrivateKey(stranger)
+	strangerKey, _ := ParseKey("stranger", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	strangerSet, _ := NewKeySet(strangerKey)
+	strangerToken, _ := strangerSet.Sign(testClaims())
+	if _, err := jwt.Parse(strangerToken, set.Keyfunc); err == nil {
+		t.Error("expected token signed with an unknown key to be rejected")
+	}
+
+	// an HMAC token claiming an asymmetric kid is rejected, even though it is signed with the public key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "new"
+	forgedToken, _ := forged.SignedString([]byte(newEd.Public().(ed25519.PublicKey)))
+	if _, err := jwt.Parse(forgedToken, set.Keyfunc); err == nil {
+		t.Error("expected token with the wrong algorithm to be rejected")
+	}
+
+	// duplicate kids and verify-only signing keys are configuration errors
+	if _, err := NewKeySet(newKey, newKey); err == nil {
+		t.Error("expected an error for duplicate key ids")
+	}
+	pub, _ := ParseKey("pub", pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&oldRSA.PublicKey)}))
+	if _, err := NewKeySet(pub); err == nil {
+		t.Error("expected an error signing with a public key")
+	}
+}
+
+func Test_KeySet_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	signing, _ := ParseKey("rsa", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	verify, _ := ParseKey("ed", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	set, _ := NewKeySet(signing, verify, NewHMACKey("hmac", []byte("secret")))
+	jwks := set.JWKS()
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 published keys, got %d", len(jwks.Keys))
+	}
+
+	if jwks.Keys[0].KeyID != "ed" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[0].Curve != "Ed25519" || jwks.Keys[0].X == "" {
+		t.Errorf("wrong Ed25519 JWK: %+v", jwks.Keys[0])
+	}
+
+	if jwks.Keys[1].KeyID != "rsa" || jwks.Keys[1].KeyType != "RSA" || jwks.Keys[1].E != "AQAB" || jwks.Keys[1].N == "" {
+		t.Errorf("wrong RSA JWK: %+v", jwks.Keys[1])
+	}
+
+	// an HMAC only key set publishes nothing
+	hmacSet, _ := NewKeySet(NewHMACKey("", []byte("secret")))
+	if len(hmacSet.JWKS().Keys) != 0 {
+		t.Error("expected HMAC keys to stay private")
+	}
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNew_redactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	logger.Info("login", "email", "admin@example.com", "password", "hunter2",
+		slog.Group("form", "new_password", "hunter3", "csrf_token", "abc"), "Authorization", "Bearer xyz")
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "hunter3", "abc", "xyz"} {
+		if bytes.Contains(buf.Bytes(), []byte(secret)) {
+			t.Errorf("expected %q to be redacted, got %s", secret, out)
+		}
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a json line, got %s", out)
+	}
+	if line["email"] != "admin@example.com" {
+		t.Errorf("expected other attributes to be kept, got %s", out)
+	}
+	if line["password"] != Redacted {
+		t.Errorf("expected the password to be replaced with %s, got %s", Redacted, out)
+	}
+}
+
+func TestNew_requestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	ctx := context.WithValue(context.Background(), requestKey, &request{id: "req-1"})
+	logger.InfoContext(ctx, "hello")
+
+	var line map[string]any
+	_ = json.Unmarshal(buf.Bytes(), &line)
+	if line["request_id"] != "req-1" {
+		t.Errorf("expected the request id to be logged, got %s", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected slog.Level
+		valid    bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"INFO", slog.LevelInfo, true},
+		{"warn", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"loud", 0, false},
+	}
+
+	for _, e := range tests {
+		level, err := ParseLevel(e.name)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %v, got error %v", e.name, e.valid, err)
+		}
+		if e.valid && level != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, level)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIDHeader carries a request's id in, from a proxy or client, and back out
+const RequestIDHeader = "X-Request-ID"
+
+// the longest request id taken from a client; anything longer gets a fresh one
+const maxRequestIDLength = 128
+
+type contextKey string
+
+const requestKey contextKey = "logging_request"
+
+// request is what the middleware knows about a request, shared with the handlers below it
+type request struct {
+	id     string
+	userID int
+}
+
+// RequestIDFromContext returns the id the middleware gave the request, or "" outside of one
+func RequestIDFromContext(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUserID notes who made the request, for its log line. Authentication middleware calls it
+// once it knows.
+func SetUserID(ctx context.Context, id int) {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		req.userID = id
+	}
+}
+
+// Middleware gives every request an id, the one in its X-Request-ID header if that looks
+// sane, sends it back in the response, and logs the request once it has been served. Only
+// the path is logged, never the query string, which can hold tokens.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			req := &request{id: r.Header.Get(RequestIDHeader)}
+			if !validRequestID(req.id) {
+				req.id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, req.id)
+
+			ctx := context.WithValue(r.Context(), requestKey, req)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("latency", time.Since(start)),
+			}
+			if req.userID != 0 {
+				attrs = append(attrs, slog.Int("user_id", req.userID))
+			}
+
+			logger.LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+// validRequestID accepts ids made of letters, digits and a little punctuation, so a client
+// can't write anything odd into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var tests = []struct {
+		name          string
+		requestID     string
+		userID        int
+		status        int
+		expectedID    string
+		expectedLevel string
+	}{
+		{"propagates the client's id", "abc-123", 0, http.StatusOK, "abc-123", "INFO"},
+		{"replaces an odd id", "bad id\n{}", 0, http.StatusOK, "", "INFO"},
+		{"replaces a long id", strings.Repeat("a", 200), 0, http.StatusOK, "", "INFO"},
+		{"assigns an id", "", 7, http.StatusOK, "", "INFO"},
+		{"server error", "", 0, http.StatusInternalServerError, "", "ERROR"},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		logger := New(&buf, slog.LevelInfo)
+
+		var seenID string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenID = RequestIDFromContext(r.Context())
+			if e.userID != 0 {
+				SetUserID(r.Context(), e.userID)
+			}
+			w.WriteHeader(e.status)
+			_, _ = w.Write([]byte("hello"))
+		})
+
+		req := httptest.NewRequest("GET", "/verify-email?token=secret", nil)
+		if e.requestID != "" {
+			req.Header.Set(RequestIDHeader, e.requestID)
+		}
+		rr := httptest.NewRecorder()
+		Middleware(logger)(next).ServeHTTP(rr, req)
+
+		id := rr.Header().Get(RequestIDHeader)
+		if e.expectedID != "" && id != e.expectedID {
+			t.Errorf("%s: expected request id %q, got %q", e.name, e.expectedID, id)
+		}
+		if e.expectedID == "" && (len(id) != 32 || id == e.requestID) {
+			t.Errorf("%s: expected a generated request id, got %q", e.name, id)
+		}
+		if seenID != id {
+			t.Errorf("%s: expected the handler to see id %q, got %q", e.name, id, seenID)
+		}
+
+		var line map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%s: expected a json log line, got %s", e.name, buf.String())
+		}
+		if line["request_id"] != id || line["method"] != "GET" || line["path"] != "/verify-email" ||
+			line["status"] != float64(e.status) || line["bytes"] != float64(5) || line["level"] != e.expectedLevel {
+			t.Errorf("%s: unexpected log line %s", e.name, buf.String())
+		}
+		if _, ok := line["latency"]; !ok {
+			t.Errorf("%s: expected the latency to be logged", e.name)
+		}
+		if e.userID != 0 && line["user_id"] != float64(e.userID) {
+			t.Errorf("%s: expected user id %d, got %v", e.name, e.userID, line["user_id"])
+		}
+		if strings.Contains(buf.String(), "secret") {
+			t.Errorf("%s: expected the query string not to be logged", e.name)
+		}
+	}
+}
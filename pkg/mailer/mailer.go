@@ -0,0 +1,87 @@
+// Package mailer sends email to users. Real delivery is behind the Mailer interface; the
+// log and file mailers here are for local development and tests.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders a message the way it would go over the wire
+func format(msg Message) string {
+	return fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		msg.To, msg.Subject, time.Now().UTC().Format(time.RFC1123Z), msg.Body)
+}
+
+// LogMailer writes every message to Out instead of sending it
+type LogMailer struct {
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+// NewLogMailer returns a mailer that writes messages to out, or to stderr when out is nil
+func NewLogMailer(out io.Writer) *LogMailer {
+	if out == nil {
+		out = os.Stderr
+	}
+	return &LogMailer{Out: out}
+}
+
+// Send writes the message out
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := io.WriteString(m.Out, "----- mail -----\r\n"+format(msg))
+	return err
+}
+
+// FileMailer writes every message to its own .eml file in Dir
+type FileMailer struct {
+	Dir string
+}
+
+// Send writes the message to a new file named after the time and recipient
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// keep the recipient in the name readable, but never let it leave the directory
+	recipient := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(format(msg)), 0o644)
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_LogMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := NewLogMailer(&out)
+
+	err := m.Send(context.Background(), Message{To: "admin@example.com", Subject: "Hello", Body: "the body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"To: admin@example.com", "Subject: Hello", "the body"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in the output, got %s", expected, out.String())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Send(ctx, Message{}); err == nil {
+		t.Error("expected an error sending with a cancelled context")
+	}
+}
+
+func Test_FileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir}
+
+	err := m.Send(context.Background(), Message{To: "../../admin@example.com", Subject: "Hello", Body: "the body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected one message file, found %d", len(entries))
+	}
+
+	contents, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	if !strings.Contains(string(contents), "the body") {
+		t.Errorf("message body missing from %s", contents)
+	}
+}
@@ -0,0 +1,127 @@
+// Package metrics collects prometheus metrics for the http servers: requests by route,
+// login outcomes, database pool stats and repository call timings.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// login outcomes, counted by Login. A login with two-factor on is two attempts: the password
+// step counts as LoginMFARequired, and only the code step can count as LoginSuccess.
+const (
+	LoginSuccess     = "success"
+	LoginBadPassword = "bad_password"
+	LoginUnknownUser = "unknown_user"
+	LoginUnverified  = "unverified"
+	LoginBlocked     = "blocked"
+	LoginMFARequired = "mfa_required"
+	LoginBadMFACode  = "bad_mfa_code"
+)
+
+// the route label of requests no route matched, so scanners can't blow up the label count
+const unmatchedRoute = "unmatched"
+
+// Metrics holds one server's metrics, in a registry of its own
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	logins   *prometheus.CounterVec
+	queries  *prometheus.HistogramVec
+}
+
+// New returns metrics registered along with the go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by chi route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "How long HTTP requests took to serve, by chi route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "logins_total",
+			Help: "Login attempts, by outcome.",
+		}, []string{"outcome"}),
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_repository_duration_seconds",
+			Help:    "How long repository methods took, by method.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		}, []string{"method"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.logins,
+		m.queries,
+	)
+
+	// every outcome shows up from the start, at zero, rather than after the first one
+	for _, outcome := range []string{LoginSuccess, LoginBadPassword, LoginUnknownUser, LoginUnverified, LoginBlocked, LoginMFARequired, LoginBadMFACode} {
+		m.logins.WithLabelValues(outcome)
+	}
+
+	return m
+}
+
+// RegisterDB exports the connection pool stats of db, as go_sql_* gauges labelled with name
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics for prometheus to scrape
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware counts and times requests. It labels them with the route pattern chi matched,
+// like /users/{userID}, rather than the path, so ids don't each get a series of their own.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && status != http.StatusNotFound {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Login counts a login attempt with one of the Login* outcomes
+func (m *Metrics) Login(outcome string) {
+	m.logins.WithLabelValues(outcome).Inc()
+}
+
+// ObserveQuery records how long a repository method took; it fits PostgresDBRepo.Observe
+func (m *Metrics) ObserveQuery(method string, d time.Duration) {
+	m.queries.WithLabelValues(method).Observe(d.Seconds())
+}
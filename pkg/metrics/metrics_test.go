@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// scrape returns what the metrics handler serves
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d from the metrics handler, got %d", http.StatusOK, rr.Code)
+	}
+	return rr.Body.String()
+}
+
+func TestMetrics_Middleware(t *testing.T) {
+	m := New()
+
+	mux := chi.NewRouter()
+	mux.Use(m.Middleware)
+	mux.Get("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Post("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/users/1", nil),
+		httptest.NewRequest("GET", "/users/2", nil),
+		httptest.NewRequest("POST", "/users/2", nil),
+		httptest.NewRequest("GET", "/wp-admin/setup.php", nil),
+	} {
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	out := scrape(t, m)
+	for _, expected := range []string{
+		`http_requests_total{method="GET",route="/users/{userID}",status="200"} 2`,
+		`http_requests_total{method="POST",route="/users/{userID}",status="422"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/users/{userID}"} 2`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the metrics to have %s", expected)
+		}
+	}
+
+	if strings.Contains(out, "/users/1") || strings.Contains(out, "wp-admin") {
+		t.Error("expected requests to be labelled by route pattern, not path")
+	}
+}
+
+func TestMetrics_Login(t *testing.T) {
+	m := New()
+	m.Login(LoginSuccess)
+	m.Login(LoginBadPassword)
+	m.Login(LoginBadPassword)
+	m.Login(LoginBadMFACode)
+
+	out := scrape(t, m)
+	for _, expected := range []string{
+		`logins_total{outcome="success"} 1`,
+		`logins_total{outcome="bad_password"} 2`,
+		`logins_total{outcome="unknown_user"} 0`,
+		`logins_total{outcome="bad_mfa_code"} 1`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the metrics to have %s", expected)
+		}
+	}
+}
+
+func TestMetrics_database(t *testing.T) {
+	m := New()
+	m.ObserveQuery("GetUser", 2*time.Millisecond)
+
+	// sql.Open doesn't connect, the pool stats are there all the same
+	db, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m.RegisterDB("users", db)
+
+	out := scrape(t, m)
+	for _, expected := range []string{
+		`db_repository_duration_seconds_count{method="GetUser"} 1`,
+		`go_sql_max_open_connections{db_name="users"} 0`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the metrics to have %s", expected)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// Box encrypts the TOTP secrets before they go in the database, with AES-256-GCM
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a box keyed from a passphrase. Use a long random value, and keep it out of
+// the database: anyone with both can read every secret.
+func NewBox(passphrase string) (*Box, error) {
+	if passphrase == "" {
+		return nil, errors.New("mfa: empty encryption key")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts the plain text, and returns the nonce and cipher text base64 encoded
+func (b *Box) Seal(plainText string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(plainText), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value from Seal
+func (b *Box) Open(sealed string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	if len(raw) < b.aead.NonceSize() {
+		return "", errors.New("mfa: sealed value is too short")
+	}
+
+	nonce, cipherText := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+
+	plainText, err := b.aead.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
@@ -0,0 +1,192 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+var (
+	// ErrInvalidCode is returned for a wrong, expired or already used code
+	ErrInvalidCode = errors.New("invalid two-factor code")
+	// ErrAlreadyEnabled is returned when enrolling a user who has two-factor logins on already
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrNotEnrolled is returned when there is no secret to check a code against
+	ErrNotEnrolled = errors.New("two-factor authentication is not set up")
+)
+
+// Manager runs enrollment and code checks against the settings stored in the database. Both
+// the api and the web app use it, so a user's second factor works the same for either.
+type Manager struct {
+	DB     repository.DatabaseRepo
+	Box    *Box
+	Issuer string // shown as the account's name in authenticator apps
+}
+
+// NewManager returns a manager that keeps its secrets encrypted with box
+func NewManager(db repository.DatabaseRepo, box *Box) *Manager {
+	return &Manager{
+		DB:     db,
+		Box:    box,
+		Issuer: "webapp",
+	}
+}
+
+// Enabled reports whether the user has to give a second factor to log in
+func (m *Manager) Enabled(ctx context.Context, userID int) (bool, error) {
+	settings, err := m.DB.GetUserMFA(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return settings.Enabled, nil
+}
+
+// Begin starts enrollment with a new secret. Nothing changes for logins until the user proves
+// their app works by confirming a code with Confirm.
+func (m *Manager) Begin(ctx context.Context, user *data.User) (secret string, uri string, err error) {
+	enabled, err := m.Enabled(ctx, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrAlreadyEnabled
+	}
+
+	secret, err = GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := m.Box.Seal(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = m.DB.SaveUserMFA(ctx, data.UserMFA{UserID: user.ID, Secret: sealed})
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, ProvisioningURI(m.Issuer, user.Email, secret), nil
+}
+
+// Confirm finishes enrollment with a code from the user's app, and returns their first set of
+// recovery codes. This is the only time the codes are available in plain text.
+func (m *Manager) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	settings, err := m.DB.GetUserMFA(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if settings.Enabled {
+		return nil, ErrAlreadyEnabled
+	}
+
+	secret, err := m.Box.Open(settings.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	settings.Enabled = true
+	settings.LastUsedStep = step
+
+	err = m.DB.SaveUserMFA(ctx, *settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.newRecoveryCodes(ctx, userID)
+}
+
+// Verify checks a second factor at login, either a code from the user's app or one of their
+// recovery codes. Either kind works only once.
+func (m *Manager) Verify(ctx context.Context, userID int, code string) error {
+	settings, err := m.DB.GetUserMFA(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled {
+		return ErrNotEnrolled
+	}
+
+	secret, err := m.Box.Open(settings.Secret)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := Validate(secret, code, time.Now()); ok {
+		fresh, err := m.DB.UseMFAStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	used, err := m.DB.ConsumeRecoveryCode(ctx, userID, data.HashToken(NormalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, after checking a current code
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	err := m.Verify(ctx, userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.newRecoveryCodes(ctx, userID)
+}
+
+// Disable turns two-factor logins off, after checking a current code
+func (m *Manager) Disable(ctx context.Context, userID int, code string) error {
+	err := m.Verify(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+
+	return m.DB.DeleteUserMFA(ctx, userID)
+}
+
+func (m *Manager) newRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = data.HashToken(code)
+	}
+
+	err = m.DB.ReplaceRecoveryCodes(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
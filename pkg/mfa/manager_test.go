@@ -0,0 +1,89 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	box, _ := NewBox("test key")
+	m := NewManager(&dbrepo.TestDBRepo{}, box)
+	user := &data.User{ID: 1, Email: "admin@example.com"}
+
+	enabled, err := m.Enabled(ctx, user.ID)
+	if err != nil || enabled {
+		t.Fatalf("expected a new user to have no second factor, got %v, %v", enabled, err)
+	}
+
+	secret, uri, err := m.Begin(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != ProvisioningURI("webapp", user.Email, secret) {
+		t.Errorf("unexpected provisioning uri %s", uri)
+	}
+
+	// starting enrollment doesn't turn anything on
+	if enabled, _ := m.Enabled(ctx, user.ID); enabled {
+		t.Error("expected two-factor to stay off until confirmed")
+	}
+
+	if _, err := m.Confirm(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected a wrong code to fail confirmation, got %v", err)
+	}
+
+	step := Step(time.Now())
+	code, _ := Code(secret, step)
+	recovery, err := m.Confirm(ctx, user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != RecoveryCodeCount {
+		t.Errorf("expected %d recovery codes, got %d", RecoveryCodeCount, len(recovery))
+	}
+
+	if enabled, _ := m.Enabled(ctx, user.ID); !enabled {
+		t.Error("expected two-factor to be on after confirming")
+	}
+
+	if _, _, err := m.Begin(ctx, user); !errors.Is(err, ErrAlreadyEnabled) {
+		t.Errorf("expected enrolling twice to fail, got %v", err)
+	}
+
+	// the code used to confirm can't be used again to log in
+	if err := m.Verify(ctx, user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected a replayed code to fail, got %v", err)
+	}
+
+	next, _ := Code(secret, step+1)
+	if err := m.Verify(ctx, user.ID, next); err != nil {
+		t.Errorf("expected the next code to work, got %v", err)
+	}
+
+	if err := m.Verify(ctx, user.ID, recovery[0]); err != nil {
+		t.Errorf("expected a recovery code to work, got %v", err)
+	}
+	if err := m.Verify(ctx, user.ID, recovery[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected a used recovery code to fail, got %v", err)
+	}
+
+	fresh, err := m.RegenerateRecoveryCodes(ctx, user.ID, recovery[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(ctx, user.ID, recovery[2]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected old recovery codes to stop working, got %v", err)
+	}
+
+	if err := m.Disable(ctx, user.ID, fresh[0]); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := m.Enabled(ctx, user.ID); enabled {
+		t.Error("expected two-factor to be off after disabling")
+	}
+}
@@ -0,0 +1,158 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors from RFC 6238 appendix B, cut down to six digits
+func TestCode_rfc6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(secret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2022, 8, 19, 12, 0, 0, 0, time.UTC)
+	current, _ := Code(secret, Step(now))
+	previous, _ := Code(secret, Step(now)-1)
+	stale, _ := Code(secret, Step(now)-2)
+
+	var tests = []struct {
+		name  string
+		code  string
+		valid bool
+		step  int64
+	}{
+		{"current", current, true, Step(now)},
+		{"with spaces", current[:3] + " " + current[3:], true, Step(now)},
+		{"previous period", previous, true, Step(now) - 1},
+		{"too old", stale, false, 0},
+		{"too short", current[:5], false, 0},
+	}
+
+	for _, e := range tests {
+		step, ok := Validate(secret, e.code, now)
+		if ok != e.valid || step != e.step {
+			t.Errorf("%s: expected %v at step %d, but got %v at step %d", e.name, e.valid, e.step, ok, step)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Web App", "me@example.com", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("wrong scheme or type in %s", uri)
+	}
+
+	if u.Path != "/Web App:me@example.com" {
+		t.Errorf("wrong label in %s", uri)
+	}
+
+	if u.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || u.Query().Get("issuer") != "Web App" {
+		t.Errorf("wrong parameters in %s", uri)
+	}
+}
+
+func TestQRCodeDataURL(t *testing.T) {
+	url, err := QRCodeDataURL(ProvisioningURI("webapp", "me@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(url, "data:image/png;base64,iVBORw0KGgo") {
+		t.Errorf("expected a base64 png data url, got %.40s", url)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("badly formatted code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+
+		typed := " " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + " "
+		if NormalizeRecoveryCode(typed) != code {
+			t.Errorf("expected %q to normalize to %q, got %q", typed, code, NormalizeRecoveryCode(typed))
+		}
+	}
+}
+
+func TestBox(t *testing.T) {
+	box, err := NewBox("a long random key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Error("sealed value contains the plain text")
+	}
+
+	again, _ := box.Seal("JBSWY3DPEHPK3PXP")
+	if again == sealed {
+		t.Error("sealing twice should use a fresh nonce")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected to open the sealed value, got %q, %v", opened, err)
+	}
+
+	other, _ := NewBox("some other key")
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("expected a different key to fail to open the value")
+	}
+
+	if _, err := NewBox(""); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
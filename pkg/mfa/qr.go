@@ -0,0 +1,17 @@
+package mfa
+
+import (
+	"encoding/base64"
+
+	"rsc.io/qr"
+)
+
+// QRCodeDataURL renders a provisioning uri as a PNG QR code, in a data: url ready for an img tag
+func QRCodeDataURL(uri string) (string, error) {
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()), nil
+}
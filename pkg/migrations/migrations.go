@@ -0,0 +1,353 @@
+// Package migrations keeps the database schema up to date. Migrations are embedded SQL
+// files named NNNN_description.up.sql and NNNN_description.down.sql, applied in version
+// order and recorded, with a checksum, in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// lockKey is an arbitrary key for pg_advisory_lock, so that two binaries starting at the
+// same time don't both try to migrate
+const lockKey = 7202301
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes whether a migration has been applied to the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator runs migrations against a database
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New returns a migrator for the migrations embedded in this package
+func New(db *sql.DB) (*Migrator, error) {
+	sqlFiles, err := fs.Sub(embedded, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(sqlFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Run applies every pending embedded migration, and is what the servers call on startup
+func Run(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	m, err := New(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Up(ctx)
+}
+
+// Load reads the migrations in the root of fsys, sorted by version. Every version needs
+// both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || path.Ext(fileName) != ".sql" {
+			continue
+		}
+
+		version, name, direction, err := parseFileName(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := fs.ReadFile(fsys, fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+			m.Checksum = checksum(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// parseFileName splits 0001_create_users.up.sql into 1, create_users and up
+func parseFileName(fileName string) (int, string, string, error) {
+	base := strings.TrimSuffix(fileName, ".sql")
+
+	var direction string
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		direction = "up"
+	case strings.HasSuffix(base, ".down"):
+		direction = "down"
+	default:
+		return 0, "", "", fmt.Errorf("migration %s must end in .up.sql or .down.sql", fileName)
+	}
+	base = strings.TrimSuffix(base, "."+direction)
+
+	prefix, name, found := strings.Cut(base, "_")
+	if !found || name == "" {
+		return 0, "", "", fmt.Errorf("migration %s must be named NNNN_description", fileName)
+	}
+
+	version, err := strconv.Atoi(prefix)
+	if err != nil || version < 1 {
+		return 0, "", "", fmt.Errorf("migration %s does not start with a version number", fileName)
+	}
+
+	return version, name, direction, nil
+}
+
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns
+// the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var ran []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+
+				_, err := tx.ExecContext(ctx,
+					`insert into schema_migrations (version, name, checksum, applied_at) values ($1, $2, $3, $4)`,
+					migration.Version, migration.Name, migration.Checksum, time.Now(),
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("applying migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			ran = append(ran, migration)
+		}
+
+		return nil
+	})
+
+	return ran, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns the ones it
+// rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var ran []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		known := map[int]Migration{}
+		for _, migration := range m.Migrations {
+			known[migration.Version] = migration
+		}
+
+		var versions []int
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, ok := known[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d is applied, but there is no down file for it", versions[i])
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+
+				_, err := tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rolling back migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			ran = append(ran, migration)
+		}
+
+		return nil
+	})
+
+	return ran, err
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			appliedAt, ok := applied[migration.Version]
+			statuses = append(statuses, Status{
+				Migration: migration,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// applied returns when each applied migration ran. It fails if a migration has been edited
+// since it was applied, because the database would no longer match the files.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	stmt := `create table if not exists schema_migrations (
+		version integer primary key,
+		name character varying(255) not null,
+		checksum character varying(64) not null,
+		applied_at timestamp without time zone not null
+	)`
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version, checksum, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := map[int]string{}
+	for _, migration := range m.Migrations {
+		checksums[migration.Version] = migration.Checksum
+	}
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var sum string
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &sum, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		if expected, ok := checksums[version]; ok && expected != sum {
+			return nil, fmt.Errorf("migration %d has changed since it was applied", version)
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// withLock runs fn on a single connection holding the migration advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+	}()
+
+	return fn(conn)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func Test_Load(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("create table b (id int);")},
+		"0002_second.down.sql": {Data: []byte("drop table b;")},
+		"0001_first.up.sql":    {Data: []byte("create table a (id int);")},
+		"0001_first.down.sql":  {Data: []byte("drop table a;")},
+		"README.md":            {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+
+	if migrations[0].Version != 1 || migrations[0].Name != "first" || migrations[1].Version != 2 {
+		t.Errorf("migrations not sorted by version: %+v", migrations)
+	}
+
+	if migrations[0].Down != "drop table a;" {
+		t.Errorf("wrong down migration: %s", migrations[0].Down)
+	}
+
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Error("expected a distinct checksum for each migration")
+	}
+}
+
+func Test_LoadErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"missing down", fstest.MapFS{
+			"0001_first.up.sql": {Data: []byte("select 1;")},
+		}},
+		{"missing up", fstest.MapFS{
+			"0001_first.down.sql": {Data: []byte("select 1;")},
+		}},
+		{"no direction", fstest.MapFS{
+			"0001_first.sql": {Data: []byte("select 1;")},
+		}},
+		{"no version", fstest.MapFS{
+			"first.up.sql":   {Data: []byte("select 1;")},
+			"first.down.sql": {Data: []byte("select 1;")},
+		}},
+		{"two names for one version", fstest.MapFS{
+			"0001_first.up.sql":   {Data: []byte("select 1;")},
+			"0001_other.down.sql": {Data: []byte("select 1;")},
+		}},
+	}
+
+	for _, e := range tests {
+		if _, err := Load(e.fsys); err == nil {
+			t.Errorf("%s: expected an error, but did not get one", e.name)
+		}
+	}
+}
+
+func Test_embeddedMigrations(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// versions must be contiguous, so a missing file is noticed
+	for i, migration := range m.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf("expected migration %d, but found %d (%s)", i+1, migration.Version, migration.Name)
+		}
+	}
+}
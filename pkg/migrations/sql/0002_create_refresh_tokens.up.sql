@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    family_id character varying(32) NOT NULL,
+    revoked boolean DEFAULT false NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens (family_id);
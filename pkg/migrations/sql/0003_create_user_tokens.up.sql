@@ -0,0 +1,10 @@
+CREATE TABLE public.user_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    scope character varying(32) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE INDEX user_tokens_user_id_scope_idx ON public.user_tokens (user_id, scope);
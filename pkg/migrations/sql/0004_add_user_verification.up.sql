@@ -0,0 +1,4 @@
+-- accounts that existed before self-registration were all made by an admin, so they count as
+-- verified. New rows default to unverified.
+ALTER TABLE public.users ADD COLUMN verified boolean NOT NULL DEFAULT true;
+ALTER TABLE public.users ALTER COLUMN verified SET DEFAULT false;
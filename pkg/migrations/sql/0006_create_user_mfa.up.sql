@@ -0,0 +1,16 @@
+CREATE TABLE public.user_mfa (
+    user_id integer PRIMARY KEY REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    secret text NOT NULL,
+    enabled boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE public.user_recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    created_at timestamp without time zone,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE public.user_image_variants (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    image_id integer NOT NULL REFERENCES public.user_images(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    variant character varying(16) NOT NULL,
+    file_name character varying(255) NOT NULL,
+    mime_type character varying(32) NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size bigint NOT NULL,
+    created_at timestamp without time zone,
+    UNIQUE (image_id, variant)
+);
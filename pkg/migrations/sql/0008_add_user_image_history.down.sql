@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS public.user_images_one_active;
+
+-- only the active image survives, as it did before the history was kept
+DELETE FROM public.user_images WHERE NOT active;
+
+ALTER TABLE public.user_images DROP COLUMN IF EXISTS active;
@@ -0,0 +1,7 @@
+-- keep every image a user uploads, with at most one of them in use as the profile picture
+ALTER TABLE public.user_images
+    ADD COLUMN active boolean NOT NULL DEFAULT false;
+
+UPDATE public.user_images SET active = true;
+
+CREATE UNIQUE INDEX user_images_one_active ON public.user_images (user_id) WHERE active;
@@ -0,0 +1,8 @@
+-- web app sessions, for the postgres session store
+CREATE TABLE public.sessions (
+    token text PRIMARY KEY,
+    data bytea NOT NULL,
+    expiry timestamp with time zone NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON public.sessions (expiry);
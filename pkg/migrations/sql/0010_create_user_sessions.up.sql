@@ -0,0 +1,17 @@
+-- where each user is logged in: one row per web app login, and one per api refresh token family
+CREATE TABLE public.user_sessions (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    kind character varying(8) NOT NULL,
+    token_id character varying(64) NOT NULL,
+    device text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    ip character varying(64) NOT NULL DEFAULT '',
+    revoked boolean NOT NULL DEFAULT false,
+    expires_at timestamp without time zone NOT NULL,
+    last_seen_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    UNIQUE (kind, token_id)
+);
+
+CREATE INDEX user_sessions_user_id_idx ON public.user_sessions (user_id);
@@ -0,0 +1,30 @@
+-- one account per email address, however it is capitalised.
+--
+-- Accounts whose addresses differ only in case have to be sorted out by hand before this can
+-- run: which one to keep, and what becomes of the other's sessions, images and tokens, isn't
+-- for a migration to decide. Find them with
+--
+--     SELECT lower(email), array_agg(id ORDER BY id) FROM public.users
+--     GROUP BY lower(email) HAVING count(*) > 1;
+--
+-- then delete the extra accounts, or change their addresses, and migrate again. Until then the
+-- migration stops here with that list, instead of failing on the index.
+DO $$
+DECLARE
+    duplicates text;
+BEGIN
+    SELECT string_agg(format('%s (ids %s)', address, ids), ', ')
+      INTO duplicates
+      FROM (SELECT lower(email) AS address, string_agg(id::text, ', ' ORDER BY id) AS ids
+              FROM public.users
+             GROUP BY lower(email)
+            HAVING count(*) > 1) AS clashes;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'some accounts have email addresses that differ only in case: %', duplicates
+            USING HINT = 'Delete or readdress the extra accounts, as described in 0011_unique_user_email.up.sql, then migrate again.';
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX users_email_key ON public.users (lower(email));
@@ -0,0 +1,2 @@
+-- bumped on every change to a user, so writers can tell when someone else got there first
+ALTER TABLE public.users ADD COLUMN version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,74 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"webapp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+)
+
+// postgres error codes the repository translates
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgCheckViolation       = "23514"
+	pgStringTooLong        = "22001"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// the unique index that keeps email addresses to one account
+const usersEmailIndex = "users_email_key"
+
+// dbError translates the database errors callers act on into the repository's, wrapping the
+// original for the logs. Anything else is returned as it is.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	// already translated, by a helper the method called
+	for _, known := range []error{repository.ErrNotFound, repository.ErrDuplicateEmail, repository.ErrConflict, repository.ErrInvalid} {
+		if errors.Is(err, known) {
+			return err
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		if pgErr.ConstraintName == usersEmailIndex {
+			return fmt.Errorf("%w: %w", repository.ErrDuplicateEmail, err)
+		}
+		return fmt.Errorf("%w: %w", repository.ErrConflict, err)
+	case pgSerializationFailure, pgDeadlockDetected:
+		return fmt.Errorf("%w: %w", repository.ErrConflict, err)
+	case pgForeignKeyViolation, pgNotNullViolation, pgCheckViolation, pgStringTooLong:
+		return fmt.Errorf("%w: %w", repository.ErrInvalid, err)
+	}
+
+	return err
+}
+
+// rowAffected returns ErrNotFound when an update or delete didn't match a row
+func rowAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
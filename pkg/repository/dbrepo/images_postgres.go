@@ -0,0 +1,286 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// InsertUserImage inserts a user profile image, and all of its variants, into the database.
+// It becomes the active image; the previous ones are kept in the history.
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	defer m.observe("InsertUserImage", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+
+	stmt := `update user_images set active = false, updated_at = $2 where user_id = $1 and active`
+	_, err = tx.ExecContext(ctx, stmt, i.UserID, time.Now())
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	var newID int
+	stmt = `insert into user_images (user_id, file_name, active, created_at, updated_at)
+		values ($1, $2, true, $3, $4) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		i.UserID,
+		i.FileName,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	stmt = `insert into user_image_variants (image_id, variant, file_name, mime_type, width, height, size, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for _, v := range i.Variants {
+		_, err = tx.ExecContext(ctx, stmt,
+			newID,
+			v.Variant,
+			v.FileName,
+			v.MIMEType,
+			v.Width,
+			v.Height,
+			v.Size,
+			time.Now(),
+		)
+		if err != nil {
+			return 0, dbError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// UserImageUsage returns the bytes taken by every stored variant of a user's images
+func (m *PostgresDBRepo) UserImageUsage(ctx context.Context, userID int) (int64, error) {
+	defer m.observe("UserImageUsage", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select coalesce(sum(v.size), 0)
+			  from user_image_variants v
+			  join user_images i on i.id = v.image_id
+			  where i.user_id = $1`
+
+	var usage int64
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&usage)
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return usage, nil
+}
+
+// GetUserImages returns every image a user has uploaded, newest first
+func (m *PostgresDBRepo) GetUserImages(ctx context.Context, userID int) ([]*data.UserImage, error) {
+	defer m.observe("GetUserImages", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, active, created_at, updated_at
+			  from user_images
+			  where user_id = $1
+			  order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var userImages []*data.UserImage
+	byID := make(map[int]*data.UserImage)
+
+	for rows.Next() {
+		var i data.UserImage
+		err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.FileName,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		userImages = append(userImages, &i)
+		byID[i.ID] = &i
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	query = `select v.image_id, v.variant, v.file_name, v.mime_type, v.width, v.height, v.size
+			 from user_image_variants v
+			 join user_images i on i.id = v.image_id
+			 where i.user_id = $1
+			 order by v.width`
+
+	variantRows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer variantRows.Close()
+
+	for variantRows.Next() {
+		var imageID int
+		var v data.ImageVariant
+		err := variantRows.Scan(
+			&imageID,
+			&v.Variant,
+			&v.FileName,
+			&v.MIMEType,
+			&v.Width,
+			&v.Height,
+			&v.Size,
+		)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		if i, ok := byID[imageID]; ok {
+			i.Variants = append(i.Variants, v)
+		}
+	}
+
+	return userImages, variantRows.Err()
+}
+
+// GetUserImage returns one image, with its variants
+func (m *PostgresDBRepo) GetUserImage(ctx context.Context, id int) (*data.UserImage, error) {
+	defer m.observe("GetUserImage", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, active, created_at, updated_at
+			  from user_images
+			  where id = $1`
+
+	var i data.UserImage
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FileName,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	i.Variants, err = m.imageVariants(ctx, i.ID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return &i, nil
+}
+
+// SetActiveUserImage makes one of a user's earlier images their profile picture again. It
+// returns repository.ErrNotFound when the user has no image with that id.
+func (m *PostgresDBRepo) SetActiveUserImage(ctx context.Context, userID, id int) error {
+	defer m.observe("SetActiveUserImage", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `select 1 from user_images where id = $1 and user_id = $2`, id, userID).Scan(&exists)
+	if err != nil {
+		return dbError(err)
+	}
+
+	// clear the old one first, only one image may be active at a time
+	stmt := `update user_images set active = false, updated_at = $2 where user_id = $1 and active`
+	_, err = tx.ExecContext(ctx, stmt, userID, time.Now())
+	if err != nil {
+		return dbError(err)
+	}
+
+	stmt = `update user_images set active = true, updated_at = $2 where id = $1`
+	_, err = tx.ExecContext(ctx, stmt, id, time.Now())
+	if err != nil {
+		return dbError(err)
+	}
+
+	return dbError(tx.Commit())
+}
+
+// DeleteUserImage deletes an image and its variant records. The stored files are left to the caller.
+func (m *PostgresDBRepo) DeleteUserImage(ctx context.Context, id int) error {
+	defer m.observe("DeleteUserImage", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from user_images where id = $1`, id)
+	return dbError(err)
+}
+
+// loadProfilePic fills in the variants of the active image GetUser found, if there is one
+func (m *PostgresDBRepo) loadProfilePic(ctx context.Context, u *data.User) error {
+	if u.ProfilePic.ID == 0 {
+		return nil
+	}
+	u.ProfilePic.UserID = u.ID
+	u.ProfilePic.Active = true
+
+	variants, err := m.imageVariants(ctx, u.ProfilePic.ID)
+	if err != nil {
+		return dbError(err)
+	}
+	u.ProfilePic.Variants = variants
+
+	return nil
+}
+
+func (m *PostgresDBRepo) imageVariants(ctx context.Context, imageID int) ([]data.ImageVariant, error) {
+	query := `select variant, file_name, mime_type, width, height, size
+			  from user_image_variants
+			  where image_id = $1
+			  order by width`
+
+	rows, err := m.DB.QueryContext(ctx, query, imageID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var variants []data.ImageVariant
+	for rows.Next() {
+		var v data.ImageVariant
+		err := rows.Scan(
+			&v.Variant,
+			&v.FileName,
+			&v.MIMEType,
+			&v.Width,
+			&v.Height,
+			&v.Size,
+		)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, rows.Err()
+}
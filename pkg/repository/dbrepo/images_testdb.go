@@ -0,0 +1,158 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertUserImage inserts a user profile image into the database, and makes it the active one
+func (m *TestDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, old := range m.images {
+		if old.UserID == i.UserID {
+			old.Active = false
+		}
+	}
+
+	m.lastImageID++
+	i.ID = m.lastImageID
+	i.Active = true
+	i.Variants = append([]data.ImageVariant(nil), i.Variants...)
+	i.CreatedAt = time.Now()
+	i.UpdatedAt = time.Now()
+	m.images = append(m.images, &i)
+
+	return i.ID, nil
+}
+
+// GetUserImages returns every image a user has uploaded, newest first
+func (m *TestDBRepo) GetUserImages(ctx context.Context, userID int) ([]*data.UserImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var userImages []*data.UserImage
+	for k := len(m.images) - 1; k >= 0; k-- {
+		if m.images[k].UserID == userID {
+			i := copyImage(m.images[k])
+			userImages = append(userImages, &i)
+		}
+	}
+
+	return userImages, nil
+}
+
+// GetUserImage returns one image, or repository.ErrNotFound
+func (m *TestDBRepo) GetUserImage(ctx context.Context, id int) (*data.UserImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.images {
+		if stored.ID == id {
+			i := copyImage(stored)
+			return &i, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// SetActiveUserImage makes one of a user's images the active one, or returns repository.ErrNotFound
+func (m *TestDBRepo) SetActiveUserImage(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found bool
+	for _, i := range m.images {
+		if i.ID == id && i.UserID == userID {
+			found = true
+		}
+	}
+	if !found {
+		return repository.ErrNotFound
+	}
+
+	for _, i := range m.images {
+		if i.UserID == userID {
+			i.Active = i.ID == id
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserImage deletes an image record
+func (m *TestDBRepo) DeleteUserImage(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, i := range m.images {
+		if i.ID == id {
+			m.images = append(m.images[:k], m.images[k+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// UserImageUsage returns the bytes taken by every stored variant of a user's images
+func (m *TestDBRepo) UserImageUsage(ctx context.Context, userID int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var usage int64
+	for _, i := range m.images {
+		if i.UserID != userID {
+			continue
+		}
+		for _, v := range i.Variants {
+			usage += v.Size
+		}
+	}
+
+	return usage, nil
+}
+
+// applyProfilePic copies the active image onto u. The caller must hold m.mu.
+func (m *TestDBRepo) applyProfilePic(u *data.User) {
+	for _, i := range m.images {
+		if i.UserID == u.ID && i.Active {
+			u.ProfilePic = copyImage(i)
+		}
+	}
+}
+
+// copyImage returns a copy of i that shares nothing with the stored one
+func copyImage(i *data.UserImage) data.UserImage {
+	c := *i
+	c.Variants = append([]data.ImageVariant(nil), i.Variants...)
+	return c
+}
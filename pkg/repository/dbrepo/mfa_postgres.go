@@ -0,0 +1,160 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// SaveUserMFA stores the two-factor settings for a user, replacing any they had
+func (m *PostgresDBRepo) SaveUserMFA(ctx context.Context, mfa data.UserMFA) error {
+	defer m.observe("SaveUserMFA", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_mfa (user_id, secret, enabled, last_used_step, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (user_id) do update set
+			secret = excluded.secret,
+			enabled = excluded.enabled,
+			last_used_step = excluded.last_used_step,
+			updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		mfa.UserID,
+		mfa.Secret,
+		mfa.Enabled,
+		mfa.LastUsedStep,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// GetUserMFA returns the two-factor settings for a user, or repository.ErrNotFound if they have none
+func (m *PostgresDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	defer m.observe("GetUserMFA", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select user_id, secret, enabled, last_used_step, created_at, updated_at
+			  from user_mfa
+			  where user_id = $1`
+	var mfa data.UserMFA
+
+	row := m.DB.QueryRowContext(ctx, query, userID)
+
+	err := row.Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.Enabled,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &mfa, nil
+}
+
+// UseMFAStep records that a code from the given time step was accepted. It returns false if
+// that step, or a later one, was used already, so the same code can't be replayed.
+func (m *PostgresDBRepo) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	defer m.observe("UseMFAStep", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_mfa set last_used_step = $1, updated_at = $2
+		where user_id = $3 and last_used_step < $1`
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, time.Now(), userID)
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	return rows == 1, nil
+}
+
+// DeleteUserMFA turns off two-factor logins for a user, removing their secret and recovery codes
+func (m *PostgresDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	defer m.observe("DeleteUserMFA", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from user_mfa where user_id = $1`, userID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return dbError(tx.Commit())
+}
+
+// ReplaceRecoveryCodes swaps a user's recovery codes for a new set, given as hashes
+func (m *PostgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	defer m.observe("ReplaceRecoveryCodes", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	stmt := `insert into user_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now())
+		if err != nil {
+			return dbError(err)
+		}
+	}
+
+	return dbError(tx.Commit())
+}
+
+// ConsumeRecoveryCode deletes a matching recovery code, and reports whether there was one
+func (m *PostgresDBRepo) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	defer m.observe("ConsumeRecoveryCode", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_recovery_codes where user_id = $1 and code_hash = $2`
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, codeHash)
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	return rows == 1, nil
+}
@@ -0,0 +1,118 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// SaveUserMFA stores the two-factor settings for a user, replacing any they had
+func (m *TestDBRepo) SaveUserMFA(ctx context.Context, mfa data.UserMFA) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mfa == nil {
+		m.mfa = make(map[int]*data.UserMFA)
+	}
+
+	mfa.CreatedAt = time.Now()
+	mfa.UpdatedAt = time.Now()
+	m.mfa[mfa.UserID] = &mfa
+
+	return nil
+}
+
+// GetUserMFA returns the two-factor settings for a user, or repository.ErrNotFound if they have none
+func (m *TestDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.mfa[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	found := *mfa
+	return &found, nil
+}
+
+// UseMFAStep records that a code from the given time step was accepted. It returns false if
+// that step, or a later one, was used already.
+func (m *TestDBRepo) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.mfa[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return false, nil
+	}
+
+	mfa.LastUsedStep = step
+	return true, nil
+}
+
+// DeleteUserMFA turns off two-factor logins for a user, removing their secret and recovery codes
+func (m *TestDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfa, userID)
+	delete(m.recoveryCodes, userID)
+
+	return nil
+}
+
+// ReplaceRecoveryCodes swaps a user's recovery codes for a new set, given as hashes
+func (m *TestDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.recoveryCodes == nil {
+		m.recoveryCodes = make(map[int][]string)
+	}
+
+	m.recoveryCodes[userID] = append([]string(nil), codeHashes...)
+
+	return nil
+}
+
+// ConsumeRecoveryCode deletes a matching recovery code, and reports whether there was one
+func (m *TestDBRepo) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := m.recoveryCodes[userID]
+	for i, hash := range codes {
+		if hash == codeHash {
+			m.recoveryCodes[userID] = append(codes[:i:i], codes[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
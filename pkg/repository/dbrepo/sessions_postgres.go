@@ -0,0 +1,118 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// PostgresSessionStore keeps the web app's scs sessions in the sessions table, so they survive
+// a restart and are shared by every instance. It implements scs.Store and scs.CtxStore.
+type PostgresSessionStore struct {
+	DB *sql.DB
+
+	stop chan struct{}
+}
+
+// NewPostgresSessionStore returns a store on db. When cleanupInterval isn't 0, expired
+// sessions are deleted in the background that often, until StopCleanup is called.
+func NewPostgresSessionStore(db *sql.DB, cleanupInterval time.Duration) *PostgresSessionStore {
+	s := &PostgresSessionStore{DB: db}
+	if cleanupInterval > 0 {
+		s.stop = make(chan struct{})
+		go s.cleanup(cleanupInterval, s.stop)
+	}
+	return s
+}
+
+// Find returns the data of an unexpired session
+func (s *PostgresSessionStore) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(context.Background(), token)
+}
+
+// FindCtx returns the data of an unexpired session
+func (s *PostgresSessionStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var b []byte
+	query := `select data from sessions where token = $1 and expiry > now()`
+	err := s.DB.QueryRowContext(ctx, query, token).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// Commit saves a session, replacing what was stored under the token
+func (s *PostgresSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	return s.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// CommitCtx saves a session, replacing what was stored under the token
+func (s *PostgresSessionStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	_, err := s.DB.ExecContext(ctx, stmt, token, b, expiry)
+	return err
+}
+
+// Delete removes a session. A token that isn't stored is not an error.
+func (s *PostgresSessionStore) Delete(token string) error {
+	return s.DeleteCtx(context.Background(), token)
+}
+
+// DeleteCtx removes a session. A token that isn't stored is not an error.
+func (s *PostgresSessionStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+// DeleteExpired removes every expired session, and returns how many there were
+func (s *PostgresSessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `delete from sessions where expiry <= now()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// StopCleanup ends the background cleanup. It does nothing when there isn't one.
+func (s *PostgresSessionStore) StopCleanup() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *PostgresSessionStore) cleanup(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(context.Background()); err != nil {
+				slog.Error("deleting expired sessions", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_PostgresSessionStore(t *testing.T) {
+	store := NewPostgresSessionStore(testDB, 0)
+
+	err := store.Commit("live", []byte("first"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// committing again replaces the data
+	_ = store.Commit("live", []byte("second"), time.Now().Add(time.Hour))
+
+	b, found, err := store.Find("live")
+	if err != nil || !found || string(b) != "second" {
+		t.Errorf("expected to find the latest data, got %q %v %v", b, found, err)
+	}
+
+	_ = store.Commit("expired", []byte("old"), time.Now().Add(-time.Minute))
+	if _, found, err := store.Find("expired"); found || err != nil {
+		t.Errorf("expected an expired session not to be found, got %v %v", found, err)
+	}
+
+	n, err := store.DeleteExpired(context.Background())
+	if err != nil || n != 1 {
+		t.Errorf("expected to delete 1 expired session, got %d %v", n, err)
+	}
+
+	if err := store.Delete("live"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := store.Find("live"); found {
+		t.Error("expected a deleted session not to be found")
+	}
+
+	if err := store.Delete("never stored"); err != nil {
+		t.Errorf("expected deleting an unknown token to be a no-op, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// InsertRefreshToken stores a newly issued refresh token, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error) {
+	defer m.observe("InsertRefreshToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, token_hash, family_id, revoked, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.TokenHash,
+		t.FamilyID,
+		false,
+		t.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// GetRefreshToken returns the stored refresh token with the given hash, revoked or not
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*data.RefreshToken, error) {
+	defer m.observe("GetRefreshToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, family_id, revoked, expires_at, created_at, updated_at
+			  from refresh_tokens
+			  where token_hash = $1`
+	var t data.RefreshToken
+
+	row := m.DB.QueryRowContext(ctx, query, tokenHash)
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.FamilyID,
+		&t.Revoked,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &t, nil
+}
+
+// RevokeRefreshToken marks one refresh token as revoked, so that it cannot be used again.
+// It reports false if the token had already been revoked, which is how callers detect reuse.
+func (m *PostgresDBRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	defer m.observe("RevokeRefreshToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where id = $2 and revoked = false`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+
+	return rows == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descended from the same login
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	defer m.observe("RevokeRefreshTokenFamily", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where family_id = $2 and revoked = false`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, logging them out everywhere
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	defer m.observe("RevokeUserRefreshTokens", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where user_id = $2 and revoked = false`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// InsertUserToken stores a single-use token, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUserToken(ctx context.Context, t data.UserToken) (int, error) {
+	defer m.observe("InsertUserToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_tokens (user_id, token_hash, scope, expires_at, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.TokenHash,
+		t.Scope,
+		t.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// ConsumeUserToken deletes and returns an unexpired token with the given scope and hash.
+// Deleting and reading in one statement means a token can only ever be consumed once.
+func (m *PostgresDBRepo) ConsumeUserToken(ctx context.Context, scope, tokenHash string) (*data.UserToken, error) {
+	defer m.observe("ConsumeUserToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_tokens
+			 where token_hash = $1 and scope = $2 and expires_at > $3
+			 returning id, user_id, token_hash, scope, expires_at, created_at`
+	var t data.UserToken
+
+	row := m.DB.QueryRowContext(ctx, stmt, tokenHash, scope, time.Now())
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.Scope,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &t, nil
+}
+
+// DeleteUserTokens deletes every token of one scope for a user
+func (m *PostgresDBRepo) DeleteUserTokens(ctx context.Context, userID int, scope string) error {
+	defer m.observe("DeleteUserTokens", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_tokens where user_id = $1 and scope = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, scope)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertRefreshToken stores a newly issued refresh token, and returns the ID of the newly inserted row
+func (m *TestDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = len(m.refreshTokens) + 1
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = time.Now()
+	m.refreshTokens = append(m.refreshTokens, &t)
+
+	return t.ID, nil
+}
+
+// GetRefreshToken returns the stored refresh token with the given hash, revoked or not
+func (m *TestDBRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*data.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.TokenHash == tokenHash {
+			token := *t
+			return &token, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// RevokeRefreshToken marks one refresh token as revoked, reporting false if it already was
+func (m *TestDBRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.ID == id {
+			if t.Revoked {
+				return false, nil
+			}
+			t.Revoked = true
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descended from the same login
+func (m *TestDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (m *TestDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.UserID == userID {
+			t.Revoked = true
+		}
+	}
+
+	return nil
+}
+
+// InsertUserToken stores a single-use token, and returns the ID of the newly inserted row
+func (m *TestDBRepo) InsertUserToken(ctx context.Context, t data.UserToken) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastUserTokenID++
+	t.ID = m.lastUserTokenID
+	t.CreatedAt = time.Now()
+	m.userTokens = append(m.userTokens, &t)
+
+	return t.ID, nil
+}
+
+// ConsumeUserToken deletes and returns an unexpired token with the given scope and hash
+func (m *TestDBRepo) ConsumeUserToken(ctx context.Context, scope, tokenHash string) (*data.UserToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.userTokens {
+		if t.TokenHash == tokenHash && t.Scope == scope && t.ExpiresAt.After(time.Now()) {
+			m.userTokens = append(m.userTokens[:i], m.userTokens[i+1:]...)
+			return t, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// DeleteUserTokens deletes every token of one scope for a user
+func (m *TestDBRepo) DeleteUserTokens(ctx context.Context, userID int, scope string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []*data.UserToken
+	for _, t := range m.userTokens {
+		if t.UserID != userID || t.Scope != scope {
+			kept = append(kept, t)
+		}
+	}
+	m.userTokens = kept
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+const userSessionColumns = `id, user_id, kind, token_id, device, user_agent, ip, revoked, expires_at, last_seen_at, created_at`
+
+// InsertUserSession records a new login, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUserSession(ctx context.Context, s data.UserSession) (int, error) {
+	defer m.observe("InsertUserSession", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_sessions (user_id, kind, token_id, device, user_agent, ip, expires_at, last_seen_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.UserID,
+		s.Kind,
+		s.TokenID,
+		s.Device,
+		s.UserAgent,
+		s.IP,
+		s.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	return newID, nil
+}
+
+// GetUserSession returns one session, revoked or not, or repository.ErrNotFound
+func (m *PostgresDBRepo) GetUserSession(ctx context.Context, id int) (*data.UserSession, error) {
+	defer m.observe("GetUserSession", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userSessionColumns + ` from user_sessions where id = $1`
+	return scanUserSession(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetUserSessionByToken returns the session of a web session id or refresh token family,
+// revoked or not, or repository.ErrNotFound
+func (m *PostgresDBRepo) GetUserSessionByToken(ctx context.Context, kind, tokenID string) (*data.UserSession, error) {
+	defer m.observe("GetUserSessionByToken", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userSessionColumns + ` from user_sessions where kind = $1 and token_id = $2`
+	return scanUserSession(m.DB.QueryRowContext(ctx, query, kind, tokenID))
+}
+
+// GetUserSessions returns a user's active sessions, most recently seen first. An api session
+// is only active while its family still has a refresh token that can be used, so logging out,
+// a detected token reuse or a password reset all end it without touching this table.
+func (m *PostgresDBRepo) GetUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error) {
+	defer m.observe("GetUserSessions", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userSessionColumns + ` from user_sessions s
+		where s.user_id = $1 and not s.revoked and s.expires_at > $2
+		and (s.kind <> $3 or exists (
+			select 1 from refresh_tokens t
+			where t.family_id = s.token_id and not t.revoked and t.expires_at > $2
+		))
+		order by s.last_seen_at desc, s.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now(), data.SessionAPI)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var sessions []*data.UserSession
+	for rows.Next() {
+		s, err := scanUserSession(rows)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	return sessions, nil
+}
+
+// TouchUserSession records that a session was just used, and from where. The expiry is only
+// ever pushed back, so a zero expiresAt leaves it as it is.
+func (m *PostgresDBRepo) TouchUserSession(ctx context.Context, kind, tokenID, ip string, expiresAt time.Time) error {
+	defer m.observe("TouchUserSession", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_sessions set last_seen_at = $1, ip = $2, expires_at = greatest(expires_at, $3)
+		where kind = $4 and token_id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), ip, expiresAt, kind, tokenID)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// RevokeUserSession ends one session. Revoking an api session revokes its refresh tokens too.
+func (m *PostgresDBRepo) RevokeUserSession(ctx context.Context, id int) error {
+	defer m.observe("RevokeUserSession", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	stmt := `update user_sessions set revoked = true where id = $1`
+	if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+		return dbError(err)
+	}
+
+	stmt = `update refresh_tokens set revoked = true, updated_at = $1
+		where not revoked and family_id in (select token_id from user_sessions where id = $2 and kind = $3)`
+	if _, err := tx.ExecContext(ctx, stmt, time.Now(), id, data.SessionAPI); err != nil {
+		return dbError(err)
+	}
+
+	return dbError(tx.Commit())
+}
+
+// RevokeUserSessions ends every session of a user, except the one with id exceptID, which
+// is 0 to end them all
+func (m *PostgresDBRepo) RevokeUserSessions(ctx context.Context, userID, exceptID int) error {
+	defer m.observe("RevokeUserSessions", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	stmt := `update user_sessions set revoked = true where user_id = $1 and id <> $2 and not revoked`
+	if _, err := tx.ExecContext(ctx, stmt, userID, exceptID); err != nil {
+		return dbError(err)
+	}
+
+	stmt = `update refresh_tokens set revoked = true, updated_at = $1
+		where not revoked and family_id in (
+			select token_id from user_sessions where user_id = $2 and id <> $3 and kind = $4
+		)`
+	if _, err := tx.ExecContext(ctx, stmt, time.Now(), userID, exceptID, data.SessionAPI); err != nil {
+		return dbError(err)
+	}
+
+	return dbError(tx.Commit())
+}
+
+// scanUserSession reads the userSessionColumns of one row
+func scanUserSession(row interface{ Scan(...any) error }) (*data.UserSession, error) {
+	var s data.UserSession
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.Kind,
+		&s.TokenID,
+		&s.Device,
+		&s.UserAgent,
+		&s.IP,
+		&s.Revoked,
+		&s.ExpiresAt,
+		&s.LastSeenAt,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &s, nil
+}
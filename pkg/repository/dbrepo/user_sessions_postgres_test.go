@@ -0,0 +1,98 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+)
+
+func Test_PostgresDBRepo_UserSessions(t *testing.T) {
+	ctx := context.Background()
+
+	web, err := testRepo.InsertUserSession(ctx, data.UserSession{
+		UserID:    1,
+		Kind:      data.SessionWeb,
+		TokenID:   "web-session",
+		Device:    "Firefox on Linux",
+		IP:        "192.0.2.1",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal("Inserting user session failed:", err)
+	}
+
+	// an api session lasts as long as its refresh token family
+	_, _ = testRepo.InsertRefreshToken(ctx, data.RefreshToken{
+		UserID:    1,
+		TokenHash: data.HashToken("api-session-token"),
+		FamilyID:  "api-session",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	api, err := testRepo.InsertUserSession(ctx, data.UserSession{
+		UserID:    1,
+		Kind:      data.SessionAPI,
+		TokenID:   "api-session",
+		Device:    "curl",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal("Inserting user session failed:", err)
+	}
+
+	err = testRepo.TouchUserSession(ctx, data.SessionWeb, "web-session", "192.0.2.2", time.Time{})
+	if err != nil {
+		t.Fatal("Touching user session failed:", err)
+	}
+
+	stored, err := testRepo.GetUserSessionByToken(ctx, data.SessionWeb, "web-session")
+	if err != nil {
+		t.Fatal("Getting user session failed:", err)
+	}
+	if stored.ID != web || stored.IP != "192.0.2.2" || !stored.Active() {
+		t.Errorf("Got wrong user session back: %+v", stored)
+	}
+
+	sessions, err := testRepo.GetUserSessions(ctx, 1)
+	if err != nil {
+		t.Fatal("Getting user sessions failed:", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != web {
+		t.Errorf("expected both sessions, the touched one first, got %d", len(sessions))
+	}
+
+	// revoking the api session revokes its refresh tokens too
+	err = testRepo.RevokeUserSession(ctx, api)
+	if err != nil {
+		t.Fatal("Revoking user session failed:", err)
+	}
+	token, _ := testRepo.GetRefreshToken(ctx, data.HashToken("api-session-token"))
+	if !token.Revoked {
+		t.Error("expected the session's refresh tokens to be revoked")
+	}
+
+	err = testRepo.RevokeUserSessions(ctx, 1, web)
+	if err != nil {
+		t.Fatal("Revoking user sessions failed:", err)
+	}
+	sessions, _ = testRepo.GetUserSessions(ctx, 1)
+	if len(sessions) != 1 || sessions[0].ID != web {
+		t.Errorf("expected only the kept session to be left, got %d", len(sessions))
+	}
+
+	err = testRepo.RevokeUserSessions(ctx, 1, 0)
+	if err != nil {
+		t.Fatal("Revoking user sessions failed:", err)
+	}
+	sessions, _ = testRepo.GetUserSessions(ctx, 1)
+	if len(sessions) != 0 {
+		t.Errorf("expected no sessions to be left, got %d", len(sessions))
+	}
+
+	_, err = testRepo.GetUserSession(ctx, 99999)
+	if err == nil {
+		t.Error("expected an error getting a user session that does not exist")
+	}
+}
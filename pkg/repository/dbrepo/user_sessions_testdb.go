@@ -0,0 +1,180 @@
+package dbrepo
+
+import (
+	"context"
+	"sort"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertUserSession records a new login, and returns the ID of the newly inserted row
+func (m *TestDBRepo) InsertUserSession(ctx context.Context, s data.UserSession) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.ID = len(m.sessions) + 1
+	s.Revoked = false
+	s.LastSeenAt = time.Now()
+	s.CreatedAt = time.Now()
+	m.sessions = append(m.sessions, &s)
+
+	return s.ID, nil
+}
+
+// GetUserSession returns one session, revoked or not, or repository.ErrNotFound
+func (m *TestDBRepo) GetUserSession(ctx context.Context, id int) (*data.UserSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.ID == id {
+			c := *s
+			return &c, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// GetUserSessionByToken returns the session of a web session id or refresh token family,
+// revoked or not, or repository.ErrNotFound
+func (m *TestDBRepo) GetUserSessionByToken(ctx context.Context, kind, tokenID string) (*data.UserSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Kind == kind && s.TokenID == tokenID {
+			c := *s
+			return &c, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// GetUserSessions returns a user's active sessions, most recently seen first. An api session
+// is only active while its family still has a usable refresh token.
+func (m *TestDBRepo) GetUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []*data.UserSession
+	for k := len(m.sessions) - 1; k >= 0; k-- {
+		s := m.sessions[k]
+		if s.UserID != userID || !s.Active() {
+			continue
+		}
+		if s.Kind == data.SessionAPI && !m.familyUsable(s.TokenID) {
+			continue
+		}
+		c := *s
+		sessions = append(sessions, &c)
+	}
+
+	// newest first already, the stable sort keeps that order between equal last seen times
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// TouchUserSession records that a session was just used, and from where. The expiry is only
+// ever pushed back.
+func (m *TestDBRepo) TouchUserSession(ctx context.Context, kind, tokenID, ip string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Kind == kind && s.TokenID == tokenID {
+			s.LastSeenAt = time.Now()
+			s.IP = ip
+			if expiresAt.After(s.ExpiresAt) {
+				s.ExpiresAt = expiresAt
+			}
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserSession ends one session. Revoking an api session revokes its refresh tokens too.
+func (m *TestDBRepo) RevokeUserSession(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.ID == id {
+			m.revokeSession(s)
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserSessions ends every session of a user, except the one with id exceptID
+func (m *TestDBRepo) RevokeUserSessions(ctx context.Context, userID, exceptID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.ID != exceptID {
+			m.revokeSession(s)
+		}
+	}
+
+	return nil
+}
+
+// revokeSession marks s revoked, along with its refresh tokens. The caller must hold m.mu.
+func (m *TestDBRepo) revokeSession(s *data.UserSession) {
+	s.Revoked = true
+	if s.Kind != data.SessionAPI {
+		return
+	}
+	for _, t := range m.refreshTokens {
+		if t.FamilyID == s.TokenID {
+			t.Revoked = true
+		}
+	}
+}
+
+// familyUsable reports whether a refresh token family has a token that can still be
+// exchanged. The caller must hold m.mu.
+func (m *TestDBRepo) familyUsable(familyID string) bool {
+	for _, t := range m.refreshTokens {
+		if t.FamilyID == familyID && !t.Revoked && time.Now().Before(t.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
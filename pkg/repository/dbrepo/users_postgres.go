@@ -3,9 +3,12 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -14,22 +17,60 @@ const dbTimeout = time.Second * 3
 
 type PostgresDBRepo struct {
 	DB *sql.DB
+
+	// Observe, when set, is told how long every repository method call took
+	Observe func(method string, d time.Duration)
+}
+
+// observe reports a method call to Observe; methods defer it with the time they started
+func (m *PostgresDBRepo) observe(method string, start time.Time) {
+	if m.Observe != nil {
+		m.Observe(method, time.Since(start))
+	}
 }
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// AllUsers returns one page of users matching the query, along with the total number of matches
+func (m *PostgresDBRepo) AllUsers(ctx context.Context, q repository.UserQuery) ([]*data.User, int, error) {
+	defer m.observe("AllUsers", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `SELECT id, email, first_name, last_name, password, is_admin, created_at, updated_at
-				from users
-				order by last_name`
-	rows, err := m.DB.QueryContext(ctx, query)
+	where, args := userQueryFilters(q)
+
+	var total int
+	countQuery := `select count(*) from users` + where
+	err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, dbError(err)
+	}
+
+	// the sort column is checked against a fixed list, so it is safe to put in the query
+	sort := "last_name"
+	if repository.IsUserSortField(q.Sort) {
+		sort = q.Sort
+	}
+	direction := "asc"
+	if q.Desc {
+		direction = "desc"
+	}
+
+	query := `SELECT id, email, first_name, last_name, password, is_admin, verified, failed_logins, locked_until,
+				created_at, updated_at, version
+				from users` + where +
+		fmt.Sprintf(` order by %s %s, id %s`, sort, direction, direction)
+
+	if q.PageSize > 0 {
+		args = append(args, q.PageSize, q.Offset())
+		query += fmt.Sprintf(` limit $%d offset $%d`, len(args)-1, len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, dbError(err)
 	}
 	defer rows.Close()
 
@@ -44,28 +85,76 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.IsAdmin,
+			&user.Verified,
+			&user.FailedLogins,
+			&user.LockedUntil,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Version,
 		)
 		if err != nil {
-			log.Println("Error scanning", err)
+			return nil, 0, dbError(err)
 		}
 		users = append(users, &user)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, dbError(err)
+	}
 
-	return users, nil
+	return users, total, nil
 }
 
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// userQueryFilters builds the where clause and its arguments for a user query
+func userQueryFilters(q repository.UserQuery) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if q.Email != "" {
+		args = append(args, q.Email)
+		conditions = append(conditions, fmt.Sprintf("lower(email) = lower($%d)", len(args)))
+	}
+
+	if q.NamePrefix != "" {
+		// escape the like wildcards, so the prefix is matched literally
+		prefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.NamePrefix)
+		args = append(args, prefix+"%")
+		conditions = append(conditions, fmt.Sprintf("(first_name ilike $%d or last_name ilike $%d)", len(args), len(args)))
+	}
+
+	if q.IsAdmin != nil {
+		args = append(args, *q.IsAdmin)
+		conditions = append(conditions, fmt.Sprintf("is_admin = $%d", len(args)))
+	}
+
+	if !q.CreatedAfter.IsZero() {
+		args = append(args, q.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if !q.CreatedBefore.IsZero() {
+		args = append(args, q.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
+
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	defer m.observe("GetUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `SELECT 
-				u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-				coalesce(ui.file_name, '')
+				u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.verified,
+				u.failed_logins, u.locked_until, u.created_at, u.updated_at, u.version,
+				coalesce(ui.id, 0), coalesce(ui.file_name, '')
 			  from users u
 			  left join user_images ui
-			  on ui.user_id = u.id
+			  on ui.user_id = u.id and ui.active
 			  where u.id = $1`
 	var user data.User
 
@@ -78,28 +167,41 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.Verified,
+		&user.FailedLogins,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&user.ProfilePic.ID,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
+	}
+
+	if err := m.loadProfilePic(ctx, &user); err != nil {
+		return nil, dbError(err)
 	}
 	return &user, nil
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GetUserByEmail returns one user by email address, however it is capitalised, the same way
+// the unique index on lower(email) compares them
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	defer m.observe("GetUserByEmail", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `SELECT 
-				u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-				coalesce(ui.file_name, '')
+				u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.verified,
+				u.failed_logins, u.locked_until, u.created_at, u.updated_at, u.version,
+				coalesce(ui.id, 0), coalesce(ui.file_name, '')
 			  from users u
 			  left join user_images ui
-			  on ui.user_id = u.id
-			  where u.email = $1`
+			  on ui.user_id = u.id and ui.active
+			  where lower(u.email) = lower($1)`
 	var user data.User
 
 	row := m.DB.QueryRowContext(ctx, query, email)
@@ -111,19 +213,32 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.Verified,
+		&user.FailedLogins,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&user.ProfilePic.ID,
 		&user.ProfilePic.FileName,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
+	}
+
+	if err := m.loadProfilePic(ctx, &user); err != nil {
+		return nil, dbError(err)
 	}
 	return &user, nil
 }
 
-// UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateUser updates one user in the database, or returns repository.ErrNotFound if there is
+// no such user and repository.ErrDuplicateEmail if another user has the email address. When
+// u.Version is set, only that version of the user is updated, and repository.ErrStale is
+// returned if the user has changed since.
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	defer m.observe("UpdateUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
@@ -131,54 +246,123 @@ func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 		first_name = $2,
 		last_name = $3,
 		is_admin = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $5,
+		version = version + 1
+		where id = $6 and ($7 = 0 or version = $7)
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	result, err := m.DB.ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
 		u.IsAdmin,
 		time.Now(),
 		u.ID,
+		u.Version,
 	)
 
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
-	return nil
+	return m.userRowAffected(ctx, result, u.ID, u.Version)
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PatchUser changes only the fields of a user that are set in u, or returns
+// repository.ErrNotFound. An empty update just bumps updated_at and the version. Like
+// UpdateUser, it returns repository.ErrStale when u.Version is set and no longer current.
+func (m *PostgresDBRepo) PatchUser(ctx context.Context, id int, u repository.UserUpdate) error {
+	defer m.observe("PatchUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	var sets []string
+	var args []any
+	set := func(column string, value any) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if u.Email != nil {
+		set("email", *u.Email)
+	}
+	if u.FirstName != nil {
+		set("first_name", *u.FirstName)
+	}
+	if u.LastName != nil {
+		set("last_name", *u.LastName)
+	}
+	if u.IsAdmin != nil {
+		set("is_admin", *u.IsAdmin)
+	}
+	set("updated_at", time.Now())
+	sets = append(sets, "version = version + 1")
+
+	args = append(args, id, u.Version)
+	stmt := fmt.Sprintf(`update users set %s where id = $%d and ($%d = 0 or version = $%d)`,
+		strings.Join(sets, ", "), len(args)-1, len(args), len(args))
+
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
 	if err != nil {
+		return dbError(err)
+	}
+
+	return m.userRowAffected(ctx, result, id, u.Version)
+}
+
+// DeleteUser deletes one user from the database, by id, or returns repository.ErrNotFound.
+// A version other than 0 limits the delete to that version of the user, and
+// repository.ErrStale is returned if the user has changed since.
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id, version int) error {
+	defer m.observe("DeleteUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from users where id = $1 and ($2 = 0 or version = $2)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, version)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return m.userRowAffected(ctx, result, id, version)
+}
+
+// userRowAffected is rowAffected for a write limited to one version of a user. When nothing
+// was written it tells a user who has moved on to another version, repository.ErrStale,
+// from one who doesn't exist.
+func (m *PostgresDBRepo) userRowAffected(ctx context.Context, result sql.Result, id, version int) error {
+	err := rowAffected(result)
+	if version == 0 || !errors.Is(err, repository.ErrNotFound) {
 		return err
 	}
 
-	return nil
+	var exists bool
+	query := `select exists(select 1 from users where id = $1)`
+	if err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return dbError(err)
+	}
+	if exists {
+		return repository.ErrStale
+	}
+	return err
 }
 
-// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row.
+// It returns repository.ErrDuplicateEmail if another user has the email address.
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	defer m.observe("InsertUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, is_admin, verified, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	err = m.DB.QueryRowContext(ctx, stmt,
 		user.Email,
@@ -186,62 +370,97 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 		user.LastName,
 		hashedPassword,
 		user.IsAdmin,
+		user.Verified,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	defer m.observe("ResetPassword", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	stmt := `update users set password = $1, version = version + 1 where id = $2`
+	result, err := m.DB.ExecContext(ctx, stmt, hashedPassword, id)
 	if err != nil {
-		return err
+		return dbError(err)
+	}
+
+	return rowAffected(result)
+}
+
+// VerifyUser marks the user's email address as confirmed
+func (m *PostgresDBRepo) VerifyUser(ctx context.Context, id int) error {
+	defer m.observe("VerifyUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set verified = true, updated_at = $1, version = version + 1 where id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return dbError(err)
 	}
 
 	return nil
 }
 
-// InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// RecordFailedLogin adds one to the user's count of failed logins, and returns the new count.
+// Like LockUser and UnlockUser it leaves the version alone: the login counters change with
+// every failed attempt, and would otherwise make an admin's edits fail as stale for no reason.
+func (m *PostgresDBRepo) RecordFailedLogin(ctx context.Context, id int) (int, error) {
+	defer m.observe("RecordFailedLogin", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	// delete existing user image just in case
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	var failed int
+	stmt := `update users set failed_logins = failed_logins + 1 where id = $1 returning failed_logins`
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&failed)
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
-	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	return failed, nil
+}
 
-	err = m.DB.QueryRowContext(ctx, stmt,
-		i.UserID,
-		i.FileName,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+// LockUser stops the user from logging in until the given time
+func (m *PostgresDBRepo) LockUser(ctx context.Context, id int, until time.Time) error {
+	defer m.observe("LockUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
 
+	stmt := `update users set locked_until = $1 where id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, until, id)
 	if err != nil {
-		return 0, err
+		return dbError(err)
 	}
 
-	return newID, nil
+	return nil
+}
+
+// UnlockUser clears the user's failed logins and any lock on the account
+func (m *PostgresDBRepo) UnlockUser(ctx context.Context, id int) error {
+	defer m.observe("UnlockUser", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set failed_logins = 0, locked_until = null where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return nil
 }
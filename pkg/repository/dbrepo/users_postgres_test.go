@@ -3,13 +3,17 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/migrations"
 	"webapp/pkg/repository"
 
 	"github.com/ory/dockertest/v3"
@@ -102,17 +106,12 @@ func TestMain(m *testing.M) {
 }
 
 func createTables() error {
-	tableSQL, err := os.ReadFile("./testdata/users.sql")
+	_, err := migrations.Run(context.Background(), testDB)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	_, err = testDB.Exec(string(tableSQL))
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -123,6 +122,20 @@ func Test_pingDB(t *testing.T) {
 	}
 }
 
+func Test_PostgresDBRepo_Observe(t *testing.T) {
+	var observed []string
+	repo := &PostgresDBRepo{DB: testDB, Observe: func(method string, d time.Duration) {
+		observed = append(observed, method)
+	}}
+
+	_, _ = repo.GetUser(context.Background(), 1)
+	_, _ = repo.GetUserByEmail(context.Background(), "nobody@example.com")
+
+	if len(observed) != 2 || observed[0] != "GetUser" || observed[1] != "GetUserByEmail" {
+		t.Errorf("expected both calls to be observed, got %v", observed)
+	}
+}
+
 func Test_PostgresDBRepo_InsertUser(t *testing.T) {
 	testUser := data.User{
 		FirstName: "Admin",
@@ -133,7 +146,7 @@ func Test_PostgresDBRepo_InsertUser(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	id, err := testRepo.InsertUser(testUser)
+	id, err := testRepo.InsertUser(context.Background(), testUser)
 
 	if err != nil {
 		t.Errorf("Insert user returned an error: %s", err)
@@ -145,7 +158,7 @@ func Test_PostgresDBRepo_InsertUser(t *testing.T) {
 }
 
 func Test_PostgresDBRepo_AllUsers(t *testing.T) {
-	users, err := testRepo.AllUsers()
+	users, _, err := testRepo.AllUsers(context.Background(), repository.UserQuery{})
 
 	if err != nil {
 		t.Errorf("All users returned an error: %s", err)
@@ -165,9 +178,9 @@ func Test_PostgresDBRepo_AllUsers(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	_, _ = testRepo.InsertUser(testUser)
+	_, _ = testRepo.InsertUser(context.Background(), testUser)
 
-	users, err = testRepo.AllUsers()
+	users, _, err = testRepo.AllUsers(context.Background(), repository.UserQuery{})
 	if err != nil {
 		t.Errorf("All users returned an error: %s", err)
 	}
@@ -178,8 +191,55 @@ func Test_PostgresDBRepo_AllUsers(t *testing.T) {
 	}
 }
 
+func Test_PostgresDBRepo_AllUsersQuery(t *testing.T) {
+	notAdmin := 0
+	isAdmin := 1
+
+	var tests = []struct {
+		name          string
+		query         repository.UserQuery
+		expectedTotal int
+		expectedIDs   []int
+	}{
+		{"everyone", repository.UserQuery{}, 2, []int{2, 1}},
+		{"sorted by id", repository.UserQuery{Sort: "id"}, 2, []int{1, 2}},
+		{"sorted by id desc", repository.UserQuery{Sort: "id", Desc: true}, 2, []int{2, 1}},
+		{"first page", repository.UserQuery{Sort: "id", Page: 1, PageSize: 1}, 2, []int{1}},
+		{"second page", repository.UserQuery{Sort: "id", Page: 2, PageSize: 1}, 2, []int{2}},
+		{"past the end", repository.UserQuery{Sort: "id", Page: 3, PageSize: 1}, 2, nil},
+		{"email", repository.UserQuery{Email: "SMITH@example.com"}, 1, []int{2}},
+		{"name prefix", repository.UserQuery{NamePrefix: "ja"}, 1, []int{2}},
+		{"name prefix is literal", repository.UserQuery{NamePrefix: "%"}, 0, nil},
+		{"admins", repository.UserQuery{IsAdmin: &isAdmin}, 2, []int{2, 1}},
+		{"not admins", repository.UserQuery{IsAdmin: &notAdmin}, 0, nil},
+		{"created after", repository.UserQuery{CreatedAfter: time.Now().Add(-time.Hour)}, 2, []int{2, 1}},
+		{"created before", repository.UserQuery{CreatedBefore: time.Now().Add(-time.Hour)}, 0, nil},
+	}
+
+	for _, e := range tests {
+		users, total, err := testRepo.AllUsers(context.Background(), e.query)
+		if err != nil {
+			t.Errorf("%s: returned an error: %s", e.name, err)
+			continue
+		}
+
+		if total != e.expectedTotal {
+			t.Errorf("%s: expected total %d, but got %d", e.name, e.expectedTotal, total)
+		}
+
+		var ids []int
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(e.expectedIDs) {
+			t.Errorf("%s: expected ids %v, but got %v", e.name, e.expectedIDs, ids)
+		}
+	}
+}
+
 func Test_PostgresDBRepo_GetUser(t *testing.T) {
-	user, err := testRepo.GetUser(1)
+	user, err := testRepo.GetUser(context.Background(), 1)
 	if err != nil {
 		t.Errorf("Get user by id returned an error: %s", err)
 	}
@@ -188,50 +248,243 @@ func Test_PostgresDBRepo_GetUser(t *testing.T) {
 	}
 
 	// non existent id
-	_, err = testRepo.GetUser(3)
+	_, err = testRepo.GetUser(context.Background(), 3)
 	if err == nil {
 		t.Error("no error reported when getting non existent user by id")
 	}
 }
 
 func Test_PostgresDBRepo_GetUserByEmail(t *testing.T) {
-	user, err := testRepo.GetUserByEmail("smith@example.com")
+	user, err := testRepo.GetUserByEmail(context.Background(), "smith@example.com")
 	if err != nil {
 		t.Errorf("Get user by id returned an error: %s", err)
 	}
 	if user.ID != 2 {
 		t.Errorf("wrong id returned by GetUser; expected 2, returned %d", user.ID)
 	}
+	// the address in other capitals is the same account
+	user, err = testRepo.GetUserByEmail(context.Background(), "Smith@EXAMPLE.com")
+	if err != nil || user.ID != 2 {
+		t.Errorf("expected the lookup to ignore case, got %v", err)
+	}
 	// non existent email
-	_, err = testRepo.GetUserByEmail("dsfaf")
+	_, err = testRepo.GetUserByEmail(context.Background(), "dsfaf")
 	if err == nil {
 		t.Error("no error reported when getting non existent user by email")
 	}
 }
 
+func Test_PostgresDBRepo_VerifyUser(t *testing.T) {
+	user, _ := testRepo.GetUser(context.Background(), 2)
+	if user.Verified {
+		t.Error("new user should start unverified")
+	}
+
+	err := testRepo.VerifyUser(context.Background(), 2)
+	if err != nil {
+		t.Errorf("error verifying user %d: %s", 2, err)
+	}
+
+	user, _ = testRepo.GetUser(context.Background(), 2)
+	if !user.Verified {
+		t.Error("user not marked verified in database")
+	}
+}
+
+func Test_PostgresDBRepo_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		failed, err := testRepo.RecordFailedLogin(ctx, 1)
+		if err != nil {
+			t.Fatalf("error recording failed login: %s", err)
+		}
+		if failed != i {
+			t.Errorf("expected %d failed logins, got %d", i, failed)
+		}
+	}
+
+	err := testRepo.LockUser(ctx, 1, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("error locking user: %s", err)
+	}
+
+	user, _ := testRepo.GetUserByEmail(ctx, "admin@example.com")
+	if !user.IsLocked() || user.FailedLogins != 2 {
+		t.Errorf("expected a locked user with 2 failed logins, got locked until %v with %d", user.LockedUntil, user.FailedLogins)
+	}
+
+	err = testRepo.UnlockUser(ctx, 1)
+	if err != nil {
+		t.Errorf("error unlocking user: %s", err)
+	}
+
+	user, _ = testRepo.GetUser(ctx, 1)
+	if user.LockedUntil != nil || user.FailedLogins != 0 {
+		t.Errorf("expected the lock and failed logins to be cleared, got %v and %d", user.LockedUntil, user.FailedLogins)
+	}
+}
+
+func Test_PostgresDBRepo_errors(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := testRepo.GetUser(ctx, 99999)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting a user that does not exist, got %v", err)
+	}
+
+	err = testRepo.DeleteUser(ctx, 99999, 0)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a user that does not exist, got %v", err)
+	}
+
+	first, err := testRepo.InsertUser(ctx, data.User{FirstName: "Dup", LastName: "One", Email: "dup@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unique index ignores case
+	_, err = testRepo.InsertUser(ctx, data.User{FirstName: "Dup", LastName: "Two", Email: "DUP@example.com", Password: "secret"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail inserting a taken address, got %v", err)
+	}
+
+	err = testRepo.UpdateUser(ctx, data.User{ID: first, FirstName: "Dup", LastName: "One", Email: "admin@example.com"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail updating to a taken address, got %v", err)
+	}
+
+	_, err = testRepo.InsertUserImage(ctx, data.UserImage{UserID: 99999, FileName: "orphan.png"})
+	if !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("expected ErrInvalid inserting an image for a user that does not exist, got %v", err)
+	}
+
+	_ = testRepo.DeleteUser(ctx, first, 0)
+}
+
 func Test_PostgresDBRepo_UpdateUser(t *testing.T) {
-	user, _ := testRepo.GetUser(2)
+	user, _ := testRepo.GetUser(context.Background(), 2)
 	user.FirstName = "Jane"
 	user.Email = "jane@example.com"
 
-	err := testRepo.UpdateUser(*user)
+	err := testRepo.UpdateUser(context.Background(), *user)
 	if err != nil {
 		t.Errorf("error updating user %d: %s", 2, err)
 	}
 
-	user, _ = testRepo.GetUser(2)
+	user, _ = testRepo.GetUser(context.Background(), 2)
 	if user.FirstName != "Jane" || user.Email != "jane@example.com" {
 		t.Errorf("Record not updated in database. Expected firstname Jane, email jane@example.com, but got %s, and %s", user.FirstName, user.Email)
 	}
 }
 
+func Test_PostgresDBRepo_PatchUser(t *testing.T) {
+	ctx := context.Background()
+	id, err := testRepo.InsertUser(ctx, data.User{FirstName: "Pat", LastName: "Ch", Email: "pat@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = testRepo.DeleteUser(ctx, id, 0) }()
+
+	lastName := "Patched"
+	isAdmin := 1
+	err = testRepo.PatchUser(ctx, id, repository.UserUpdate{LastName: &lastName, IsAdmin: &isAdmin})
+	if err != nil {
+		t.Fatalf("error patching user %d: %s", id, err)
+	}
+
+	user, _ := testRepo.GetUser(ctx, id)
+	if user.FirstName != "Pat" || user.LastName != "Patched" || user.Email != "pat@example.com" || user.IsAdmin != 1 {
+		t.Errorf("expected only the last name and admin flag to change, got %s %s <%s> admin %d", user.FirstName, user.LastName, user.Email, user.IsAdmin)
+	}
+
+	// an empty update still tells us whether the user exists
+	err = testRepo.PatchUser(ctx, id, repository.UserUpdate{})
+	if err != nil {
+		t.Errorf("expected an empty patch to succeed, got %v", err)
+	}
+
+	err = testRepo.PatchUser(ctx, 99999, repository.UserUpdate{LastName: &lastName})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound patching a user that does not exist, got %v", err)
+	}
+
+	email := "ADMIN@example.com"
+	err = testRepo.PatchUser(ctx, id, repository.UserUpdate{Email: &email})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail patching to a taken address, got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_versions(t *testing.T) {
+	ctx := context.Background()
+	id, err := testRepo.InsertUser(ctx, data.User{FirstName: "Ver", LastName: "Sion", Email: "version@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := testRepo.GetUser(ctx, id)
+	if user.Version != 1 {
+		t.Fatalf("expected a new user to be at version 1, got %d", user.Version)
+	}
+
+	user.LastName = "Sioned"
+	err = testRepo.UpdateUser(ctx, *user)
+	if err != nil {
+		t.Fatalf("error updating the current version: %s", err)
+	}
+
+	// user still holds version 1, which is now out of date
+	err = testRepo.UpdateUser(ctx, *user)
+	if !errors.Is(err, repository.ErrStale) {
+		t.Errorf("expected ErrStale updating an old version, got %v", err)
+	}
+
+	lastName := "Patched"
+	err = testRepo.PatchUser(ctx, id, repository.UserUpdate{LastName: &lastName, Version: 1})
+	if !errors.Is(err, repository.ErrStale) {
+		t.Errorf("expected ErrStale patching an old version, got %v", err)
+	}
+
+	err = testRepo.DeleteUser(ctx, id, 1)
+	if !errors.Is(err, repository.ErrStale) {
+		t.Errorf("expected ErrStale deleting an old version, got %v", err)
+	}
+
+	err = testRepo.DeleteUser(ctx, 99999, 1)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a user that does not exist, got %v", err)
+	}
+
+	// a new password moves the version on, not just edits
+	_ = testRepo.ResetPassword(ctx, id, "new secret")
+	user, _ = testRepo.GetUser(ctx, id)
+	if user.Version != 3 || user.LastName != "Sioned" {
+		t.Errorf("expected version 3 of the updated user, got version %d of %s", user.Version, user.LastName)
+	}
+
+	// but failed logins, and the locks they lead to, don't
+	_, _ = testRepo.RecordFailedLogin(ctx, id)
+	_ = testRepo.LockUser(ctx, id, time.Now().Add(time.Minute))
+	_ = testRepo.UnlockUser(ctx, id)
+	user, _ = testRepo.GetUser(ctx, id)
+	if user.Version != 3 {
+		t.Errorf("expected the login counters to leave the user at version 3, got %d", user.Version)
+	}
+
+	err = testRepo.DeleteUser(ctx, id, user.Version)
+	if err != nil {
+		t.Errorf("error deleting the current version: %s", err)
+	}
+}
+
 func Test_PostgresDBRepo_DeleteUser(t *testing.T) {
-	err := testRepo.DeleteUser(2)
+	err := testRepo.DeleteUser(context.Background(), 2, 0)
 	if err != nil {
 		t.Errorf("error deleting user %d: %s", 2, err)
 	}
 
-	_, err = testRepo.GetUser(2)
+	_, err = testRepo.GetUser(context.Background(), 2)
 
 	if err == nil {
 		t.Errorf("Error: expected deleted user with id %d, but was not deleted", 2)
@@ -239,11 +492,11 @@ func Test_PostgresDBRepo_DeleteUser(t *testing.T) {
 }
 
 func Test_PostgresDBRepo_ResetPassword(t *testing.T) {
-	err := testRepo.ResetPassword(1, "password")
+	err := testRepo.ResetPassword(context.Background(), 1, "password")
 	if err != nil {
 		t.Error("Error resetting password", err)
 	}
-	user, _ := testRepo.GetUser(1)
+	user, _ := testRepo.GetUser(context.Background(), 1)
 	matches, err := user.PasswordMatches("password")
 	if err != nil {
 		t.Error("Error resetting password", err)
@@ -255,14 +508,18 @@ func Test_PostgresDBRepo_ResetPassword(t *testing.T) {
 
 func Test_PostgresDBRepo_InsertUserImage(t *testing.T) {
 	var userImage = data.UserImage{
-		ID:        1,
-		UserID:    1,
-		FileName:  "test.jpg",
+		ID:       1,
+		UserID:   1,
+		FileName: "test.jpg",
+		Variants: []data.ImageVariant{
+			{Variant: "thumbnail", FileName: "thumb.jpg", MIMEType: "image/jpeg", Width: 150, Height: 150, Size: 10},
+			{Variant: "original", FileName: "test.jpg", MIMEType: "image/jpeg", Width: 800, Height: 600, Size: 100},
+		},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	newID, err := testRepo.InsertUserImage(userImage)
+	newID, err := testRepo.InsertUserImage(context.Background(), userImage)
 	if err != nil {
 		t.Error("Inserting user image failed:", err)
 	}
@@ -271,9 +528,170 @@ func Test_PostgresDBRepo_InsertUserImage(t *testing.T) {
 		t.Error("Got wrong id for image, expected 1, but got", newID)
 	}
 
+	user, err := testRepo.GetUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if thumb := user.ProfilePic.Variant("thumbnail"); thumb == nil || thumb.Width != 150 || thumb.FileName != "thumb.jpg" {
+		t.Errorf("Got wrong thumbnail for the profile pic: %+v", user.ProfilePic.Variants)
+	}
+
+	usage, err := testRepo.UserImageUsage(context.Background(), 1)
+	if err != nil || usage != 110 {
+		t.Errorf("expected 110 bytes used, got %d (%v)", usage, err)
+	}
+
 	userImage.UserID = 100 // for a user that doesn't exist
-	_, err = testRepo.InsertUserImage(userImage)
+	_, err = testRepo.InsertUserImage(context.Background(), userImage)
 	if err == nil {
 		t.Error("Expected an error while inserting a user image with non existent user id, found no error")
 	}
 }
+
+func Test_PostgresDBRepo_UserMFA(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := testRepo.GetUserMFA(ctx, 1)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a user without mfa, got %v", err)
+	}
+
+	err = testRepo.SaveUserMFA(ctx, data.UserMFA{UserID: 1, Secret: "sealed"})
+	if err != nil {
+		t.Fatal("Saving mfa failed:", err)
+	}
+
+	err = testRepo.SaveUserMFA(ctx, data.UserMFA{UserID: 1, Secret: "sealed", Enabled: true, LastUsedStep: 10})
+	if err != nil {
+		t.Fatal("Updating mfa failed:", err)
+	}
+
+	stored, err := testRepo.GetUserMFA(ctx, 1)
+	if err != nil || !stored.Enabled || stored.LastUsedStep != 10 {
+		t.Errorf("Got wrong mfa settings back: %+v, %v", stored, err)
+	}
+
+	// a time step can only be used once, and never an older one
+	if ok, _ := testRepo.UseMFAStep(ctx, 1, 11); !ok {
+		t.Error("expected a new step to be accepted")
+	}
+	if ok, _ := testRepo.UseMFAStep(ctx, 1, 11); ok {
+		t.Error("expected a used step to be refused")
+	}
+	if ok, _ := testRepo.UseMFAStep(ctx, 1, 9); ok {
+		t.Error("expected an older step to be refused")
+	}
+
+	err = testRepo.ReplaceRecoveryCodes(ctx, 1, []string{data.HashToken("a"), data.HashToken("b")})
+	if err != nil {
+		t.Fatal("Replacing recovery codes failed:", err)
+	}
+
+	if ok, _ := testRepo.ConsumeRecoveryCode(ctx, 1, data.HashToken("a")); !ok {
+		t.Error("expected a recovery code to be accepted")
+	}
+	if ok, _ := testRepo.ConsumeRecoveryCode(ctx, 1, data.HashToken("a")); ok {
+		t.Error("expected a used recovery code to be refused")
+	}
+
+	err = testRepo.DeleteUserMFA(ctx, 1)
+	if err != nil {
+		t.Fatal("Deleting mfa failed:", err)
+	}
+
+	if ok, _ := testRepo.ConsumeRecoveryCode(ctx, 1, data.HashToken("b")); ok {
+		t.Error("expected recovery codes to go with the mfa settings")
+	}
+}
+
+func Test_PostgresDBRepo_RefreshTokens(t *testing.T) {
+	token := data.RefreshToken{
+		UserID:    1,
+		TokenHash: data.HashToken("first"),
+		FamilyID:  "family",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	id, err := testRepo.InsertRefreshToken(context.Background(), token)
+	if err != nil {
+		t.Fatal("Inserting refresh token failed:", err)
+	}
+
+	token.TokenHash = data.HashToken("second")
+	_, err = testRepo.InsertRefreshToken(context.Background(), token)
+	if err != nil {
+		t.Fatal("Inserting refresh token failed:", err)
+	}
+
+	stored, err := testRepo.GetRefreshToken(context.Background(), data.HashToken("first"))
+	if err != nil {
+		t.Fatal("Getting refresh token failed:", err)
+	}
+	if stored.ID != id || stored.Revoked {
+		t.Errorf("Got wrong refresh token back: %+v", stored)
+	}
+
+	// a token can only be revoked once
+	ok, err := testRepo.RevokeRefreshToken(context.Background(), id)
+	if err != nil || !ok {
+		t.Errorf("expected first revoke to succeed, got %v, %v", ok, err)
+	}
+	ok, err = testRepo.RevokeRefreshToken(context.Background(), id)
+	if err != nil || ok {
+		t.Errorf("expected second revoke to report false, got %v, %v", ok, err)
+	}
+
+	err = testRepo.RevokeRefreshTokenFamily(context.Background(), "family")
+	if err != nil {
+		t.Error("Revoking refresh token family failed:", err)
+	}
+
+	stored, _ = testRepo.GetRefreshToken(context.Background(), data.HashToken("second"))
+	if !stored.Revoked {
+		t.Error("expected every token in the family to be revoked")
+	}
+
+	_, err = testRepo.GetRefreshToken(context.Background(), data.HashToken("unknown"))
+	if err == nil {
+		t.Error("expected an error getting a refresh token that does not exist")
+	}
+}
+
+func Test_PostgresDBRepo_contextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := testRepo.GetUser(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from a cancelled query, but got %v", err)
+	}
+}
+
+func Test_migration_caseDuplicateEmails(t *testing.T) {
+	ctx := context.Background()
+	migrator, err := migrations.New(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// back to before the unique index, where addresses could differ only in case
+	if _, err := migrator.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := testRepo.InsertUser(ctx, data.User{FirstName: "Case", LastName: "One", Email: "Case@example.com", Password: "secret"})
+	second, _ := testRepo.InsertUser(ctx, data.User{FirstName: "Case", LastName: "Two", Email: "case@example.com", Password: "secret"})
+
+	_, err = migrator.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("case@example.com (ids %d, %d)", first, second)) {
+		t.Fatalf("expected the migration to stop and list the clashing accounts, got %v", err)
+	}
+
+	// the manual step, then it goes through
+	if _, err := testDB.ExecContext(ctx, `delete from users where id = $1`, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("expected the migration to apply once the duplicate is gone, got %v", err)
+	}
+}
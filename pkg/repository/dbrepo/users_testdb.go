@@ -1,26 +1,130 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"strings"
+	"sync"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-type TestDBRepo struct{}
+// TestDBRepo is an in-memory stand in for the database, used by the handler tests. Every
+// method fails with ctx.Err() once the context is done, like a real query would.
+type TestDBRepo struct {
+	mu              sync.Mutex
+	users           []*data.User // users added by InsertUser, on top of the admin fixture
+	lastUserID      int
+	logins          map[int]*loginState
+	mfa             map[int]*data.UserMFA
+	recoveryCodes   map[int][]string
+	refreshTokens   []*data.RefreshToken
+	userTokens      []*data.UserToken
+	lastUserTokenID int
+	images          []*data.UserImage // every uploaded image, oldest first
+	lastImageID     int
+	sessions        []*data.UserSession
+}
+
+// loginState holds the failed logins and lock for one user, so they apply to the fixture too
+type loginState struct {
+	failed      int
+	lockedUntil *time.Time
+}
+
+// applyLoginState copies the stored login state onto u. The caller must hold m.mu.
+func (m *TestDBRepo) applyLoginState(u *data.User) {
+	if state, ok := m.logins[u.ID]; ok {
+		u.FailedLogins = state.failed
+		u.LockedUntil = state.lockedUntil
+	}
+}
 
 func (m *TestDBRepo) Connection() *sql.DB {
 	return nil
 }
 
-func (m *TestDBRepo) AllUsers() ([]*data.User, error) {
+// AllUsers applies the query filters and paging to the admin fixture and the inserted users
+func (m *TestDBRepo) AllUsers(ctx context.Context, q repository.UserQuery) ([]*data.User, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	admin := &data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+		IsAdmin:   1,
+		Version:   1,
+		CreatedAt: time.Date(2022, 8, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all := []*data.User{admin}
+	for _, u := range m.users {
+		c := *u
+		m.applyLoginState(&c)
+		all = append(all, &c)
+	}
 
 	var users []*data.User
 
-	return users, nil
+	for _, u := range all {
+		switch {
+		case q.Email != "" && !strings.EqualFold(q.Email, u.Email):
+		case q.NamePrefix != "" && !hasPrefixFold(u.FirstName, q.NamePrefix) && !hasPrefixFold(u.LastName, q.NamePrefix):
+		case q.IsAdmin != nil && *q.IsAdmin != u.IsAdmin:
+		case !q.CreatedAfter.IsZero() && u.CreatedAt.Before(q.CreatedAfter):
+		case !q.CreatedBefore.IsZero() && !u.CreatedAt.Before(q.CreatedBefore):
+		default:
+			users = append(users, u)
+		}
+	}
+
+	total := len(users)
+
+	if q.PageSize > 0 {
+		if q.Offset() >= len(users) {
+			return nil, total, nil
+		}
+		users = users[q.Offset():]
+		if len(users) > q.PageSize {
+			users = users[:q.PageSize]
+		}
+	}
+
+	return users, total, nil
 }
 
-func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
+// emailTaken reports whether a user other than exceptID has the email address, ignoring case
+// like the unique index does. The caller must hold m.mu.
+func (m *TestDBRepo) emailTaken(email string, exceptID int) bool {
+	if strings.EqualFold(email, "admin@example.com") && exceptID != 1 {
+		return true
+	}
+	for _, u := range m.users {
+		if u.ID != exceptID && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func (m *TestDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var user = data.User{}
 	if id == 1 {
 		user = data.User{
@@ -28,58 +132,326 @@ func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
 			FirstName: "Admin",
 			LastName:  "User",
 			Email:     "admin@example.com",
+			IsAdmin:   1,
+			Verified:  true,
+			Version:   1,
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ID == id {
+			user = *u
 		}
+	}
+
+	if user.ID != 0 {
+		m.applyLoginState(&user)
+		m.applyProfilePic(&user)
 		return &user, nil
 	}
 
-	return nil, errors.New("user not found")
+	return nil, repository.ErrNotFound
 }
 
-func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	if email == "admin@example.com" {
+// GetUserByEmail returns one user by email address, however it is capitalised
+func (m *TestDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if strings.EqualFold(email, "admin@example.com") {
 
 		user := data.User{
 			ID:        1,
 			FirstName: "Admin",
 			LastName:  "User",
-			Email:     email,
+			Email:     "admin@example.com",
 			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
 			IsAdmin:   1,
+			Verified:  true,
+			Version:   1,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
+		m.applyLoginState(&user)
+		m.applyProfilePic(&user)
 
 		return &user, nil
 	}
-	return nil, errors.New("not found")
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			user := *u
+			m.applyLoginState(&user)
+			m.applyProfilePic(&user)
+			return &user, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
 }
 
 // UpdateUser updates one user in the database
-func (m *TestDBRepo) UpdateUser(u data.User) error {
+func (m *TestDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the admin fixture never changes, so stays at version 1
 	if u.ID == 1 {
+		if u.Version > 1 {
+			return repository.ErrStale
+		}
+		if m.emailTaken(u.Email, u.ID) {
+			return repository.ErrDuplicateEmail
+		}
 		return nil
 	}
 
-	return errors.New("user not found")
+	for _, stored := range m.users {
+		if stored.ID == u.ID {
+			if u.Version != 0 && u.Version != stored.Version {
+				return repository.ErrStale
+			}
+			if m.emailTaken(u.Email, u.ID) {
+				return repository.ErrDuplicateEmail
+			}
+			stored.Email = u.Email
+			stored.FirstName = u.FirstName
+			stored.LastName = u.LastName
+			stored.IsAdmin = u.IsAdmin
+			stored.UpdatedAt = time.Now()
+			stored.Version++
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *TestDBRepo) DeleteUser(id int) error {
+// PatchUser changes only the fields of a user that are set in u
+func (m *TestDBRepo) PatchUser(ctx context.Context, id int, u repository.UserUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	return nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == 1 {
+		if u.Version > 1 {
+			return repository.ErrStale
+		}
+		if u.Email != nil && m.emailTaken(*u.Email, id) {
+			return repository.ErrDuplicateEmail
+		}
+		return nil
+	}
+
+	for _, stored := range m.users {
+		if stored.ID != id {
+			continue
+		}
+		if u.Version != 0 && u.Version != stored.Version {
+			return repository.ErrStale
+		}
+		if u.Email != nil {
+			if m.emailTaken(*u.Email, id) {
+				return repository.ErrDuplicateEmail
+			}
+			stored.Email = *u.Email
+		}
+		if u.FirstName != nil {
+			stored.FirstName = *u.FirstName
+		}
+		if u.LastName != nil {
+			stored.LastName = *u.LastName
+		}
+		if u.IsAdmin != nil {
+			stored.IsAdmin = *u.IsAdmin
+		}
+		stored.UpdatedAt = time.Now()
+		stored.Version++
+		return nil
+	}
+
+	return repository.ErrNotFound
+}
+
+// DeleteUser deletes one user from the database, by id, and when version isn't 0 only that
+// version of them
+func (m *TestDBRepo) DeleteUser(ctx context.Context, id, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == 1 {
+		if version > 1 {
+			return repository.ErrStale
+		}
+		return nil
+	}
+
+	for k, u := range m.users {
+		if u.ID == id {
+			if version != 0 && version != u.Version {
+				return repository.ErrStale
+			}
+			m.users = append(m.users[:k], m.users[k+1:]...)
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *TestDBRepo) InsertUser(user data.User) (int, error) {
-	return 1, nil
+func (m *TestDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// the lowest cost keeps the tests fast, the hash still works with PasswordMatches
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.MinCost)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	// ids start after the admin fixture, and aren't reused after a delete
+	if m.lastUserID == 0 {
+		m.lastUserID = 1
+	}
+	m.lastUserID++
+	user.ID = m.lastUserID
+	user.Password = string(hashedPassword)
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	user.Version = 1
+	m.users = append(m.users, &user)
+
+	return user.ID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *TestDBRepo) ResetPassword(id int, password string) error {
+func (m *TestDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == 1 {
+		return nil
+	}
+
+	for _, u := range m.users {
+		if u.ID == id {
+			u.Password = string(hashedPassword)
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+// VerifyUser marks the user's email address as confirmed
+func (m *TestDBRepo) VerifyUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == 1 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ID == id {
+			u.Verified = true
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+// RecordFailedLogin adds one to the user's count of failed logins, and returns the new count
+func (m *TestDBRepo) RecordFailedLogin(ctx context.Context, id int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.loginStateFor(id)
+	state.failed++
+
+	return state.failed, nil
+}
+
+// LockUser stops the user from logging in until the given time
+func (m *TestDBRepo) LockUser(ctx context.Context, id int, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loginStateFor(id).lockedUntil = &until
+
 	return nil
 }
 
-// InsertUserImage inserts a user profile image into the database.
-func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	return 2, nil
+// UnlockUser clears the user's failed logins and any lock on the account
+func (m *TestDBRepo) UnlockUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.logins, id)
+
+	return nil
+}
+
+// loginStateFor returns the login state for a user, creating it if needed. The caller must hold m.mu.
+func (m *TestDBRepo) loginStateFor(id int) *loginState {
+	if m.logins == nil {
+		m.logins = make(map[int]*loginState)
+	}
+	if m.logins[id] == nil {
+		m.logins[id] = &loginState{}
+	}
+	return m.logins[id]
 }
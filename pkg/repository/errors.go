@@ -0,0 +1,22 @@
+package repository
+
+import "errors"
+
+// Errors the repositories return for the failures callers act on. Implementations may wrap
+// them, along with the database's own error, so check with errors.Is. Their messages are
+// safe to show to users; the wrapped database error isn't.
+var (
+	// ErrNotFound is returned when the record asked for doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateEmail is returned when another user already has the email address
+	ErrDuplicateEmail = errors.New("an account with that email address already exists")
+	// ErrConflict is returned when a write clashes with a record that already exists, or
+	// with a concurrent change
+	ErrConflict = errors.New("conflicts with the current state of the record")
+	// ErrStale is returned when a write is limited to one version of a record, and the
+	// record has been changed since that version was read
+	ErrStale = errors.New("the record has changed since it was read")
+	// ErrInvalid is returned when the database refuses a value, like a reference to a record
+	// that doesn't exist or text that is too long
+	ErrInvalid = errors.New("invalid data")
+)
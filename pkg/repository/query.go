@@ -0,0 +1,39 @@
+package repository
+
+import "time"
+
+// UserSortFields are the columns users can be sorted by
+var UserSortFields = []string{"id", "email", "first_name", "last_name", "created_at"}
+
+// UserQuery describes which users AllUsers should return. Zero values mean "no filter",
+// and a PageSize of 0 returns every matching user.
+type UserQuery struct {
+	Page     int
+	PageSize int
+	Sort     string
+	Desc     bool
+
+	Email         string
+	NamePrefix    string
+	IsAdmin       *int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// Offset returns the number of rows to skip for the requested page
+func (q UserQuery) Offset() int {
+	if q.Page <= 1 || q.PageSize <= 0 {
+		return 0
+	}
+	return (q.Page - 1) * q.PageSize
+}
+
+// IsUserSortField reports whether users can be sorted by field
+func IsUserSortField(field string) bool {
+	for _, f := range UserSortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
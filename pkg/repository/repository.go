@@ -1,18 +1,51 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 	"webapp/pkg/data"
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	InsertUser(user data.User) (int, error)
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
+	AllUsers(ctx context.Context, q UserQuery) ([]*data.User, int, error)
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	UpdateUser(ctx context.Context, u data.User) error
+	PatchUser(ctx context.Context, id int, u UserUpdate) error
+	DeleteUser(ctx context.Context, id, version int) error
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+	VerifyUser(ctx context.Context, id int) error
+	RecordFailedLogin(ctx context.Context, id int) (int, error)
+	LockUser(ctx context.Context, id int, until time.Time) error
+	UnlockUser(ctx context.Context, id int) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	GetUserImages(ctx context.Context, userID int) ([]*data.UserImage, error)
+	GetUserImage(ctx context.Context, id int) (*data.UserImage, error)
+	SetActiveUserImage(ctx context.Context, userID, id int) error
+	DeleteUserImage(ctx context.Context, id int) error
+	UserImageUsage(ctx context.Context, userID int) (int64, error)
+	InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*data.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id int) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	InsertUserSession(ctx context.Context, s data.UserSession) (int, error)
+	GetUserSession(ctx context.Context, id int) (*data.UserSession, error)
+	GetUserSessionByToken(ctx context.Context, kind, tokenID string) (*data.UserSession, error)
+	GetUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error)
+	TouchUserSession(ctx context.Context, kind, tokenID, ip string, expiresAt time.Time) error
+	RevokeUserSession(ctx context.Context, id int) error
+	RevokeUserSessions(ctx context.Context, userID, exceptID int) error
+	InsertUserToken(ctx context.Context, t data.UserToken) (int, error)
+	ConsumeUserToken(ctx context.Context, scope, tokenHash string) (*data.UserToken, error)
+	DeleteUserTokens(ctx context.Context, userID int, scope string) error
+	SaveUserMFA(ctx context.Context, m data.UserMFA) error
+	GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error)
+	UseMFAStep(ctx context.Context, userID int, step int64) (bool, error)
+	DeleteUserMFA(ctx context.Context, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
 }
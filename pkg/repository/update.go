@@ -0,0 +1,18 @@
+package repository
+
+// UserUpdate holds the fields of a user PatchUser should change. Nil fields are left as they
+// are, so a partial update doesn't blank the fields it leaves out.
+type UserUpdate struct {
+	FirstName *string
+	LastName  *string
+	Email     *string
+	IsAdmin   *int
+
+	// Version, when set, limits the update to that version of the user
+	Version int
+}
+
+// IsEmpty reports whether the update changes none of the user's fields
+func (u UserUpdate) IsEmpty() bool {
+	return u.FirstName == nil && u.LastName == nil && u.Email == nil && u.IsAdmin == nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"flag"
+	"fmt"
+)
+
+// Config picks and sets up a storage backend. The web app and the api share it, so both see
+// the same uploads.
+type Config struct {
+	Backend     string // "local" or "s3"
+	Dir         string
+	BaseURL     string // where the local backend's files are served, for SignedURL
+	Key         string // signs the urls of the local backend
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// RegisterFlags adds the storage flags to fs
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Backend, "storage", "local", "where uploaded images are kept: local or s3")
+	fs.StringVar(&c.Dir, "storage-dir", "./uploads", "directory for the local storage backend, kept out of ./static so uploads are only served through signed urls")
+	fs.StringVar(&c.Key, "storage-key", "change-me-storage-key", "key for signing local storage urls, shared by the web app and the api")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", "", "url of the S3 compatible service, e.g. http://localhost:9000 for MinIO")
+	fs.StringVar(&c.S3Region, "s3-region", "us-east-1", "region of the S3 bucket")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", "", "S3 bucket for uploaded images")
+	fs.StringVar(&c.S3AccessKey, "s3-access-key", "", "S3 access key id")
+	fs.StringVar(&c.S3SecretKey, "s3-secret-key", "", "S3 secret access key")
+}
+
+// Open returns the configured backend
+func Open(c Config) (Storage, error) {
+	switch c.Backend {
+	case "local":
+		return NewLocal(c.Dir, c.BaseURL, []byte(c.Key))
+	case "s3":
+		if c.S3Endpoint == "" || c.S3Bucket == "" {
+			return nil, fmt.Errorf("the s3 storage backend needs -s3-endpoint and -s3-bucket")
+		}
+		return NewS3(c.S3Endpoint, c.S3Region, c.S3Bucket, c.S3AccessKey, c.S3SecretKey), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q, must be local or s3", c.Backend)
+}
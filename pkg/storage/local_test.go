@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal_putGetDelete(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir(), "http://localhost:9000/images", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Put(ctx, "ab/pic.png", strings.NewReader("png bytes"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := l.Get(ctx, "ab/pic.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+
+	if string(content) != "png bytes" || obj.Size != 9 || obj.ContentType != "image/png" {
+		t.Errorf("got back %q, %d bytes of %s", content, obj.Size, obj.ContentType)
+	}
+
+	if err := l.Delete(ctx, "ab/pic.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "ab/pic.png"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after deleting but got %v", err)
+	}
+	if err := l.Delete(ctx, "ab/pic.png"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed but got %v", err)
+	}
+}
+
+func TestLocal_invalidKeys(t *testing.T) {
+	ctx := context.Background()
+	l := &Local{Dir: t.TempDir()}
+
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a/../../b", "a//b", `a\b`, "a/."} {
+		if err := l.Put(ctx, key, strings.NewReader("x"), ""); err != ErrInvalidKey {
+			t.Errorf("%q: expected ErrInvalidKey but got %v", key, err)
+		}
+		if _, err := l.Get(ctx, key); err != ErrInvalidKey {
+			t.Errorf("%q: expected ErrInvalidKey from Get but got %v", key, err)
+		}
+	}
+}
+
+func TestLocal_signedURL(t *testing.T) {
+	ctx := context.Background()
+	l := &Local{Dir: t.TempDir(), BaseURL: "http://localhost:9000/images", Secret: []byte("secret")}
+
+	signed, err := l.SignedURL(ctx, "pic.png", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(signed)
+	if u.Path != "/images/pic.png" {
+		t.Errorf("expected the url to point at /images/pic.png but got %s", u.Path)
+	}
+
+	if err := l.Verify("pic.png", u.Query()); err != nil {
+		t.Errorf("expected the signature to verify but got %v", err)
+	}
+
+	if err := l.Verify("other.png", u.Query()); err != ErrBadSignature {
+		t.Errorf("expected a signature for another key to fail, got %v", err)
+	}
+
+	tampered := u.Query()
+	tampered.Set("expires", "9999999999")
+	if err := l.Verify("pic.png", tampered); err != ErrBadSignature {
+		t.Errorf("expected a changed expiry to fail, got %v", err)
+	}
+
+	expired, _ := l.SignedURL(ctx, "pic.png", -time.Minute)
+	u, _ = url.Parse(expired)
+	if err := l.Verify("pic.png", u.Query()); err != ErrBadSignature {
+		t.Errorf("expected an expired url to fail, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// Guard protects a login form from password guessing. Failures are tracked per client ip and
+// per email address in memory, with exponential backoff, and per account in the database,
+// where too many failures lock the account for a while.
+type Guard struct {
+	DB       repository.DatabaseRepo
+	IPs      *Limiter
+	Accounts *Limiter
+
+	// MaxFailures is how many failed logins in a row lock an account
+	MaxFailures int
+	// LockDuration is how long the first lock lasts. Every failure after that, once the lock
+	// has run out, locks the account again for twice as long, up to MaxLockDuration.
+	LockDuration    time.Duration
+	MaxLockDuration time.Duration
+}
+
+// NewGuard returns a guard with the default limits
+func NewGuard(db repository.DatabaseRepo) *Guard {
+	return &Guard{
+		DB:              db,
+		IPs:             NewLimiter(20, time.Second, 15*time.Minute),
+		Accounts:        NewLimiter(3, time.Second, 15*time.Minute),
+		MaxFailures:     10,
+		LockDuration:    15 * time.Minute,
+		MaxLockDuration: 24 * time.Hour,
+	}
+}
+
+// BlockedError is returned for a login attempt that is refused without checking the password
+type BlockedError struct {
+	Locked     bool // the account is locked, rather than the client being slowed down
+	RetryAfter time.Duration
+}
+
+func (e *BlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account locked after too many failed logins, try again in %s", roundUp(e.RetryAfter))
+	}
+	return fmt.Sprintf("too many failed logins, try again in %s", roundUp(e.RetryAfter))
+}
+
+// StatusCode is the http status to answer a blocked attempt with
+func (e *BlockedError) StatusCode() int {
+	if e.Locked {
+		return http.StatusLocked
+	}
+	return http.StatusTooManyRequests
+}
+
+// RetryAfterHeader is the value for the Retry-After header, in whole seconds
+func (e *BlockedError) RetryAfterHeader() string {
+	return strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// roundUp rounds up to whole seconds for the messages
+func roundUp(d time.Duration) time.Duration {
+	r := d.Truncate(time.Second)
+	if r < d {
+		r += time.Second
+	}
+	return r
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check is called before the password is compared. It returns an error if the client or the
+// email address has to back off, or if the account is locked. user is nil when no account
+// has the email address.
+func (g *Guard) Check(ip, email string, user *data.User) *BlockedError {
+	if user != nil && user.IsLocked() {
+		return &BlockedError{Locked: true, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+
+	wait := g.IPs.Wait(ipKey(ip))
+	if w := g.Accounts.Wait(accountKey(email)); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		return &BlockedError{RetryAfter: wait}
+	}
+
+	return nil
+}
+
+// Failed records a failed login. If it locks the account, it returns the error for that.
+func (g *Guard) Failed(ctx context.Context, ip, email string, user *data.User) (*BlockedError, error) {
+	g.IPs.Failure(ipKey(ip))
+	g.Accounts.Failure(accountKey(email))
+
+	if user == nil {
+		return nil, nil
+	}
+
+	failed, err := g.DB.RecordFailedLogin(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if failed < g.MaxFailures {
+		return nil, nil
+	}
+
+	lockFor := g.MaxLockDuration
+	if shift := failed - g.MaxFailures; shift < 32 && g.LockDuration<<shift < g.MaxLockDuration {
+		lockFor = g.LockDuration << shift
+	}
+
+	err = g.DB.LockUser(ctx, user.ID, time.Now().Add(lockFor))
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockedError{Locked: true, RetryAfter: lockFor}, nil
+}
+
+// Succeeded clears the failures for a login that got the password right
+func (g *Guard) Succeeded(ctx context.Context, ip, email string, user *data.User) error {
+	g.IPs.Reset(ipKey(ip))
+	g.Accounts.Reset(accountKey(email))
+
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	return g.DB.UnlockUser(ctx, user.ID)
+}
+
+// Unlock lets an admin clear a lock and the failed logins on an account straight away
+func (g *Guard) Unlock(ctx context.Context, user *data.User) error {
+	g.Accounts.Reset(accountKey(user.Email))
+
+	return g.DB.UnlockUser(ctx, user.ID)
+}
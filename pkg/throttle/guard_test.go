@@ -0,0 +1,112 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestGuard_locksAccount(t *testing.T) {
+	ctx := context.Background()
+	repo := &dbrepo.TestDBRepo{}
+
+	id, err := repo.InsertUser(ctx, data.User{Email: "lock@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGuard(repo)
+	g.MaxFailures = 3
+	// keep the in-memory backoff out of the way, this test is about the lock
+	g.IPs = NewLimiter(100, time.Second, time.Minute)
+	g.Accounts = NewLimiter(100, time.Second, time.Minute)
+
+	user, _ := repo.GetUser(ctx, id)
+
+	for i := 1; i < g.MaxFailures; i++ {
+		blocked, err := g.Failed(ctx, "1.1.1.1", user.Email, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked != nil {
+			t.Fatalf("failure %d: account locked too early", i)
+		}
+	}
+
+	blocked, _ := g.Failed(ctx, "1.1.1.1", user.Email, user)
+	if blocked == nil || !blocked.Locked || blocked.RetryAfter != g.LockDuration {
+		t.Fatalf("expected the account to be locked for %s, got %+v", g.LockDuration, blocked)
+	}
+
+	if blocked.StatusCode() != http.StatusLocked {
+		t.Errorf("expected status %d for a locked account but got %d", http.StatusLocked, blocked.StatusCode())
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	blocked = g.Check("1.1.1.1", user.Email, user)
+	if blocked == nil || !blocked.Locked {
+		t.Fatal("expected Check to refuse a locked account")
+	}
+
+	// once a lock has run out, the next failure locks the account for twice as long
+	expired := time.Now().Add(-time.Second)
+	_ = repo.LockUser(ctx, id, expired)
+	user, _ = repo.GetUser(ctx, id)
+
+	if g.Check("1.1.1.1", user.Email, user) != nil {
+		t.Fatal("expected an expired lock to let the user try again")
+	}
+
+	blocked, _ = g.Failed(ctx, "1.1.1.1", user.Email, user)
+	if blocked == nil || blocked.RetryAfter != 2*g.LockDuration {
+		t.Errorf("expected a second lock of %s, got %+v", 2*g.LockDuration, blocked)
+	}
+
+	err = g.Unlock(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if user.IsLocked() || user.FailedLogins != 0 {
+		t.Errorf("expected unlocked user with no failures, got locked until %v with %d failures", user.LockedUntil, user.FailedLogins)
+	}
+}
+
+func TestGuard_backoff(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(&dbrepo.TestDBRepo{})
+
+	// unknown accounts back off like real ones, so they can't be told apart
+	for i := 0; i < 3; i++ {
+		_, _ = g.Failed(ctx, "2.2.2.2", "nobody@example.com", nil)
+		if blocked := g.Check("2.2.2.2", "nobody@example.com", nil); blocked != nil {
+			t.Fatalf("failure %d: blocked before the free failures ran out", i+1)
+		}
+	}
+
+	_, _ = g.Failed(ctx, "2.2.2.2", "NOBODY@example.com ", nil)
+
+	blocked := g.Check("3.3.3.3", "nobody@example.com", nil)
+	if blocked == nil || blocked.Locked {
+		t.Fatalf("expected the address to be throttled from any ip, got %+v", blocked)
+	}
+
+	if blocked.StatusCode() != http.StatusTooManyRequests {
+		t.Errorf("expected status %d but got %d", http.StatusTooManyRequests, blocked.StatusCode())
+	}
+
+	if blocked.RetryAfterHeader() != "1" {
+		t.Errorf("expected Retry-After of 1 but got %s", blocked.RetryAfterHeader())
+	}
+
+	user := &data.User{ID: 1, Email: "nobody@example.com"}
+	_ = g.Succeeded(ctx, "2.2.2.2", "nobody@example.com", user)
+
+	if blocked := g.Check("2.2.2.2", "nobody@example.com", nil); blocked != nil {
+		t.Errorf("expected a successful login to clear the backoff, got %+v", blocked)
+	}
+}
@@ -0,0 +1,92 @@
+package throttle
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies are the addresses of the reverse proxies in front of the app. Only they are
+// trusted to say, in X-Forwarded-For, who a request is really from: anyone else could put
+// whatever they liked there, and dodge the ip limits by changing it on every request.
+type Proxies []*net.IPNet
+
+// ParseProxies reads a comma separated list of ip addresses and CIDR ranges, e.g.
+// "10.0.0.0/8, 127.0.0.1". An empty list trusts no proxies.
+func ParseProxies(list string) (Proxies, error) {
+	var p Proxies
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an ip address or CIDR range", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p = append(p, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an ip address or CIDR range", s)
+		}
+		p = append(p, ipNet)
+	}
+
+	return p, nil
+}
+
+// Trusts reports whether ip is one of the proxies
+func (p Proxies) Trusts(ip net.IP) bool {
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made the request. When the request came
+// from a trusted proxy, X-Forwarded-For is read from the right, skipping the trusted proxies
+// the request passed through, and the first other address is the client. Addresses further
+// left were sent by the client, so can't be believed.
+func (p Proxies) ClientIP(r *http.Request) (string, error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return "unknown", err
+	}
+
+	userIP := net.ParseIP(ip)
+	if userIP == nil {
+		return "", fmt.Errorf("UserIP: %q is not in IP:port format", r.RemoteAddr)
+	}
+
+	if !p.Trusts(userIP) {
+		return ip, nil
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// a garbled header, the last good address is the best we have
+			break
+		}
+		ip = hop.String()
+		if !p.Trusts(hop) {
+			break
+		}
+	}
+
+	return ip, nil
+}
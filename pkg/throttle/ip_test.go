@@ -0,0 +1,80 @@
+package throttle
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	var tests = []struct {
+		list      string
+		expectLen int
+		expectErr bool
+	}{
+		{"", 0, false},
+		{"10.0.0.1", 1, false},
+		{"10.0.0.0/8, 127.0.0.1, ::1", 3, false},
+		{"10.0.0.0/33", 0, true},
+		{"proxy.example.com", 0, true},
+	}
+
+	for _, e := range tests {
+		p, err := ParseProxies(e.list)
+		if (err != nil) != e.expectErr {
+			t.Errorf("%q: expected error to be %v, but got %v", e.list, e.expectErr, err)
+		}
+		if len(p) != e.expectLen {
+			t.Errorf("%q: expected %d proxies but got %d", e.list, e.expectLen, len(p))
+		}
+	}
+}
+
+func TestProxies_ClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expectedIP string
+		expectErr  bool
+	}{
+		{"remote address", "1.2.3.4:5678", "", "1.2.3.4", false},
+		{"through a proxy", "10.0.0.1:5678", "5.6.7.8", "5.6.7.8", false},
+		{"through several proxies", "10.0.0.1:5678", "5.6.7.8, 10.0.0.2", "5.6.7.8", false},
+		{"spoofed by the client", "10.0.0.1:5678", "9.9.9.9, 5.6.7.8", "5.6.7.8", false},
+		{"not from a proxy", "1.2.3.4:5678", "5.6.7.8", "1.2.3.4", false},
+		{"only proxies", "10.0.0.1:5678", "10.0.0.2", "10.0.0.2", false},
+		{"garbled header", "10.0.0.1:5678", "5.6.7.8, nonsense", "10.0.0.1", false},
+		{"no port", "1.2.3.4", "", "unknown", true},
+		{"not an ip", "hello:world", "", "", true},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+
+		ip, err := proxies.ClientIP(req)
+		if (err != nil) != e.expectErr {
+			t.Errorf("%s: expected error to be %v, but got %v", e.name, e.expectErr, err)
+		}
+
+		if ip != e.expectedIP {
+			t.Errorf("%s: expected ip %s but got %s", e.name, e.expectedIP, ip)
+		}
+	}
+
+	// with no trusted proxies the header is never read
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5678"
+	req.Header.Set("X-Forwarded-For", "5.6.7.8")
+	if ip, _ := Proxies(nil).ClientIP(req); ip != "10.0.0.1" {
+		t.Errorf("expected the remote address with no trusted proxies, got %s", ip)
+	}
+}
@@ -0,0 +1,113 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter slows down repeated failures for a key, like a client ip or an email address. The
+// first few failures are free, after that the key has to wait before trying again, and the
+// wait doubles with every further failure up to a maximum. A key that stays quiet for the
+// maximum wait is forgotten.
+type Limiter struct {
+	free    int
+	base    time.Duration
+	maximum time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type entry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewLimiter returns a limiter that allows free failures per key, then blocks the key for
+// base, 2*base, 4*base and so on, never longer than maximum
+func NewLimiter(free int, base, maximum time.Duration) *Limiter {
+	return &Limiter{
+		free:    free,
+		base:    base,
+		maximum: maximum,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Wait returns how long the key must wait before its next attempt, or 0 if it may go ahead
+func (l *Limiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+
+	if wait := e.blockedUntil.Sub(l.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Failure records a failed attempt for the key, and returns how long it is now blocked for
+func (l *Limiter) Failure(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok || l.expired(e, now) {
+		e = &entry{}
+		l.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures <= l.free {
+		return 0
+	}
+
+	wait := l.maximum
+	// stop doubling well before the shift could overflow
+	if shift := e.failures - l.free - 1; shift < 32 && l.base<<shift < l.maximum {
+		wait = l.base << shift
+	}
+	e.blockedUntil = now.Add(wait)
+
+	return wait
+}
+
+// Reset forgets the failures for the key, after a success
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+func (l *Limiter) expired(e *entry, now time.Time) bool {
+	return now.After(e.blockedUntil) && now.Sub(e.lastFailure) > l.maximum
+}
+
+// sweep drops forgotten keys, at most once a minute, so the map doesn't grow forever.
+// The caller must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
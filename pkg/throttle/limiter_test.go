@@ -0,0 +1,74 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_backoff(t *testing.T) {
+	now := time.Date(2022, 8, 19, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(2, time.Second, 10*time.Second)
+	l.now = func() time.Time { return now }
+
+	var tests = []struct {
+		name         string
+		expectedWait time.Duration
+	}{
+		{"first free failure", 0},
+		{"second free failure", 0},
+		{"first backoff", time.Second},
+		{"doubled", 2 * time.Second},
+		{"doubled again", 4 * time.Second},
+		{"doubled again", 8 * time.Second},
+		{"capped", 10 * time.Second},
+		{"still capped", 10 * time.Second},
+	}
+
+	for _, e := range tests {
+		wait := l.Failure("key")
+		if wait != e.expectedWait {
+			t.Errorf("%s: expected wait %s but got %s", e.name, e.expectedWait, wait)
+		}
+
+		if l.Wait("key") != e.expectedWait {
+			t.Errorf("%s: expected Wait to report %s but got %s", e.name, e.expectedWait, l.Wait("key"))
+		}
+	}
+
+	if l.Wait("other") != 0 {
+		t.Error("failures for one key should not slow down another")
+	}
+
+	now = now.Add(5 * time.Second)
+	if wait := l.Wait("key"); wait != 5*time.Second {
+		t.Errorf("expected 5s left to wait, but got %s", wait)
+	}
+
+	l.Reset("key")
+	if l.Wait("key") != 0 {
+		t.Error("reset key should not have to wait")
+	}
+}
+
+func TestLimiter_forgets(t *testing.T) {
+	now := time.Date(2022, 8, 19, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(1, time.Second, time.Minute)
+	l.now = func() time.Time { return now }
+
+	l.Failure("key")
+	l.Failure("key")
+
+	// after a quiet spell longer than the maximum wait, the key starts from scratch
+	now = now.Add(2 * time.Minute)
+	if wait := l.Failure("key"); wait != 0 {
+		t.Errorf("expected a forgotten key to get a free failure, but it has to wait %s", wait)
+	}
+
+	l.Failure("stale")
+	now = now.Add(5 * time.Minute)
+	l.Failure("key")
+
+	if _, ok := l.entries["stale"]; ok {
+		t.Error("expected the stale key to be swept")
+	}
+}
@@ -0,0 +1,57 @@
+// Package useragent turns a User-Agent header into a short description of the device, like
+// "Firefox on Linux", for showing users where they are logged in. It only knows the common
+// browsers and systems; anything else is described as well as the parts it recognises allow.
+package useragent
+
+import "strings"
+
+// checked in order, since most browsers also claim to be the ones they are built on
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"Go-http-client/", "Go http client"},
+}
+
+var systems = []struct{ token, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// Describe returns a short description of the device a User-Agent header came from
+func Describe(ua string) string {
+	browser := match(ua, browsers)
+	system := match(ua, systems)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "Unknown browser on " + system
+	default:
+		return "Unknown device"
+	}
+}
+
+func match(ua string, known []struct{ token, name string }) string {
+	for _, k := range known {
+		if strings.Contains(ua, k.token) {
+			return k.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package useragent
+
+import "testing"
+
+func TestDescribe(t *testing.T) {
+	var tests = []struct {
+		ua       string
+		expected string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36 Edg/116.0.1938.62", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.1.2", "curl"},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "Unknown browser on Linux"},
+		{"", "Unknown device"},
+	}
+
+	for _, e := range tests {
+		if got := Describe(e.ua); got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.ua, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"webapp/pkg/data"
+)
+
+const (
+	// MinPasswordLength is the shortest password we accept
+	MinPasswordLength = 8
+	// MaxPasswordLength is the longest, in bytes: bcrypt ignores anything past 72
+	MaxPasswordLength = 72
+	// MaxNameLength is the most characters a first or last name may have
+	MaxNameLength = 100
+	// MaxEmailLength is the longest address SMTP can deliver to
+	MaxEmailLength = 254
+)
+
+// passwords too common to be worth guessing at, however long they are
+var commonPasswords = map[string]bool{
+	"password":   true,
+	"password1":  true,
+	"12345678":   true,
+	"123456789":  true,
+	"qwertyuiop": true,
+	"qwerty123":  true,
+	"iloveyou":   true,
+	"letmein1":   true,
+	"abc12345":   true,
+	"11111111":   true,
+}
+
+// Errors holds what is wrong with a payload, one message per field. Messages are written to
+// follow the field's name, e.g. "must be a valid address", so they read well in an api
+// response and, with the field's label in front, on a web form.
+type Errors map[string]string
+
+// Add records a problem with a field, unless it already has one
+func (e Errors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// Check adds message for field when ok is false
+func (e Errors) Check(ok bool, field, message string) {
+	if !ok {
+		e.Add(field, message)
+	}
+}
+
+// Field adds err's message for field, when there is an error
+func (e Errors) Field(field string, err error) {
+	if err != nil {
+		e.Add(field, err.Error())
+	}
+}
+
+// Valid reports whether no problems were found
+func (e Errors) Valid() bool {
+	return len(e) == 0
+}
+
+// Email checks an email address and returns it normalised: surrounding space trimmed and the
+// domain lower cased. The local part is left alone, since strictly it is case sensitive. Lookups
+// by email and the unique index on it ignore case altogether, so logging in with the address
+// in other capitals still finds the account.
+func Email(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("is required")
+	}
+	if len(value) > MaxEmailLength {
+		return "", fmt.Errorf("must be at most %d characters long", MaxEmailLength)
+	}
+
+	// a bare address only, not "Name <address>" or a list
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return "", errors.New("must be a valid address")
+	}
+
+	at := strings.LastIndexByte(value, '@')
+	domain := value[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errors.New("must be a valid address")
+	}
+
+	return value[:at+1] + strings.ToLower(domain), nil
+}
+
+// Name checks a first or last name and returns it trimmed
+func Name(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("is required")
+	}
+	if utf8.RuneCountInString(value) > MaxNameLength {
+		return "", fmt.Errorf("must be at most %d characters long", MaxNameLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return "", errors.New("must not contain control characters")
+		}
+	}
+
+	return value, nil
+}
+
+// Password checks that a new password is strong enough. The email address, when given, is
+// the account's own, which makes a poor password.
+func Password(value, email string) error {
+	switch {
+	case value == "":
+		return errors.New("is required")
+	case len(value) < MinPasswordLength:
+		return fmt.Errorf("must be at least %d characters long", MinPasswordLength)
+	case len(value) > MaxPasswordLength:
+		return fmt.Errorf("must be at most %d bytes long", MaxPasswordLength)
+	case strings.Count(value, value[:1]) == len(value):
+		return errors.New("must not be one character repeated")
+	case commonPasswords[strings.ToLower(value)]:
+		return errors.New("is too common, please choose another")
+	case email != "" && strings.EqualFold(value, strings.TrimSpace(email)):
+		return errors.New("must not be your email address")
+	}
+
+	return nil
+}
+
+// IsAdmin checks the is_admin flag, which is 0 or 1
+func IsAdmin(value int) error {
+	if value != 0 && value != 1 {
+		return errors.New("must be 0 or 1")
+	}
+	return nil
+}
+
+// User checks the name, email and admin fields of a user, normalising them in place. The
+// password is checked as well when checkPassword is set, for users being created.
+func User(u *data.User, checkPassword bool) Errors {
+	errs := Errors{}
+
+	errs.Field("first_name", normalise(&u.FirstName, Name))
+	errs.Field("last_name", normalise(&u.LastName, Name))
+	errs.Field("email", normalise(&u.Email, Email))
+
+	if checkPassword {
+		errs.Field("password", Password(u.Password, u.Email))
+	}
+
+	errs.Field("is_admin", IsAdmin(u.IsAdmin))
+
+	return errs
+}
+
+// normalise runs check on the value held in field, replacing it with the normalised value
+// when it passes
+func normalise(field *string, check func(string) (string, error)) error {
+	value, err := check(*field)
+	if err != nil {
+		return err
+	}
+	*field = value
+	return nil
+}
@@ -0,0 +1,140 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func TestEmail(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"me@example.com", "me@example.com", true},
+		{"  Me@Example.COM ", "Me@example.com", true},
+		{"", "", false},
+		{"me.example.com", "", false},
+		{"Me <me@example.com>", "", false},
+		{"me@example.com, you@example.com", "", false},
+		{"me@localhost", "", false},
+		{"me@example.", "", false},
+		{strings.Repeat("a", 250) + "@example.com", "", false},
+	}
+
+	for _, e := range tests {
+		got, err := Email(e.value)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid to be %v, but got error %v", e.value, e.valid, err)
+			continue
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.value, e.expected, got)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"Ada", "Ada", true},
+		{"  Zoë ", "Zoë", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"Ada\nLovelace", "", false},
+		{strings.Repeat("é", MaxNameLength), strings.Repeat("é", MaxNameLength), true},
+		{strings.Repeat("é", MaxNameLength+1), "", false},
+	}
+
+	for _, e := range tests {
+		got, err := Name(e.value)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid to be %v, but got error %v", e.value, e.valid, err)
+			continue
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.value, e.expected, got)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		email    string
+		valid    bool
+	}{
+		{"long enough", "correct horse", "", true},
+		{"empty", "", "", false},
+		{"too short", "short", "", false},
+		{"too long for bcrypt", strings.Repeat("ab", 40), "", false},
+		{"one character", "aaaaaaaaaa", "", false},
+		{"common", "Password1", "", false},
+		{"own email", "me@example.com", "ME@example.com", false},
+		{"someone else's email", "me@example.com", "you@example.com", true},
+	}
+
+	for _, e := range tests {
+		err := Password(e.password, e.email)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid to be %v, but got error %v", e.name, e.valid, err)
+		}
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	for value, valid := range map[int]bool{0: true, 1: true, 2: false, -1: false} {
+		if err := IsAdmin(value); (err == nil) != valid {
+			t.Errorf("%d: expected valid to be %v, but got error %v", value, valid, err)
+		}
+	}
+}
+
+func TestUser(t *testing.T) {
+	u := data.User{FirstName: " Ada ", LastName: "Lovelace", Email: "Ada@Example.com", Password: "analytical engine"}
+	if errs := User(&u, true); !errs.Valid() {
+		t.Fatalf("expected a valid user, got %v", errs)
+	}
+	if u.FirstName != "Ada" || u.Email != "Ada@example.com" {
+		t.Errorf("expected the user to be normalised, got %q and %q", u.FirstName, u.Email)
+	}
+
+	u = data.User{FirstName: "", LastName: "Lovelace", Email: "nope", IsAdmin: 3}
+	errs := User(&u, true)
+	for _, field := range []string{"first_name", "email", "password", "is_admin"} {
+		if errs[field] == "" {
+			t.Errorf("expected an error for %s, got %v", field, errs)
+		}
+	}
+	if _, ok := errs["last_name"]; ok {
+		t.Errorf("did not expect an error for last_name, got %q", errs["last_name"])
+	}
+	if u.Email != "nope" {
+		t.Errorf("expected an invalid email to be left alone, got %q", u.Email)
+	}
+
+	// updates don't carry a password
+	u = data.User{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"}
+	if errs := User(&u, false); !errs.Valid() {
+		t.Errorf("expected a valid update without a password, got %v", errs)
+	}
+}
+
+func TestErrors_Add(t *testing.T) {
+	errs := Errors{}
+	errs.Add("email", "first")
+	errs.Add("email", "second")
+	errs.Check(true, "name", "not added")
+
+	if errs["email"] != "first" {
+		t.Errorf("expected the first message to be kept, got %q", errs["email"])
+	}
+	if _, ok := errs["name"]; ok {
+		t.Error("expected a passing check not to add an error")
+	}
+}
@@ -0,0 +1,11 @@
+--
+-- Development data. The schema itself is managed by pkg/migrations, so apply the
+-- migrations first, then load this file:
+--
+--   go run ./cmd/cli migrate up
+--   psql "host=localhost user=postgres dbname=users" -f sql/seed.sql
+--
+
+INSERT INTO public.users (first_name, last_name, email, password, is_admin, verified, created_at, updated_at)
+SELECT 'Admin', 'User', 'admin@example.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 1, true, '2022-08-19 00:00:00', '2022-08-19 00:00:00'
+WHERE NOT EXISTS (SELECT 1 FROM public.users WHERE email = 'admin@example.com');